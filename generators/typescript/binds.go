@@ -0,0 +1,222 @@
+package typescript
+
+import (
+	"guts/parser/nodes"
+	"html"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// elementInterfaces are the DOM interfaces of the elements, as returned by
+// document.createElement. Other elements are HTMLElements.
+var elementInterfaces = map[string]string{
+	"a":        "HTMLAnchorElement",
+	"audio":    "HTMLAudioElement",
+	"button":   "HTMLButtonElement",
+	"canvas":   "HTMLCanvasElement",
+	"details":  "HTMLDetailsElement",
+	"dialog":   "HTMLDialogElement",
+	"div":      "HTMLDivElement",
+	"dl":       "HTMLDListElement",
+	"fieldset": "HTMLFieldSetElement",
+	"form":     "HTMLFormElement",
+	"h1":       "HTMLHeadingElement",
+	"h2":       "HTMLHeadingElement",
+	"h3":       "HTMLHeadingElement",
+	"h4":       "HTMLHeadingElement",
+	"h5":       "HTMLHeadingElement",
+	"h6":       "HTMLHeadingElement",
+	"iframe":   "HTMLIFrameElement",
+	"img":      "HTMLImageElement",
+	"input":    "HTMLInputElement",
+	"label":    "HTMLLabelElement",
+	"li":       "HTMLLIElement",
+	"meter":    "HTMLMeterElement",
+	"ol":       "HTMLOListElement",
+	"option":   "HTMLOptionElement",
+	"output":   "HTMLOutputElement",
+	"p":        "HTMLParagraphElement",
+	"progress": "HTMLProgressElement",
+	"select":   "HTMLSelectElement",
+	"span":     "HTMLSpanElement",
+	"table":    "HTMLTableElement",
+	"tbody":    "HTMLTableSectionElement",
+	"td":       "HTMLTableCellElement",
+	"template": "HTMLTemplateElement",
+	"textarea": "HTMLTextAreaElement",
+	"tfoot":    "HTMLTableSectionElement",
+	"th":       "HTMLTableCellElement",
+	"thead":    "HTMLTableSectionElement",
+	"tr":       "HTMLTableRowElement",
+	"ul":       "HTMLUListElement",
+	"video":    "HTMLVideoElement",
+}
+
+// _identifierRegex matches the names that can be written as JavaScript
+// properties without quotes.
+var _identifierRegex = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// bindHelpers are the helpers of mount.
+var bindHelpers = []helper{
+	{"requiredRef", nil, `const requiredRef = /*ts <T>*/(element/*ts : T | null*/, name/*ts : string*/)/*ts : T*/ => {
+	if (element === null) throw new Error(` + "`missing bind ${name}`" + `);
+	return element;
+};
+`},
+}
+
+// bind is an element with a {bind:name}.
+type bind struct {
+	name string
+	// typ is the DOM interface of the element.
+	typ string
+	// many is set for an element in a loop, which is bound once per
+	// iteration, optional for an element that may not be rendered.
+	many     bool
+	optional bool
+}
+
+// refType returns the type of the property of the bind in the refs
+// interface.
+func (b bind) refType() string {
+	if b.many && strings.Contains(b.typ, " | ") {
+		return "(" + b.typ + ")[]"
+	}
+	if b.many {
+		return b.typ + "[]"
+	}
+	return b.typ
+}
+
+// selector returns the CSS selector of the marker of the bind.
+func (b bind) selector() string {
+	return "[data-bind-id='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(b.name) + "']"
+}
+
+// collectBinds returns the binds of the descendants of n, in document order.
+func collectBinds(n nodes.Node, many, optional bool, binds []bind) []bind {
+	switch n := n.(type) {
+	case nodes.Element:
+		if n.Bind() != "" {
+			binds = addBind(binds, bind{name: n.Bind(), typ: elementInterface(n), many: many, optional: optional && !many})
+		}
+	case nodes.ConditionalBlock:
+		optional = true
+	case nodes.LoopBlock:
+		many = true
+	}
+	for _, child := range descendants(n) {
+		binds = collectBinds(child, many, optional, binds)
+	}
+	return binds
+}
+
+// addBind appends b to binds. A name bound in several branches of a
+// conditional is one ref, of any of the types of the bound elements.
+func addBind(binds []bind, b bind) []bind {
+	for i, other := range binds {
+		if other.name != b.name {
+			continue
+		}
+		if !slices.Contains(strings.Split(other.typ, " | "), b.typ) {
+			binds[i].typ += " | " + b.typ
+		}
+		binds[i].many = other.many || b.many
+		binds[i].optional = !binds[i].many && (other.optional || b.optional)
+		return binds
+	}
+	return append(binds, b)
+}
+
+// elementInterface returns the DOM interface of an element.
+func elementInterface(n nodes.Element) string {
+	if inSVG(n) {
+		if n.Name() == "svg" {
+			return "SVGSVGElement"
+		}
+		return "SVGElement"
+	}
+	if typ, ok := elementInterfaces[n.Name()]; ok {
+		return typ
+	}
+	return "HTMLElement"
+}
+
+// bindMarker returns the attribute marking a bound element in HTML, found by
+// mount.
+func bindMarker(name string) string {
+	return ` data-bind-id="` + html.EscapeString(name) + `"`
+}
+
+// generateRefs writes the interface of the bound elements, followed by a new
+// line.
+func generateRefs(binds []bind, m *module, w io.Writer) {
+	writeString(w, "export interface "+m.refsName+" {\n")
+	for _, b := range binds {
+		if b.optional {
+			writeString(w, "\t"+propertyName(b.name)+"?: "+b.refType()+" | null;\n")
+		} else {
+			writeString(w, "\t"+propertyName(b.name)+": "+b.refType()+";\n")
+		}
+	}
+	writeString(w, "}\n")
+}
+
+// generateMount writes mount, which finds the bound elements in rendered
+// markup and passes them to the mount function of the component script.
+func generateMount(binds []bind, script *componentScript, m *module, w io.Writer) {
+	writeString(w, m.export(m.mountName))
+	writeString(w, m.typed("const "+m.mountName+" = (root/*ts : ParentNode*/)/*ts : "+m.refsName+"*/ => "))
+	indent := "\t"
+	if script != nil && script.mount {
+		writeString(w, "{\n")
+		writeString(w, m.typed("\tconst refs/*ts : "+m.refsName+"*/ = "))
+		indent = "\t\t"
+	} else {
+		writeString(w, "(")
+	}
+	writeString(w, "{\n")
+	for _, b := range binds {
+		var value string
+		switch {
+		case b.many:
+			value = "Array.from(root.querySelectorAll/*ts <" + b.typ + ">*/(" + jsString(b.selector()) + "))"
+		case b.optional:
+			value = "root.querySelector/*ts <" + b.typ + ">*/(" + jsString(b.selector()) + ")"
+		default:
+			value = "requiredRef(root.querySelector/*ts <" + b.typ + ">*/(" + jsString(b.selector()) + "), " + jsString(b.name) + ")"
+		}
+		writeString(w, m.typed(indent+propertyName(b.name)+": "+value+",\n"))
+	}
+	if script == nil || !script.mount {
+		writeString(w, "});")
+		return
+	}
+	arguments := make([]string, len(script.parameters))
+	for i, parameter := range script.parameters {
+		arguments[i] = propertyAccess("refs", parameter)
+	}
+	writeString(w, "\t};\n")
+	writeString(w, "\t"+scriptMountName+"("+strings.Join(arguments, ", ")+");\n")
+	writeString(w, "\treturn refs;\n")
+	writeString(w, "};")
+}
+
+// propertyName returns name as the name of a property of an object literal
+// or an interface.
+func propertyName(name string) string {
+	if _identifierRegex.MatchString(name) {
+		return name
+	}
+	return jsString(name)
+}
+
+// propertyAccess returns the access of the property name of value.
+func propertyAccess(value, name string) string {
+	if _identifierRegex.MatchString(name) {
+		return value + "." + name
+	}
+	return value + "[" + jsString(name) + "]"
+}
@@ -0,0 +1,63 @@
+package typescript
+
+import (
+	"fmt"
+	"guts/parser/nodes"
+	"io"
+	"strings"
+)
+
+// GenerateDeclarations writes the declaration file (.d.ts) of the JavaScript
+// module generated for a document with the same options.
+func GenerateDeclarations(node nodes.Node, w io.Writer, options Options) error {
+	n, ok := node.(nodes.Document)
+	if !ok {
+		return fmt.Errorf("unsupported node type: %T", node)
+	}
+
+	binds := collectBinds(n, false, false, nil)
+	if script, err := collectScript(n, binds); err != nil {
+		return err
+	} else if script != nil {
+		return fmt.Errorf("<script type=\"ts\"> can only be generated as TypeScript")
+	}
+	m := newModule(options)
+	if err := m.checkNames(n); err != nil {
+		return err
+	}
+	generateTypes(n, m.typeName, w)
+	if len(binds) > 0 || options.DOM || options.Update {
+		generateRefs(binds, m, w)
+	}
+	rendered := "string"
+	if options.DOM {
+		rendered = domRenderType(m)
+	}
+	if options.DefaultExport {
+		writeString(w, fmt.Sprintf("declare const %s: (model: %s) => %s;\n", m.renderName, m.typeName, rendered))
+	} else {
+		writeString(w, fmt.Sprintf("export declare const %s: (model: %s) => %s;\n", m.renderName, m.typeName, rendered))
+	}
+
+	if options.Update {
+		writeString(w, fmt.Sprintf("export declare const %s: (model: %s) => %s;\n", m.createName, m.typeName, viewType(m)))
+	}
+	if len(binds) > 0 {
+		writeString(w, fmt.Sprintf("export declare const %s: (root: ParentNode) => %s;\n", m.mountName, m.refsName))
+	}
+	if options.Validators {
+		suffix := strings.ToUpper(m.typeName[:1]) + m.typeName[1:]
+		writeString(w, `export declare class ModelError extends Error {
+	readonly path: string;
+	readonly expected: string;
+	constructor(path: string, expected: string);
+}
+`)
+		writeString(w, fmt.Sprintf("export declare function assert%s(x: unknown): asserts x is %s;\n", suffix, m.typeName))
+		writeString(w, fmt.Sprintf("export declare function validate%s(x: unknown): x is %s;\n", suffix, m.typeName))
+	}
+	if options.DefaultExport {
+		writeString(w, "export default "+m.renderName+";\n")
+	}
+	return nil
+}
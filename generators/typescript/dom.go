@@ -0,0 +1,338 @@
+package typescript
+
+import (
+	"bytes"
+	"encoding/json"
+	"guts/parser/nodes"
+	"guts/parser/nodes/attributes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// svgNamespace is the namespace of svg elements and their descendants, which
+// are not HTML elements.
+const svgNamespace = "http://www.w3.org/2000/svg"
+
+// domRenderType returns the type returned by render in DOM output: the built
+// nodes, and the elements with a {bind:name}.
+func domRenderType(m *module) string {
+	return "{ fragment: DocumentFragment; refs: " + m.refsName + " }"
+}
+
+// domHelpers are the helpers of DOM output. Unlike the escapers, they need a
+// document.
+var domHelpers = []helper{
+	{"setAttributes", []string{"sanitizeUrl", "urlAttributes"}, `const setAttributes = (element/*ts : Element*/, attrs/*ts : Record<string, unknown>*/) => {
+	for (const [name, value] of Object.entries(attrs)) {
+		if (!/^[^\s"'<>/=]+$/.test(name) || /^on/i.test(name)) continue;
+		element.setAttribute(name, urlAttributes.has(name.toLowerCase()) ? sanitizeUrl(value) : String(value ?? ''));
+	}
+};
+`},
+	{"parseHtml", nil, `const parseHtml = (value/*ts : unknown*/) => {
+	const template = document.createElement('template');
+	template.innerHTML = String(value ?? '');
+	return template.content;
+};
+`},
+}
+
+// generateDOMRender writes a render function that builds the nodes of a
+// document with createElement, setAttribute and createTextNode, so outputs
+// never have to be encoded as HTML.
+func generateDOMRender(n nodes.Document, m *module, fields []string, binds []bind, w io.Writer) {
+	b := &domBuilder{binds: map[string]bind{}}
+	var arrays []string
+	for _, bind := range binds {
+		b.binds[bind.name] = bind
+		if bind.many {
+			arrays = append(arrays, propertyName(bind.name)+": []/*ts  as "+bind.refType()+"*/")
+		}
+	}
+	refs := "{}"
+	if len(arrays) > 0 {
+		refs = "{ " + strings.Join(arrays, ", ") + " }"
+	}
+	b.line(1, "const fragment = document.createDocumentFragment();")
+	b.line(1, m.typed("const refs = "+refs+"/*ts  as "+m.refsName+"*/;"))
+	b.children(n, "fragment", 1)
+	b.line(1, "return { fragment, refs };")
+
+	writeString(w, m.exportRender())
+	writeString(w, "const "+m.renderName+" = ({"+strings.Join(fields, ", "))
+	writeString(w, m.typed("}/*ts : "+m.typeName+"*/)/*ts : "+domRenderType(m)+"*/ => {\n"))
+	writeString(w, b.String())
+	writeString(w, "};")
+}
+
+// domBuilder writes the statements of a render function building the DOM.
+type domBuilder struct {
+	strings.Builder
+	vars  int
+	binds map[string]bind
+	// tracked is set while building the nodes patched by the update function
+	// of create, outside of the regions it rebuilds. fields are the names of
+	// the fields of the model and patches the statements of update.
+	tracked bool
+	fields  []string
+	patches []patch
+}
+
+func (b *domBuilder) line(depth int, s string) {
+	b.WriteString(strings.Repeat("\t", depth))
+	b.WriteString(s)
+	b.WriteString("\n")
+}
+
+// children writes the statements appending the children of n to parent.
+func (b *domBuilder) children(n nodes.Node, parent string, depth int) {
+	for _, child := range n.Children() {
+		if b.tracked && b.trackedNode(child, parent, depth) {
+			continue
+		}
+		b.node(child, parent, depth)
+	}
+}
+
+// node writes the statements appending n to parent.
+func (b *domBuilder) node(n nodes.Node, parent string, depth int) {
+	switch n := n.(type) {
+	case nodes.Element:
+		b.element(n, parent, depth)
+	case nodes.OutputBlock:
+		b.line(depth, parent+".append("+domOutput(n)+");")
+	case nodes.ConditionalBlock:
+		b.line(depth, "if ("+expressionString(n)+") {")
+		b.children(n, parent, depth+1)
+		for next := n.Next(); next != nil; next = next.Next() {
+			if next.Condition() != nil {
+				b.line(depth, "} else if ("+expressionString(next)+") {")
+			} else {
+				b.line(depth, "} else {")
+			}
+			b.children(next, parent, depth+1)
+		}
+		b.line(depth, "}")
+	case nodes.LoopBlock:
+		items := n.ItemsKey()
+		if isNullableKey(n, items) {
+			items = "(" + items + " ?? [])"
+		}
+		b.line(depth, "for (const ["+n.IndexKey()+", "+n.ValueKey()+"] of Array.isArray("+items+") ? "+
+			items+".entries() : Object.entries("+items+")) {")
+		b.children(n, parent, depth+1)
+		b.line(depth, "}")
+	case nodes.TextNode:
+		if n.TextContent() != "" {
+			b.line(depth, parent+".append(document.createTextNode("+jsString(n.TextContent())+"));")
+		}
+	}
+}
+
+func (b *domBuilder) element(n nodes.Element, parent string, depth int) {
+	if n.Name() == "!doctype" || isComponentScript(n) {
+		// a fragment has no doctype, scripts are compiled with the module
+		return
+	}
+
+	b.vars++
+	e := "e" + strconv.Itoa(b.vars)
+	if inSVG(n) {
+		b.line(depth, "const "+e+" = document.createElementNS("+jsString(svgNamespace)+", "+jsString(n.Name())+");")
+	} else {
+		b.line(depth, "const "+e+" = document.createElement("+jsString(n.Name())+");")
+	}
+
+	n.Attributes().Iterator()(func(key string, value attributes.AttributeValue) bool {
+		statement := e + ".setAttribute(" + jsString(key) + ", " + domAttributeValue(key, value) + ");"
+		b.line(depth, statement)
+		if property := formProperty(n, key); property != "" {
+			// once edited, a form control no longer follows its attributes
+			statement = e + "." + property + " = " + domPropertyValue(key, value) + ";"
+		}
+		b.trackAttribute(value, statement)
+		return true
+	})
+	if spread := n.Attributes().GetSpreadAttribute(); spread != nil && !spread.IsEmpty() {
+		b.line(depth, "setAttributes("+e+", "+spread.Key()+");")
+		b.trackAttribute(spread, "patchAttributes("+e+", previous."+spread.Key()+", "+spread.Key()+");")
+	}
+	if n.Bind() != "" {
+		// the marker lets mount find the element in markup rendered elsewhere
+		b.line(depth, e+".setAttribute(\"data-bind-id\", "+jsString(n.Bind())+");")
+		if b.binds[n.Bind()].many {
+			b.line(depth, propertyAccess("refs", n.Bind())+".push("+e+");")
+		} else {
+			b.line(depth, propertyAccess("refs", n.Bind())+" = "+e+";")
+		}
+	}
+	b.line(depth, parent+".append("+e+");")
+
+	if n.IsVoid() {
+		return
+	}
+	if n.Name() == "template" {
+		e += ".content"
+	}
+	b.children(n, e, depth)
+}
+
+// inSVG reports whether n is an svg element or one of its descendants.
+func inSVG(n nodes.Node) bool {
+	for ; n != nil; n = n.Parent() {
+		if _, ok := n.(nodes.Element); ok && n.Name() == "svg" {
+			return true
+		}
+	}
+	return false
+}
+
+// domOutput returns the node appended for an output: a text node, or the
+// parsed nodes of {raw ...}.
+func domOutput(n nodes.OutputBlock) string {
+	value, raw := domOutputValue(n)
+	if raw {
+		return "parseHtml(" + value + ")"
+	}
+	return "document.createTextNode(" + value + ")"
+}
+
+// domOutputValue returns the text of an output, or its HTML if raw is set.
+func domOutputValue(n nodes.OutputBlock) (value string, raw bool) {
+	formatter := formatterOf(n, n.Key(), n.ExpressionType())
+	value = n.Key()
+	if n.Expression() != nil {
+		formatter = ""
+		value = "(" + expressionString(n) + ")"
+	}
+	if formatter != "" {
+		value = formatter + "(" + value + ")"
+	}
+
+	switch escaper := textEscaper(n); escaper {
+	case "":
+		return value, true
+	case "htmlEncode":
+		if formatter == "" {
+			// text nodes need no encoding, but never print undefined or null
+			value = "String(" + value + " ?? '')"
+		}
+	default:
+		value = escaper + "(" + value + ")"
+	}
+	return value, false
+}
+
+// domAttributeValue returns the value of an attribute: its static strings
+// and outputs concatenated.
+func domAttributeValue(key string, value attributes.AttributeValue) string {
+	parts := attributeParts(value)
+	if len(parts) == 0 {
+		return "''"
+	}
+	values := make([]string, 0, len(parts))
+	for i, part := range parts {
+		switch part := part.(type) {
+		case attributes.AttributeValueString:
+			values = append(values, jsString(string(part)))
+		case attributes.AttributeValueExpression:
+			helpers := domAttributeEscapers(key, i == 0)
+			if len(helpers) == 0 {
+				values = append(values, "String("+part.Key()+" ?? '')")
+			} else {
+				values = append(values, escape(part.Key(), helpers))
+			}
+		}
+	}
+	return strings.Join(values, " + ")
+}
+
+// formProperty returns the property of the form control n holding the current
+// state of attribute key, or "".
+func formProperty(n nodes.Element, key string) string {
+	switch {
+	case key == "value" && (n.Name() == "input" || n.Name() == "select" || n.Name() == "option" || n.Name() == "textarea"):
+		return "value"
+	case key == "checked" && n.Name() == "input":
+		return "checked"
+	case key == "selected" && n.Name() == "option":
+		return "selected"
+	}
+	return ""
+}
+
+// domPropertyValue returns the value of the property of a form control set
+// from attribute key: the text of value, or whether checked or selected is
+// true. Only an attribute that is a single output can be false.
+func domPropertyValue(key string, value attributes.AttributeValue) string {
+	if key == "value" {
+		return domAttributeValue(key, value)
+	}
+	if parts := attributeParts(value); len(parts) == 1 {
+		if expr, ok := parts[0].(attributes.AttributeValueExpression); ok {
+			return "Boolean(" + expr.Key() + ")"
+		}
+	}
+	return "true"
+}
+
+// domAttributeEscapers returns the helpers applied to an output in the value
+// of attribute name, which needs no HTML encoding when it is set with
+// setAttribute.
+func domAttributeEscapers(name string, first bool) []string {
+	helpers := attributeEscapers(name, first)
+	return helpers[:len(helpers)-1]
+}
+
+func collectDOMHelpers(n nodes.Node, used map[string]bool) {
+	switch n := n.(type) {
+	case nodes.OutputBlock:
+		switch escaper := textEscaper(n); escaper {
+		case "":
+			used["parseHtml"] = true
+		case "htmlEncode":
+		default:
+			used[escaper] = true
+		}
+	case nodes.Element:
+		n.Attributes().Iterator()(func(key string, value attributes.AttributeValue) bool {
+			for i, value := range attributeParts(value) {
+				if _, ok := value.(attributes.AttributeValueExpression); ok {
+					for _, helper := range domAttributeEscapers(key, i == 0) {
+						used[helper] = true
+					}
+				}
+			}
+			return true
+		})
+		if spread := n.Attributes().GetSpreadAttribute(); spread != nil && !spread.IsEmpty() {
+			used["setAttributes"] = true
+		}
+	}
+	for _, child := range descendants(n) {
+		collectDOMHelpers(child, used)
+	}
+}
+
+// expressionString returns the condition of a conditional block or the
+// expression of an output.
+func expressionString(n nodes.Node) string {
+	var buf bytes.Buffer
+	switch n := n.(type) {
+	case nodes.ConditionalBlock:
+		generateBooleanExpression(n.Condition(), &buf)
+	case nodes.OutputBlock:
+		generateBooleanExpression(n.Expression(), &buf)
+	}
+	return buf.String()
+}
+
+// jsString returns s as a JavaScript string literal.
+func jsString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
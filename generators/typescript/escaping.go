@@ -0,0 +1,150 @@
+package typescript
+
+import (
+	"guts/parser/nodes"
+	"guts/parser/nodes/attributes"
+	"sort"
+	"strings"
+)
+
+// urlAttributes are the attributes whose values are URLs. An output at the
+// start of such a value may only use a safe scheme, an output after it is
+// percent-encoded.
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"src":        true,
+	"xlink:href": true,
+}
+
+// escapers are the helpers that escape outputs for the context they are
+// written in, in the order they are declared. An escaper is declared after
+// the escapers it uses. They only work on strings and never touch the DOM, so
+// templates also render on servers and in workers.
+var escapers = []helper{
+	{"htmlEncode", nil, `const htmlEscapes/*ts : Record<string, string>*/ = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' };
+const htmlEncode = (value/*ts : unknown*/) => String(value ?? '').replace(/[&<>"']/g, (c) => htmlEscapes[c]);
+`},
+	{"sanitizeUrl", nil, `const sanitizeUrl = (value/*ts : unknown*/) => {
+	const url = String(value ?? '');
+	return /^(?:(?:https?|mailto|tel):|[^:/?#]*(?:[/?#]|$))/i.test(url) ? url : 'about:invalid#unsafe-url';
+};
+`},
+	{"urlEncode", nil, `const urlEncode = (value/*ts : unknown*/) => encodeURIComponent(String(value ?? ''));
+`},
+	{"scriptJson", nil, `const scriptJson = (value/*ts : unknown*/) => (JSON.stringify(value) ?? 'null')
+	.replace(/[<>&\u2028\u2029]/g, (c) => '\\u' + c.charCodeAt(0).toString(16).padStart(4, '0'));
+`},
+	{"cssEncode", nil, `const cssEncode = (value/*ts : unknown*/) => String(value ?? '')
+	.replace(/[^\w .,#%+-]/gu, (c) => '\\' + c.codePointAt(0)/*ts !*/.toString(16) + ' ');
+`},
+	{"urlAttributes", nil, `const urlAttributes = /*#__PURE__*/ new Set([` + quotedNames(urlAttributes) + `]);
+`},
+	{"spreadAttributes", []string{"htmlEncode", "sanitizeUrl", "urlAttributes"}, `const spreadAttributes = (attrs/*ts : Record<string, unknown>*/) => Object.entries(attrs)
+	.filter(([name]) => /^[^\s"'<>/=]+$/.test(name) && !/^on/i.test(name))
+	.map(([name, value]) => ` + "`${name}=\"${htmlEncode(urlAttributes.has(name.toLowerCase()) ? sanitizeUrl(value) : value)}\"`" + `)
+	.join(' ');
+`},
+}
+
+func quotedNames(names map[string]bool) string {
+	quoted := make([]string, 0, len(names))
+	for name := range names {
+		quoted = append(quoted, "'"+name+"'")
+	}
+	sort.Strings(quoted)
+	return strings.Join(quoted, ", ")
+}
+
+// textEscaper returns the helper escaping an output for the element it is
+// written in, or an empty string for {raw ...}.
+func textEscaper(n nodes.OutputBlock) string {
+	if n.Raw() {
+		return ""
+	}
+	parent := n.Parent()
+	for parent != nil {
+		if _, ok := parent.(nodes.Element); ok {
+			break
+		}
+		parent = parent.Parent()
+	}
+	if parent != nil {
+		switch parent.Name() {
+		case "script":
+			return "scriptJson"
+		case "style":
+			return "cssEncode"
+		}
+	}
+	return "htmlEncode"
+}
+
+// attributeEscapers returns the helpers applied, innermost first, to an
+// output in the value of attribute name. first is set for an output at the
+// start of the value.
+func attributeEscapers(name string, first bool) []string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return []string{"scriptJson", "htmlEncode"}
+	case name == "style":
+		return []string{"cssEncode", "htmlEncode"}
+	case urlAttributes[name] && first:
+		return []string{"sanitizeUrl", "htmlEncode"}
+	case urlAttributes[name]:
+		return []string{"urlEncode", "htmlEncode"}
+	}
+	return []string{"htmlEncode"}
+}
+
+// escape applies helpers, innermost first, to expression.
+func escape(expression string, helpers []string) string {
+	for _, helper := range helpers {
+		expression = helper + "(" + expression + ")"
+	}
+	return expression
+}
+
+func collectEscapers(n nodes.Node, used map[string]bool) {
+	switch n := n.(type) {
+	case nodes.OutputBlock:
+		used[textEscaper(n)] = true
+	case nodes.Element:
+		n.Attributes().Iterator()(func(key string, value attributes.AttributeValue) bool {
+			for i, value := range attributeParts(value) {
+				if _, ok := value.(attributes.AttributeValueExpression); ok {
+					for _, helper := range attributeEscapers(key, i == 0) {
+						used[helper] = true
+					}
+				}
+			}
+			return true
+		})
+		if spread := n.Attributes().GetSpreadAttribute(); spread != nil && !spread.IsEmpty() {
+			used["spreadAttributes"] = true
+		}
+	}
+	for _, child := range descendants(n) {
+		collectEscapers(child, used)
+	}
+}
+
+// attributeParts returns the static strings and outputs of an attribute
+// value.
+func attributeParts(value attributes.AttributeValue) []attributes.AttributeValue {
+	if composite, ok := value.(attributes.AttributeValueComposite); ok {
+		return composite.Values()
+	}
+	if value == nil {
+		return nil
+	}
+	return []attributes.AttributeValue{value}
+}
@@ -0,0 +1,135 @@
+package typescript
+
+import (
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"strings"
+)
+
+// formatters are the helpers that render values of the types represented as
+// strings in text. Attributes keep the machine-readable values. Decimals are
+// only regrouped with the separators of the locale, never converted to
+// numbers, so money is rendered exactly as it is given.
+var formatters = map[expressions.ExpressionBaseType]helper{
+	expressions.ExpressionBaseTypeDate: {"formatDate", nil, `const formatDate = (value/*ts : string | null | undefined*/) => {
+	if (value == null) return '';
+	return new Intl.DateTimeFormat(undefined, { dateStyle: 'medium', timeZone: 'UTC' }).format(new Date(value));
+};
+`},
+	expressions.ExpressionBaseTypeDateTime: {"formatDateTime", nil, `const formatDateTime = (value/*ts : Date | string | null | undefined*/) => {
+	if (value == null) return '';
+	return new Intl.DateTimeFormat(undefined, { dateStyle: 'medium', timeStyle: 'short' }).format(new Date(value));
+};
+`},
+	expressions.ExpressionBaseTypeDuration: {"formatDuration", nil, `const formatDuration = (value/*ts : string | null | undefined*/) => {
+	if (value == null) return '';
+	const match = /^(-?)P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$/.exec(value);
+	if (!match) return value;
+	const parts = [[match[2], 'y'], [match[3], 'mo'], [match[4], 'w'], [match[5], 'd'], [match[6], 'h'], [match[7], 'm'], [match[8], 's']]
+		.filter(([amount]) => amount && Number(amount) !== 0)
+		.map(([amount, unit]) => amount + unit);
+	return parts.length ? match[1] + parts.join(' ') : '0s';
+};
+`},
+	expressions.ExpressionBaseTypeDecimal: {"formatDecimal", nil, `const formatDecimal = (value/*ts : string | null | undefined*/) => {
+	if (value == null) return '';
+	const match = /^(-?)(\d+)(?:\.(\d+))?$/.exec(value);
+	if (!match) return value;
+	const parts = new Intl.NumberFormat().formatToParts(1234567.5);
+	const group = parts.find((part) => part.type === 'group')?.value ?? '';
+	const decimal = parts.find((part) => part.type === 'decimal')?.value ?? '.';
+	return match[1] + match[2].replace(/\B(?=(\d{3})+(?!\d))/g, group) + (match[3] === undefined ? '' : decimal + match[3]);
+};
+`},
+}
+
+// formatterOf returns the helper that formats the value of key, used in n,
+// or an empty string if the value is printed as it is.
+func formatterOf(n nodes.Node, key string, annotation expressions.ExpressionType) string {
+	typ := annotation
+	if typ == nil {
+		typ = typeOf(n, key)
+	}
+	if typ == nil {
+		return ""
+	}
+	return formatters[typ.BaseType()].name
+}
+
+// formattedTypes are the types with formatters, in the order the formatters
+// are declared.
+var formattedTypes = []expressions.ExpressionBaseType{
+	expressions.ExpressionBaseTypeDate,
+	expressions.ExpressionBaseTypeDateTime,
+	expressions.ExpressionBaseTypeDuration,
+	expressions.ExpressionBaseTypeDecimal,
+}
+
+func collectFormatters(n nodes.Node, used map[string]bool) {
+	if output, ok := n.(nodes.OutputBlock); ok && output.Expression() == nil {
+		used[formatterOf(output, output.Key(), output.ExpressionType())] = true
+	}
+	for _, child := range descendants(n) {
+		collectFormatters(child, used)
+	}
+}
+
+// typeOf returns the type of key, a variable or member path such as
+// order.total used in n, or nil if it is not known.
+func typeOf(n nodes.Node, key string) expressions.ExpressionType {
+	document := documentOf(n)
+	key = strings.ReplaceAll(strings.TrimSpace(key), "?", "")
+	if document == nil || key == "" || strings.ContainsAny(key, "[( ") {
+		return nil
+	}
+
+	path := strings.Split(key, ".")
+	typ := variableType(n, path[0], document)
+	for _, name := range path[1:] {
+		typ = resolveType(document, typ)
+		if typ == nil {
+			return nil
+		}
+		switch typ.BaseType() {
+		case expressions.ExpressionBaseTypeObject:
+			typ, _ = typ.Field(name)
+		case expressions.ExpressionBaseTypeMap:
+			typ = typ.ValueType()
+		default:
+			return nil
+		}
+	}
+	return resolveType(document, typ)
+}
+
+// variableType returns the type of a loop variable or a variable of the
+// model.
+func variableType(n nodes.Node, name string, document nodes.Document) expressions.ExpressionType {
+	for parent := n.Parent(); parent != nil; parent = parent.Parent() {
+		loop, ok := parent.(nodes.LoopBlock)
+		if !ok || (name != loop.IndexKey() && name != loop.ValueKey()) {
+			continue
+		}
+		collection := loop.ExpressionType()
+		if collection == nil {
+			collection = typeOf(loop, loop.ItemsKey())
+		}
+		collection = resolveType(document, collection)
+		if collection == nil || collection.KeyType() == nil {
+			return nil
+		}
+		if name == loop.IndexKey() {
+			return collection.KeyType()
+		}
+		return collection.ValueType()
+	}
+	return document.GetDeclaredTypes()[name]
+}
+
+// resolveType returns the declaration of a named type, or typ itself.
+func resolveType(document nodes.Document, typ expressions.ExpressionType) expressions.ExpressionType {
+	if typ == nil || typ.Name() == "" {
+		return typ
+	}
+	return document.GetNamedTypes()[typ.Name()]
+}
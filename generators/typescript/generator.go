@@ -1,18 +1,63 @@
 package typescript
 
 import (
+	"encoding/json"
 	"fmt"
 	"guts/parser/expressions"
 	"guts/parser/nodes"
 	"guts/parser/nodes/attributes"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// Options configure the module generated for a document.
+type Options struct {
+	// Validators adds assertModel and validateModel, which check data of
+	// unknown origin, such as a parsed JSON response, against the model.
+	Validators bool
+	// Name is the base of the exported names: with OrderSummary, the module
+	// exports OrderSummaryModel and renderOrderSummary instead of model and
+	// render, so the modules of many templates can be bundled together.
+	Name string
+	// DefaultExport makes the render function the default export.
+	DefaultExport bool
+	// Module is the module system of JavaScript output, ESM by default.
+	// TypeScript is always written as ESM and compiled as configured.
+	Module ModuleFormat
+	// JavaScript writes JavaScript instead of TypeScript. The types are
+	// written separately by GenerateDeclarations.
+	JavaScript bool
+	// Runtime is the module the helpers are imported from, e.g.
+	// ./guts-runtime, written by GenerateRuntime. Without it, every module
+	// declares the helpers it uses.
+	Runtime string
+	// DOM builds the nodes of the document with createElement instead of
+	// rendering HTML: render returns a DocumentFragment and the elements with
+	// a {bind:name}.
+	DOM bool
+	// Update adds create, which builds the nodes of the document like DOM
+	// output and returns them with update. update applies a new model to the
+	// nodes without rebuilding them, so focus, selection and listeners are
+	// kept: it patches the text nodes and attributes depending on the fields
+	// that changed and rebuilds the conditional and loop blocks depending on
+	// them. The rows of a keyed loop, {for i, item in items key item.id},
+	// are matched by key, so the rows of kept items are moved instead of
+	// rebuilt. Fields are compared by identity, so objects and arrays must be
+	// replaced rather than modified.
+	Update bool
+}
+
 func Generate(node nodes.Node, w io.Writer) error {
+	return GenerateWithOptions(node, w, Options{})
+}
+
+// GenerateWithOptions is like Generate with options for the generated module.
+func GenerateWithOptions(node nodes.Node, w io.Writer, options Options) error {
 	switch n := node.(type) {
 	case nodes.Document:
-		return generateDocument(n, w)
+		return generateDocument(n, w, options)
 	case nodes.Element:
 		return generateElement(n, w)
 	case nodes.LoopBlock:
@@ -28,59 +73,141 @@ func Generate(node nodes.Node, w io.Writer) error {
 	}
 }
 
+// templateLiteralEscaper escapes static content written into a template
+// literal, which would otherwise end the literal or start an expression. Every
+// $ is escaped, as static parts may be split right before a {.
+var templateLiteralEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "$", "\\$")
+
 func writeString(w io.Writer, s string) error {
 	w.Write([]byte(s))
 	return nil
 }
 
-func generateDocument(n nodes.Document, w io.Writer) error {
-	generateHelpers(w)
-
-	typeName := "model"
-	renderFuncName := "render"
-	generateType(typeName, n.GetDeclaredTypes(), w)
+func generateDocument(n nodes.Document, w io.Writer, options Options) error {
+	if options.Module == CommonJS && !options.JavaScript {
+		return fmt.Errorf("only JavaScript can be generated as a CommonJS module")
+	}
+	binds := collectBinds(n, false, false, nil)
+	script, err := collectScript(n, binds)
+	if err != nil {
+		return err
+	}
+	if script != nil && options.JavaScript {
+		return fmt.Errorf("<script type=\"ts\"> can only be generated as TypeScript")
+	}
+	m := newModule(options)
+	if err := m.checkNames(n); err != nil {
+		return err
+	}
+	if options.Module == CommonJS {
+		writeString(w, "'use strict';\nObject.defineProperty(exports, '__esModule', { value: true });\n")
+	}
+	used := usedHelpers(n, options)
+	if options.Runtime != "" {
+		generateImport(used, m, w)
+	} else {
+		used = withDependencies(used)
+		writeHelpers(escapers, used, m, w)
+		writeHelpers(domHelpers, used, m, w)
+		writeHelpers(updateHelpers, used, m, w)
+		writeHelpers(bindHelpers, used, m, w)
+		writeHelpers(formatterHelpers(), used, m, w)
+	}
+	mount := len(binds) > 0 || script != nil && script.mount
+	if !options.JavaScript {
+		generateTypes(n, m.typeName, w)
+		if mount || options.DOM || options.Update {
+			generateRefs(binds, m, w)
+		}
+	}
+	if script != nil {
+		writeString(w, script.source)
+		writeString(w, "\n")
+	}
 
 	var fields []string
-	for name := range n.GetDeclaredTypes() {
-		fields = append(fields, name)
+	if n.Strict() {
+		for _, prop := range n.Props() {
+			field := prop.Name
+			if prop.HasDefault {
+				value, _ := json.Marshal(prop.Default)
+				field += " = " + string(value)
+			}
+			fields = append(fields, field)
+		}
+	} else {
+		fields = sortedNames(n.GetDeclaredTypes())
 	}
 
-	writeString(w, "\nexport const ")
-	writeString(w, renderFuncName)
-	writeString(w, " = ({")
-	writeString(w, strings.Join(fields, ", "))
-	writeString(w, "}: ")
-	writeString(w, typeName)
-	writeString(w, ") => (`")
+	if options.DOM {
+		generateDOMRender(n, m, fields, binds, w)
+	} else {
+		writeString(w, m.exportRender())
+		writeString(w, "const ")
+		writeString(w, m.renderName)
+		writeString(w, " = ({")
+		writeString(w, strings.Join(fields, ", "))
+		writeString(w, m.typed("}/*ts : "+m.typeName+"*/) => (`"))
 
-	for _, child := range n.Children() {
-		Generate(child, w)
+		for _, child := range n.Children() {
+			Generate(child, w)
+		}
+		writeString(w, "`);")
+	}
+	if options.Update {
+		writeString(w, "\n")
+		generateCreate(n, m, fields, binds, w)
+	}
+	if mount {
+		writeString(w, "\n")
+		generateMount(binds, script, m, w)
+	}
+
+	if options.Validators {
+		writeString(w, "\n")
+		generateValidators(n, m, used, w)
+	}
+	if trailer := m.trailer(); trailer != "" {
+		writeString(w, "\n")
+		writeString(w, trailer)
 	}
-	writeString(w, "`);")
 	return nil
 }
 
-func generateHelpers(w io.Writer) {
-	writeString(w, `const encoder = document.createElement('div');
-const htmlEncode = (value: string) => {
-	encoder.textContent = value;
-	return encoder.innerHTML;
-};
-`)
+// generateTypes writes the named types and the model of a document, each
+// followed by a new line.
+func generateTypes(n nodes.Document, typeName string, w io.Writer) {
+	namedTypes := n.GetNamedTypes()
+	for _, name := range sortedNames(namedTypes) {
+		generateType(name, namedTypes[name].Fields(), w)
+		writeString(w, "\n")
+	}
+	if n.Strict() {
+		generateProps(typeName, n.Props(), w)
+	} else {
+		generateType(typeName, modelFields(n), w)
+	}
+	writeString(w, "\n")
 }
 
 func generateElement(n nodes.Element, w io.Writer) error {
+	if isComponentScript(n) {
+		// compiled with the module
+		return nil
+	}
 	writeString(w, "<")
 	writeString(w, n.Name())
 
 	n.Attributes().Iterator()(func(key string, value attributes.AttributeValue) bool {
 		writeString(w, " ")
-		writeString(w, key)
+		writeString(w, templateLiteralEscaper.Replace(key))
 
 		if value != nil && !value.IsEmpty() {
-			writeString(w, "=\"${htmlEncode(`")
-			generateAttributeValue(value, w)
-			writeString(w, "`)}\"")
+			writeString(w, "=\"")
+			for i, part := range attributeParts(value) {
+				generateAttributeValue(n, part, attributeEscapers(key, i == 0), w)
+			}
+			writeString(w, "\"")
 		}
 
 		return true
@@ -88,10 +215,13 @@ func generateElement(n nodes.Element, w io.Writer) error {
 
 	spread := n.Attributes().GetSpreadAttribute()
 	if spread != nil && !spread.IsEmpty() {
-		writeString(w, " ")
-		writeString(w, "${[...Object.entries(")
+		writeString(w, " ${spreadAttributes(")
 		writeString(w, spread.Key())
-		writeString(w, ")].map(([k, v]) => (`${k}=\"${htmlEncode(v)}\"`)).join(' ')}")
+		writeString(w, ")}")
+	}
+
+	if n.Bind() != "" {
+		writeString(w, templateLiteralEscaper.Replace(bindMarker(n.Bind())))
 	}
 
 	writeString(w, ">")
@@ -111,17 +241,22 @@ func generateElement(n nodes.Element, w io.Writer) error {
 }
 
 func generateText(n nodes.TextNode, w io.Writer) error {
-	writeString(w, n.TextContent())
+	writeString(w, templateLiteralEscaper.Replace(n.TextContent()))
 	return nil
 }
 
 func generateLoopBlock(n nodes.LoopBlock, w io.Writer) error {
+	items := n.ItemsKey()
+	if isNullableKey(n, items) {
+		// looping over a missing collection renders nothing
+		items = "(" + items + " ?? [])"
+	}
 	writeString(w, "${[...(Array.isArray(")
-	writeString(w, n.ItemsKey())
+	writeString(w, items)
 	writeString(w, ") ? ")
-	writeString(w, n.ItemsKey())
+	writeString(w, items)
 	writeString(w, ".entries() : Object.entries(")
-	writeString(w, n.ItemsKey())
+	writeString(w, items)
 	writeString(w, "))].map(([")
 	writeString(w, n.IndexKey())
 	writeString(w, ", ")
@@ -136,12 +271,94 @@ func generateLoopBlock(n nodes.LoopBlock, w io.Writer) error {
 }
 
 func generateOutputBlock(n nodes.OutputBlock, w io.Writer) error {
+	formatter := formatterOf(n, n.Key(), n.ExpressionType())
+	if n.Expression() != nil {
+		formatter = ""
+	}
+	if escaper := textEscaper(n); escaper != "" {
+		// escapers accept null and undefined
+		writeString(w, "${"+escaper+"(")
+		if formatter != "" {
+			writeString(w, formatter+"("+n.Key()+")")
+		} else if expr := n.Expression(); expr != nil {
+			generateBooleanExpression(expr, w)
+		} else {
+			writeString(w, n.Key())
+		}
+		writeString(w, ")}")
+		return nil
+	}
+	if formatter != "" {
+		// formatters print null as an empty string
+		writeString(w, "${"+formatter+"("+n.Key()+")}")
+		return nil
+	}
 	writeString(w, "${")
-	writeString(w, n.Key())
+	if expr := n.Expression(); expr != nil {
+		if expressions.Ungroup(expr).Operator() != expressions.Coalesce {
+			writeString(w, "(")
+			generateBooleanExpression(expr, w)
+			writeString(w, ")")
+		} else {
+			generateBooleanExpression(expr, w)
+		}
+	} else {
+		writeString(w, n.Key())
+	}
+	if isNullableOutput(n) {
+		// never print undefined or null
+		writeString(w, " ?? ''")
+	}
 	writeString(w, "}")
 	return nil
 }
 
+func isNullableOutput(n nodes.OutputBlock) bool {
+	if expr := n.Expression(); expr != nil {
+		return expressions.Ungroup(expr).Operator() == expressions.Coalesce
+	}
+	if n.ExpressionType() != nil && n.ExpressionType().Optional() {
+		return true
+	}
+	return isNullableKey(n, n.Key())
+}
+
+// isNullableKey reports whether the value of key, used in n, may be null.
+func isNullableKey(n nodes.Node, key string) bool {
+	if strings.Contains(key, "?") {
+		return true
+	}
+
+	document := documentOf(n)
+	if document == nil {
+		return false
+	}
+	typ, ok := document.GetDeclaredTypes()[key]
+	return ok && typ.Optional()
+}
+
+// descendants returns the children of n and, for a conditional block, the
+// children of its else branches, which are not children of any node.
+func descendants(n nodes.Node) []nodes.Node {
+	children := n.Children()
+	if conditional, ok := n.(nodes.ConditionalBlock); ok {
+		for next := conditional.Next(); next != nil; next = next.Next() {
+			children = append(children[:len(children):len(children)], next.Children()...)
+		}
+	}
+	return children
+}
+
+func documentOf(n nodes.Node) nodes.Document {
+	for n != nil {
+		if document, ok := n.(nodes.Document); ok {
+			return document
+		}
+		n = n.Parent()
+	}
+	return nil
+}
+
 func generateConditionalBlock(n nodes.ConditionalBlock, w io.Writer) error {
 	writeString(w, "${(")
 	generateBooleanExpression(n.Condition(), w)
@@ -174,33 +391,46 @@ func generateBooleanExpression(n expressions.BooleanExpression, w io.Writer) err
 	}
 	if n.Parentheses() {
 		writeString(w, "(")
+		generateBooleanExpression(n.Inner(), w)
+		writeString(w, ")")
+		return nil
 	}
 	if n.Left() != nil {
-		generateBooleanExpression(n.Left(), w)
+		generateOperand(n.Operator(), n.Left(), w)
 		writeString(w, " ")
 	}
 	writeString(w, string(n.Operator()))
 	if n.Right() != nil {
 		writeString(w, " ")
-		generateBooleanExpression(n.Right(), w)
+		generateOperand(n.Operator(), n.Right(), w)
 	}
-	if n.Parentheses() {
+	return nil
+}
+
+// generateOperand writes an operand of op. TypeScript does not allow ?? to be
+// mixed with && or || without parentheses.
+func generateOperand(op expressions.BooleanOperator, operand expressions.BooleanExpression, w io.Writer) error {
+	mixed := op == expressions.Coalesce && !operand.Parentheses() &&
+		(operand.Operator() == expressions.LogicalAnd || operand.Operator() == expressions.LogicalOr)
+	if mixed {
+		writeString(w, "(")
+	}
+	generateBooleanExpression(operand, w)
+	if mixed {
 		writeString(w, ")")
 	}
 	return nil
 }
 
-func generateAttributeValue(value attributes.AttributeValue, w io.Writer) error {
+// generateAttributeValue writes a static string or an output of an attribute
+// value, escaped by helpers.
+func generateAttributeValue(n nodes.Element, value attributes.AttributeValue, helpers []string, w io.Writer) error {
 	switch v := value.(type) {
 	case attributes.AttributeValueString:
-		writeString(w, string(v))
-	case attributes.AttributeValueComposite:
-		for _, value := range v.Values() {
-			generateAttributeValue(value, w)
-		}
+		writeString(w, templateLiteralEscaper.Replace(string(v)))
 	case attributes.AttributeValueExpression:
 		writeString(w, "${")
-		writeString(w, v.Key())
+		writeString(w, escape(v.Key(), helpers))
 		writeString(w, "}")
 	default:
 		return fmt.Errorf("unsupported attribute value type: %T", v)
@@ -208,28 +438,108 @@ func generateAttributeValue(value attributes.AttributeValue, w io.Writer) error
 	return nil
 }
 
-func generateType(name string, t map[string]expressions.ExpressionType, w io.Writer) error {
+func generateType(name string, fields []expressions.ObjectField, w io.Writer) error {
+	writeString(w, "export interface ")
+	writeString(w, name)
+	writeString(w, " {\n")
+	for _, field := range fields {
+		writeString(w, "\t")
+		writeString(w, field.Name)
+		writeString(w, getTypeScriptField(field.Type))
+		writeString(w, ";\n")
+	}
+	writeString(w, "}")
+	return nil
+}
+
+// generateProps writes the model of a template with a props block. Props
+// with a default value may be omitted.
+func generateProps(name string, props []expressions.Prop, w io.Writer) error {
 	writeString(w, "export interface ")
 	writeString(w, name)
 	writeString(w, " {\n")
-	for name, t := range t {
+	for _, prop := range props {
 		writeString(w, "\t")
-		writeString(w, name)
-		writeString(w, ": ")
-		writeString(w, getTypeScriptType(t))
+		writeString(w, prop.Name)
+		if prop.HasDefault && !prop.Type.Optional() {
+			writeString(w, "?: ")
+			writeString(w, getTypeScriptType(prop.Type))
+		} else {
+			writeString(w, getTypeScriptField(prop.Type))
+		}
 		writeString(w, ";\n")
 	}
 	writeString(w, "}")
 	return nil
 }
 
+// getTypeScriptField returns the type annotation of a property of type t.
+func getTypeScriptField(t expressions.ExpressionType) string {
+	if t.Optional() {
+		return "?: " + getTypeScriptType(t) + " | null"
+	}
+	return ": " + getTypeScriptType(t)
+}
+
+func sortedNames(t map[string]expressions.ExpressionType) []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getTypeScriptValueType returns the type of an array element or map value,
+// which includes null if it is optional.
+func getTypeScriptValueType(t expressions.ExpressionType) string {
+	if t.Optional() {
+		return getTypeScriptType(t) + " | null"
+	}
+	return getTypeScriptType(t)
+}
+
 func getTypeScriptType(t expressions.ExpressionType) string {
+	if literal, ok := t.Literal(); ok {
+		return strconv.Quote(literal)
+	}
+
 	if t.BaseType() == expressions.ExpressionBaseTypeArray {
-		return getTypeScriptBaseType(t.ValueType()) + "[]"
+		switch t.ValueType().BaseType() {
+		case expressions.ExpressionBaseTypeUnion, expressions.ExpressionBaseTypeDateTime:
+			return "(" + getTypeScriptValueType(t.ValueType()) + ")[]"
+		}
+		if t.ValueType().Optional() {
+			return "(" + getTypeScriptValueType(t.ValueType()) + ")[]"
+		}
+		return getTypeScriptType(t.ValueType()) + "[]"
+	}
+
+	if t.BaseType() == expressions.ExpressionBaseTypeUnion {
+		members := make([]string, len(t.Members()))
+		for i, member := range t.Members() {
+			members[i] = getTypeScriptType(member)
+		}
+		return strings.Join(members, " | ")
 	}
 
 	if t.BaseType() == expressions.ExpressionBaseTypeMap {
-		return "Record<" + getTypeScriptBaseType(t.KeyType()) + "," + getTypeScriptBaseType(t.ValueType()) + ">"
+		return "Record<" + getTypeScriptType(t.KeyType()) + "," + getTypeScriptValueType(t.ValueType()) + ">"
+	}
+
+	if t.Name() != "" {
+		return t.Name()
+	}
+
+	if t.BaseType() == expressions.ExpressionBaseTypeObject {
+		if len(t.Fields()) == 0 {
+			return "{}"
+		}
+		fields := make([]string, len(t.Fields()))
+		for i, field := range t.Fields() {
+			fields[i] = field.Name + getTypeScriptField(field.Type)
+		}
+		return "{ " + strings.Join(fields, "; ") + " }"
 	}
 
 	return getTypeScriptBaseType(t.BaseType())
@@ -245,6 +555,13 @@ func getTypeScriptBaseType(t expressions.ExpressionBaseType) string {
 		return "number"
 	case expressions.ExpressionBaseTypeBool:
 		return "boolean"
+	case expressions.ExpressionBaseTypeUnknown:
+		return "unknown"
+	case expressions.ExpressionBaseTypeDate, expressions.ExpressionBaseTypeDuration, expressions.ExpressionBaseTypeDecimal:
+		// ISO 8601 and decimal strings, as they are encoded in JSON
+		return "string"
+	case expressions.ExpressionBaseTypeDateTime:
+		return "Date | string"
 	}
 	return ""
 }
@@ -3,6 +3,7 @@ package typescript
 import (
 	"bytes"
 	"guts/parser"
+	"io"
 	"strings"
 	"testing"
 
@@ -23,17 +24,14 @@ func TestGenerate(t *testing.T) {
 				{/for}
 			</ul>`,
 			expected: strings.Join([]string{
-				"const encoder = document.createElement('div');",
-				"const htmlEncode = (value: string) => {",
-				"	encoder.textContent = value;",
-				"	return encoder.innerHTML;",
-				"};",
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
 				"export interface model {",
 				"	items: string[];",
 				"}",
 				"export const render = ({items}: model) => (`<ul>",
 				"				${[...(Array.isArray(items) ? items.entries() : Object.entries(items))].map(([i, item]) => (`",
-				"					<li data-index=\"${htmlEncode(`${i}`)}\">${item}</li>",
+				"					<li data-index=\"${htmlEncode(i)}\">${htmlEncode(item)}</li>",
 				"				`)).join('')}",
 				"			</ul>`);",
 			}, "\n"),
@@ -50,42 +48,301 @@ func TestGenerate(t *testing.T) {
 				{/if}
 			</div>`,
 			expected: strings.Join([]string{
-				"const encoder = document.createElement('div');",
-				"const htmlEncode = (value: string) => {",
-				"	encoder.textContent = value;",
-				"	return encoder.innerHTML;",
-				"};",
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
 				"export interface model {",
 				"	qty: number;",
 				"}",
 				"export const render = ({qty}: model) => (`<div>",
 				"				${(qty == 1) && (`",
-				"					You have ${qty} item.",
+				"					You have ${htmlEncode(qty)} item.",
 				"				`) || (qty > 1000) && (`",
 				"					You have way too many items.",
 				"				`) || (`",
-				"					You have ${qty} items.",
+				"					You have ${htmlEncode(qty)} items.",
 				"				`) || ''}",
 				"			</div>`);",
 			}, "\n"),
+		}, {
+			name:     "unannotated variables",
+			template: `{if qty > 1}<p title={title}>You have {qty} items.</p>{/if}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface model {",
+				"	qty: number;",
+				"	title: string;",
+				"}",
+				"export const render = ({qty, title}: model) => (`${(qty > 1) && (`<p title=\"${htmlEncode(title)}\">You have ${htmlEncode(qty)} items.</p>`) || ''}`);",
+			}, "\n"),
+		}, {
+			name:     "null-safe outputs",
+			template: `<p title={nickname ?? name}>{nickname ?? name: string}</p><p>{user?.name}</p>{if (count ?? 0) > 1 || (ready: bool ?? false)}<p>{count: int}</p>{/if}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface model {",
+				"	count?: number | null;",
+				"	name: string;",
+				"	nickname?: string | null;",
+				"	ready?: boolean | null;",
+				"	user?: Record<string,unknown> | null;",
+				"}",
+				"export const render = ({count, name, nickname, ready, user}: model) => (`<p title=\"${htmlEncode(nickname ?? name)}\">${htmlEncode(nickname ?? name)}</p><p>${htmlEncode(user?.name)}</p>${((count ?? 0) > 1 || (ready ?? false)) && (`<p>${htmlEncode(count)}</p>`) || ''}`);",
+			}, "\n"),
+		}, {
+			name:     "nested loops",
+			template: `{for r, row in rows: map[string,int[]][]}{for c, cell in row}<td data-col={c}>{cell}</td>{/for}{/for}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface model {",
+				"	rows: Record<string,number[]>[];",
+				"}",
+				"export const render = ({rows}: model) => (`${[...(Array.isArray(rows) ? rows.entries() : Object.entries(rows))].map(([r, row]) => (`${[...(Array.isArray(row) ? row.entries() : Object.entries(row))].map(([c, cell]) => (`<td data-col=\"${htmlEncode(c)}\">${htmlEncode(cell)}</td>`)).join('')}`)).join('')}`);",
+			}, "\n"),
+		}, {
+			name: "named types",
+			template: `{type LineItem = {sku: string, qty: int, options: {gift: bool}}}{type Order = {lines: LineItem[], notes: map[string,string]}}` +
+				`{for i, line in lines: LineItem[]}{line.sku}{/for}{order.notes.gift}{order: Order}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface LineItem {",
+				"	sku: string;",
+				"	qty: number;",
+				"	options: { gift: boolean };",
+				"}",
+				"export interface Order {",
+				"	lines: LineItem[];",
+				"	notes: Record<string,string>;",
+				"}",
+				"export interface model {",
+				"	lines: LineItem[];",
+				"	order: Order;",
+				"}",
+				"export const render = ({lines, order}: model) => (`${[...(Array.isArray(lines) ? lines.entries() : Object.entries(lines))].map(([i, line]) => (`${htmlEncode(line.sku)}`)).join('')}${htmlEncode(order.notes.gift)}${htmlEncode(order)}`);",
+			}, "\n"),
+		}, {
+			name:     "optional types",
+			template: `{type User = {name: string, nickname: string?}}{if users}{for i, user in users: User[]?}<p title={user.nickname ?? user.name}>{user.name}</p>{/for}{/if}{for i, tag in tags: string[] | null}{tag}{/for}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface User {",
+				"	name: string;",
+				"	nickname?: string | null;",
+				"}",
+				"export interface model {",
+				"	tags?: string[] | null;",
+				"	users?: User[] | null;",
+				"}",
+				"export const render = ({tags, users}: model) => (`${(users) && (`${[...(Array.isArray((users ?? [])) ? (users ?? []).entries() : Object.entries((users ?? [])))].map(([i, user]) => (`<p title=\"${htmlEncode(user.nickname ?? user.name)}\">${htmlEncode(user.name)}</p>`)).join('')}`) || ''}${[...(Array.isArray((tags ?? [])) ? (tags ?? []).entries() : Object.entries((tags ?? [])))].map(([i, tag]) => (`${htmlEncode(tag)}`)).join('')}`);",
+			}, "\n"),
+		}, {
+			name:     "union types",
+			template: `{type Order = {status: "pending" | "shipped", sizes: ("s" | "m" | null)[], tags: map[string,string?]}}{if order.status == "pending"}{order.tags.gift ?? ""}{/if}{order: Order}{id: string | int}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface Order {",
+				"	status: \"pending\" | \"shipped\";",
+				"	sizes: (\"s\" | \"m\" | null)[];",
+				"	tags: Record<string,string | null>;",
+				"}",
+				"export interface model {",
+				"	id: string | number;",
+				"	order: Order;",
+				"}",
+				"export const render = ({id, order}: model) => (`${(order.status == \"pending\") && (`${htmlEncode(order.tags.gift ?? \"\")}`) || ''}${htmlEncode(order)}${htmlEncode(id)}`);",
+			}, "\n"),
+		}, {
+			name: "props",
+			template: `{props
+				title: string
+				count: int = 0
+				tags: string[] = []
+				status: "open" | "closed" = "open"
+				nickname: string? = null
+			}<h1>{title}</h1>`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface model {",
+				"	title: string;",
+				"	count?: number;",
+				"	tags?: string[];",
+				"	status?: \"open\" | \"closed\";",
+				"	nickname?: string | null;",
+				"}",
+				"export const render = ({title, count = 0, tags = [], status = \"open\", nickname = null}: model) => (`<h1>${htmlEncode(title)}</h1>`);",
+			}, "\n"),
+		}, {
+			name:     "date, time and decimal types",
+			template: `{type Line = {sku: string, price: decimal}}<time datetime={placed: datetime}>{placed}</time>{for i, line in lines: Line[]}{line.sku}: {line.price}{/for}<p>{total: decimal?}</p>`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"const formatDateTime = (value: Date | string | null | undefined) => {",
+				"	if (value == null) return '';",
+				"	return new Intl.DateTimeFormat(undefined, { dateStyle: 'medium', timeStyle: 'short' }).format(new Date(value));",
+				"};",
+				"const formatDecimal = (value: string | null | undefined) => {",
+				"	if (value == null) return '';",
+				"	const match = /^(-?)(\\d+)(?:\\.(\\d+))?$/.exec(value);",
+				"	if (!match) return value;",
+				"	const parts = new Intl.NumberFormat().formatToParts(1234567.5);",
+				"	const group = parts.find((part) => part.type === 'group')?.value ?? '';",
+				"	const decimal = parts.find((part) => part.type === 'decimal')?.value ?? '.';",
+				"	return match[1] + match[2].replace(/\\B(?=(\\d{3})+(?!\\d))/g, group) + (match[3] === undefined ? '' : decimal + match[3]);",
+				"};",
+				"export interface Line {",
+				"	sku: string;",
+				"	price: string;",
+				"}",
+				"export interface model {",
+				"	lines: Line[];",
+				"	placed: Date | string;",
+				"	total?: string | null;",
+				"}",
+				"export const render = ({lines, placed, total}: model) => (`<time datetime=\"${htmlEncode(placed)}\">${htmlEncode(formatDateTime(placed))}</time>" +
+					"${[...(Array.isArray(lines) ? lines.entries() : Object.entries(lines))].map(([i, line]) => (`${htmlEncode(line.sku)}: ${htmlEncode(formatDecimal(line.price))}`)).join('')}" +
+					"<p>${htmlEncode(formatDecimal(total))}</p>`);",
+			}, "\n"),
 		}, {
 			name: "spread attribute",
 			template: `<div>
 				<img {...attrs: map[string,string]}>
 			</div>`,
 			expected: strings.Join([]string{
-				"const encoder = document.createElement('div');",
-				"const htmlEncode = (value: string) => {",
-				"	encoder.textContent = value;",
-				"	return encoder.innerHTML;",
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"const sanitizeUrl = (value: unknown) => {",
+				"	const url = String(value ?? '');",
+				"	return /^(?:(?:https?|mailto|tel):|[^:/?#]*(?:[/?#]|$))/i.test(url) ? url : 'about:invalid#unsafe-url';",
 				"};",
+				"const urlAttributes = /*#__PURE__*/ new Set(['action', 'background', 'cite', 'formaction', 'href', 'icon', 'longdesc', 'manifest', 'poster', 'src', 'xlink:href']);",
+				"const spreadAttributes = (attrs: Record<string, unknown>) => Object.entries(attrs)",
+				"	.filter(([name]) => /^[^\\s\"'<>/=]+$/.test(name) && !/^on/i.test(name))",
+				"	.map(([name, value]) => `${name}=\"${htmlEncode(urlAttributes.has(name.toLowerCase()) ? sanitizeUrl(value) : value)}\"`)",
+				"	.join(' ');",
 				"export interface model {",
 				"	attrs: Record<string,string>;",
 				"}",
 				"export const render = ({attrs}: model) => (`<div>",
-				"				<img ${[...Object.entries(attrs)].map(([k, v]) => (`${k}=\"${htmlEncode(v)}\"`)).join(' ')}>",
+				"				<img ${spreadAttributes(attrs)}>",
 				"			</div>`);",
 			}, "\n"),
+		}, {
+			name: "escaping contexts",
+			template: `<a href={url: string} title={title: string}>{name: string}</a><a href="/users/{id: int}?tab={tab: string}">profile</a>` +
+				`<button onclick="select({id})" style="color: {color: string}">{raw label: string}</button>` +
+				`<script>const config = {= config: map[string,string]};</script><style>p { color: {color}; }</style>`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"const sanitizeUrl = (value: unknown) => {",
+				"	const url = String(value ?? '');",
+				"	return /^(?:(?:https?|mailto|tel):|[^:/?#]*(?:[/?#]|$))/i.test(url) ? url : 'about:invalid#unsafe-url';",
+				"};",
+				"const urlEncode = (value: unknown) => encodeURIComponent(String(value ?? ''));",
+				"const scriptJson = (value: unknown) => (JSON.stringify(value) ?? 'null')",
+				"	.replace(/[<>&\\u2028\\u2029]/g, (c) => '\\\\u' + c.charCodeAt(0).toString(16).padStart(4, '0'));",
+				"const cssEncode = (value: unknown) => String(value ?? '')",
+				"	.replace(/[^\\w .,#%+-]/gu, (c) => '\\\\' + c.codePointAt(0)!.toString(16) + ' ');",
+				"export interface model {",
+				"	color: string;",
+				"	config: Record<string,string>;",
+				"	id: number;",
+				"	label: string;",
+				"	name: string;",
+				"	tab: string;",
+				"	title: string;",
+				"	url: string;",
+				"}",
+				"export const render = ({color, config, id, label, name, tab, title, url}: model) => (`<a href=\"${htmlEncode(sanitizeUrl(url))}\" title=\"${htmlEncode(title)}\">${htmlEncode(name)}</a>" +
+					"<a href=\"/users/${htmlEncode(urlEncode(id))}?tab=${htmlEncode(urlEncode(tab))}\">profile</a>" +
+					"<button onclick=\"select(${htmlEncode(scriptJson(id))})\" style=\"color: ${htmlEncode(cssEncode(color))}\">${label}</button>" +
+					"<script>const config = ${scriptJson(config)};</script>" +
+					"<style>p { color: ${cssEncode(color)}; }</style>`);",
+			}, "\n"),
+		}, {
+			name: "template literal metacharacters",
+			template: "<p title=\"a`b\\c ${x\">It's `code`, C:\\temp and $5 or ${ 5 }</p><!-- `${danger}` -->" +
+				"<script>const s = `tpl ${location.href}` + '\\\\' + `\\``;</script><style>q::before { content: \"\\201C\"; }</style>" +
+				"<textarea>`${x}`</textarea>{name: string}",
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"export interface model {",
+				"	name: string;",
+				"}",
+				"export const render = ({name}: model) => (`<p title=\"a\\`b\\\\c \\${x\">It's \\`code\\`, C:\\\\temp and \\$5 or \\$${htmlEncode(5)}</p>" +
+					"<script>const s = \\`tpl \\${location.href}\\` + '\\\\\\\\' + \\`\\\\\\`\\`;</script>" +
+					"<style>q::before { content: \"\\\\201C\"; }</style>" +
+					"<textarea>\\`\\${x}\\`</textarea>${htmlEncode(name)}`);",
+			}, "\n"),
+		}, {
+			name:     "binds",
+			template: `<form {bind:form}><button {bind:save}>Save</button>{if note: string?}<p {bind:note}>{note}</p>{/if}{for i, item in items: string[]}<li {bind:rows}>{item}</li>{/for}<svg {bind:my-icon}></svg></form>`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"const requiredRef = <T>(element: T | null, name: string): T => {",
+				"	if (element === null) throw new Error(`missing bind ${name}`);",
+				"	return element;",
+				"};",
+				"export interface model {",
+				"	items: string[];",
+				"	note?: string | null;",
+				"}",
+				"export interface refs {",
+				"	form: HTMLFormElement;",
+				"	save: HTMLButtonElement;",
+				"	note?: HTMLParagraphElement | null;",
+				"	rows: HTMLLIElement[];",
+				"	\"my-icon\": SVGSVGElement;",
+				"}",
+				"export const render = ({items, note}: model) => (`<form data-bind-id=\"form\"><button data-bind-id=\"save\">Save</button>${(note) && (`<p data-bind-id=\"note\">${htmlEncode(note)}</p>`) || ''}${[...(Array.isArray(items) ? items.entries() : Object.entries(items))].map(([i, item]) => (`<li data-bind-id=\"rows\">${htmlEncode(item)}</li>`)).join('')}<svg data-bind-id=\"my-icon\"></svg></form>`);",
+				"export const mount = (root: ParentNode): refs => ({",
+				"	form: requiredRef(root.querySelector<HTMLFormElement>(\"[data-bind-id='form']\"), \"form\"),",
+				"	save: requiredRef(root.querySelector<HTMLButtonElement>(\"[data-bind-id='save']\"), \"save\"),",
+				"	note: root.querySelector<HTMLParagraphElement>(\"[data-bind-id='note']\"),",
+				"	rows: Array.from(root.querySelectorAll<HTMLLIElement>(\"[data-bind-id='rows']\")),",
+				"	\"my-icon\": requiredRef(root.querySelector<SVGSVGElement>(\"[data-bind-id='my-icon']\"), \"my-icon\"),",
+				"});",
+			}, "\n"),
+		}, {
+			name:     "bind in branches of a conditional",
+			template: `{if open: bool}<button {bind:toggle}>Close</button>{else}<a {bind:toggle}>Open</a>{/if}`,
+			expected: strings.Join([]string{
+				"export interface model {",
+				"	open: boolean;",
+				"}",
+				"export interface refs {",
+				"	toggle?: HTMLButtonElement | HTMLAnchorElement | null;",
+				"}",
+				"export const render = ({open}: model) => (`${(open) && (`<button data-bind-id=\"toggle\">Close</button>`) || (`<a data-bind-id=\"toggle\">Open</a>`) || ''}`);",
+				"export const mount = (root: ParentNode): refs => ({",
+				"	toggle: root.querySelector<HTMLButtonElement | HTMLAnchorElement>(\"[data-bind-id='toggle']\"),",
+				"});",
+			}, "\n"),
+		}, {
+			name:     "helpers only used in else branches",
+			template: `{if open: bool}open{else}{day: date}{/if}`,
+			expected: strings.Join([]string{
+				"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+				"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+				"const formatDate = (value: string | null | undefined) => {",
+				"	if (value == null) return '';",
+				"	return new Intl.DateTimeFormat(undefined, { dateStyle: 'medium', timeZone: 'UTC' }).format(new Date(value));",
+				"};",
+				"export interface model {",
+				"	day: string;",
+				"	open: boolean;",
+				"}",
+				"export const render = ({day, open}: model) => (`${(open) && (`open`) || (`${htmlEncode(formatDate(day))}`) || ''}`);",
+			}, "\n"),
 		},
 	}
 	for _, tt := range tests {
@@ -109,3 +366,835 @@ func TestGenerate(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateWithOptions_Validators(t *testing.T) {
+	template := `{type Line = {sku: string, sizes: ("s" | "m")[]}}{for i, line in lines: Line[]}{line.sku}{/for}{id: string | int}{notes: map[string,decimal?]}`
+	expected := strings.Join([]string{
+		"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+		"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+		"export interface Line {",
+		"	sku: string;",
+		"	sizes: (\"s\" | \"m\")[];",
+		"}",
+		"export interface model {",
+		"	id: string | number;",
+		"	lines: Line[];",
+		"	notes: Record<string,string | null>;",
+		"}",
+		"export const render = ({id, lines, notes}: model) => (`${[...(Array.isArray(lines) ? lines.entries() : Object.entries(lines))].map(([i, line]) => (`${htmlEncode(line.sku)}`)).join('')}${htmlEncode(id)}${htmlEncode(notes)}`);",
+		"export class ModelError extends Error {",
+		"	readonly path: string;",
+		"	readonly expected: string;",
+		"	constructor(path: string, expected: string) {",
+		"		super(`${path}: expected ${expected}`);",
+		"		this.path = path;",
+		"		this.expected = expected;",
+		"	}",
+		"}",
+		"function fail(path: string, expected: string): never {",
+		"	throw new ModelError(path, expected);",
+		"}",
+		"const isRecord = (x: unknown): x is Record<string, unknown> => typeof x === 'object' && x !== null && !Array.isArray(x);",
+		"const matches = (check: () => void) => {",
+		"	try {",
+		"		check();",
+		"		return true;",
+		"	} catch (e) {",
+		"		if (e instanceof ModelError) return false;",
+		"		throw e;",
+		"	}",
+		"};",
+		"function assertType_Line(x: unknown, path: string): asserts x is Line {",
+		"	if (!isRecord(x)) return fail(path, 'an object');",
+		"	if (typeof x[\"sku\"] !== 'string') return fail(`${path}.sku`, \"a string\");",
+		"	const v1 = x[\"sizes\"];",
+		"	if (!Array.isArray(v1)) return fail(`${path}.sizes`, 'an array');",
+		"	for (const [i2, e2] of v1.entries()) {",
+		"		if (e2 !== \"s\" && e2 !== \"m\") return fail(`${path}.sizes[${i2}]`, \"\\\"s\\\" | \\\"m\\\"\");",
+		"	}",
+		"}",
+		"export function assertModel(x: unknown): asserts x is model {",
+		"	if (!isRecord(x)) return fail('model', 'an object');",
+		"	const v1 = x[\"id\"];",
+		"	if (!(matches(() => {",
+		"		if (typeof v1 !== 'string') return fail(`model.id`, \"a string\");",
+		"	}) || matches(() => {",
+		"		if (!Number.isInteger(v1)) return fail(`model.id`, \"an integer\");",
+		"	}))) return fail(`model.id`, \"string | number\");",
+		"	const v2 = x[\"lines\"];",
+		"	if (!Array.isArray(v2)) return fail(`model.lines`, 'an array');",
+		"	for (const [i3, e3] of v2.entries()) {",
+		"		assertType_Line(e3, `model.lines[${i3}]`);",
+		"	}",
+		"	const v4 = x[\"notes\"];",
+		"	if (!isRecord(v4)) return fail(`model.notes`, 'an object');",
+		"	for (const [k5, e5] of Object.entries(v4)) {",
+		"		if (e5 != null) {",
+		"			if (typeof e5 !== 'string' || !/^-?\\d+(\\.\\d+)?$/.test(e5)) return fail(`model.notes[${JSON.stringify(k5)}]`, \"a decimal string\");",
+		"		}",
+		"	}",
+		"}",
+		"export function validateModel(x: unknown): x is model {",
+		"	return matches(() => assertModel(x));",
+		"}",
+	}, "\n")
+
+	document, err := parser.Parse(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = GenerateWithOptions(document, &buf, Options{Validators: true})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestGenerateWithOptions_ValidatorNames(t *testing.T) {
+	document, err := parser.Parse(strings.NewReader(`{type Model = {a: string}}<p>{m: Model}</p>`))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = GenerateWithOptions(document, &buf, Options{Validators: true})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "function assertModel("))
+	assert.Contains(t, buf.String(), "function assertType_Model(x: unknown, path: string): asserts x is Model {")
+	assert.Contains(t, buf.String(), "assertType_Model(x[\"m\"], `model.m`);")
+
+	document, err = parser.Parse(strings.NewReader(`{type OrderModel = {a: string}}<p>{m: OrderModel}</p>`))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	err = GenerateWithOptions(document, io.Discard, Options{Name: "Order", Validators: true})
+	assert.EqualError(t, err, "type OrderModel has the name of the model")
+	err = GenerateDeclarations(document, io.Discard, Options{Name: "Order", JavaScript: true})
+	assert.EqualError(t, err, "type OrderModel has the name of the model")
+}
+
+func TestGenerate_ServerSafe(t *testing.T) {
+	// modules are imported on servers and in workers, where there is no DOM
+	template := `{type Line = {sku: string, price: decimal}}<a href={url: string} {...attrs: map[string,string]}>{name: string}</a>` +
+		`{for i, line in lines: Line[]}<p title={line.sku} style="color: {color: string}">{line.price}</p>{/for}<time>{placed: datetime}</time>`
+	document, err := parser.Parse(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = GenerateWithOptions(document, &buf, Options{Validators: true})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	assert.NotContains(t, buf.String(), "document.")
+	assert.NotContains(t, buf.String(), "innerHTML")
+}
+
+func TestGenerateWithOptions_Modules(t *testing.T) {
+	template := `<p>{name: string}</p>`
+	helpers := []string{
+		"const htmlEscapes: Record<string, string> = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+		"const htmlEncode = (value: unknown) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+	}
+	jsHelpers := []string{
+		"const htmlEscapes = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '\"': '&quot;', \"'\": '&#39;' };",
+		"const htmlEncode = (value) => String(value ?? '').replace(/[&<>\"']/g, (c) => htmlEscapes[c]);",
+	}
+	tests := []struct {
+		name     string
+		options  Options
+		expected []string
+	}{
+		{
+			name:    "names from the file name",
+			options: Options{Name: "OrderSummary"},
+			expected: append(helpers,
+				"export interface OrderSummaryModel {",
+				"	name: string;",
+				"}",
+				"export const renderOrderSummary = ({name}: OrderSummaryModel) => (`<p>${htmlEncode(name)}</p>`);",
+			),
+		}, {
+			name:    "default export",
+			options: Options{Name: "OrderSummary", DefaultExport: true},
+			expected: append(helpers,
+				"export interface OrderSummaryModel {",
+				"	name: string;",
+				"}",
+				"const renderOrderSummary = ({name}: OrderSummaryModel) => (`<p>${htmlEncode(name)}</p>`);",
+				"export default renderOrderSummary;",
+			),
+		}, {
+			name:    "JavaScript",
+			options: Options{JavaScript: true},
+			expected: append(jsHelpers,
+				"export const render = ({name}) => (`<p>${htmlEncode(name)}</p>`);",
+			),
+		}, {
+			name:    "CommonJS",
+			options: Options{JavaScript: true, Module: CommonJS, Validators: true},
+			expected: append(append([]string{
+				"'use strict';",
+				"Object.defineProperty(exports, '__esModule', { value: true });",
+			}, jsHelpers...),
+				"const render = ({name}) => (`<p>${htmlEncode(name)}</p>`);",
+				"class ModelError extends Error {",
+				"	constructor(path, expected) {",
+				"		super(`${path}: expected ${expected}`);",
+				"		this.path = path;",
+				"		this.expected = expected;",
+				"	}",
+				"}",
+				"function fail(path, expected) {",
+				"	throw new ModelError(path, expected);",
+				"}",
+				"const isRecord = (x) => typeof x === 'object' && x !== null && !Array.isArray(x);",
+				"const matches = (check) => {",
+				"	try {",
+				"		check();",
+				"		return true;",
+				"	} catch (e) {",
+				"		if (e instanceof ModelError) return false;",
+				"		throw e;",
+				"	}",
+				"};",
+				"function assertModel(x) {",
+				"	if (!isRecord(x)) return fail('model', 'an object');",
+				"	if (typeof x[\"name\"] !== 'string') return fail(`model.name`, \"a string\");",
+				"}",
+				"function validateModel(x) {",
+				"	return matches(() => assertModel(x));",
+				"}",
+				"exports.render = render;",
+				"exports.ModelError = ModelError;",
+				"exports.assertModel = assertModel;",
+				"exports.validateModel = validateModel;",
+			),
+		}, {
+			name:    "runtime",
+			options: Options{Runtime: "./guts-runtime", Validators: true},
+			expected: []string{
+				"import { VERSION, htmlEncode, ModelError, fail, isRecord, matches } from './guts-runtime';",
+				"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+				"export interface model {",
+				"	name: string;",
+				"}",
+				"export const render = ({name}: model) => (`<p>${htmlEncode(name)}</p>`);",
+				"export { ModelError };",
+				"export function assertModel(x: unknown): asserts x is model {",
+				"	if (!isRecord(x)) return fail('model', 'an object');",
+				"	if (typeof x[\"name\"] !== 'string') return fail(`model.name`, \"a string\");",
+				"}",
+				"export function validateModel(x: unknown): x is model {",
+				"	return matches(() => assertModel(x));",
+				"}",
+			},
+		}, {
+			name:    "CommonJS runtime",
+			options: Options{JavaScript: true, Module: CommonJS, Runtime: "./guts-runtime"},
+			expected: []string{
+				"'use strict';",
+				"Object.defineProperty(exports, '__esModule', { value: true });",
+				"const { VERSION, htmlEncode } = require('./guts-runtime');",
+				"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+				"const render = ({name}) => (`<p>${htmlEncode(name)}</p>`);",
+				"exports.render = render;",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := parser.Parse(strings.NewReader(template))
+			if err != nil {
+				t.Fatalf("failed to parse template: %v", err)
+			}
+
+			var buf bytes.Buffer
+			err = GenerateWithOptions(document, &buf, tt.options)
+			if err != nil {
+				t.Fatalf("failed to generate template: %v", err)
+			}
+			assert.Equal(t, strings.Join(tt.expected, "\n"), buf.String())
+		})
+	}
+
+	document, err := parser.Parse(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	err = GenerateWithOptions(document, &bytes.Buffer{}, Options{Module: CommonJS})
+	assert.EqualError(t, err, "only JavaScript can be generated as a CommonJS module")
+}
+
+func TestGenerateWithOptions_DOM(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		options  Options
+		expected []string
+	}{
+		{
+			name:     "elements, text and refs",
+			template: `<p class="total {kind: string}">Hi <b {bind:name}>{name: string}</b><br>{price: decimal}</p>`,
+			options:  Options{DOM: true, Runtime: "./guts-runtime"},
+			expected: []string{
+				"import { VERSION, requiredRef, formatDecimal } from './guts-runtime';",
+				"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+				"export interface model {",
+				"	kind: string;",
+				"	name: string;",
+				"	price: string;",
+				"}",
+				"export interface refs {",
+				"	name: HTMLElement;",
+				"}",
+				"export const render = ({kind, name, price}: model): { fragment: DocumentFragment; refs: refs } => {",
+				"	const fragment = document.createDocumentFragment();",
+				"	const refs = {} as refs;",
+				"	const e1 = document.createElement(\"p\");",
+				"	e1.setAttribute(\"class\", \"total \" + String(kind ?? ''));",
+				"	fragment.append(e1);",
+				"	e1.append(document.createTextNode(\"Hi \"));",
+				"	const e2 = document.createElement(\"b\");",
+				"	e2.setAttribute(\"data-bind-id\", \"name\");",
+				"	refs.name = e2;",
+				"	e1.append(e2);",
+				"	e2.append(document.createTextNode(String(name ?? '')));",
+				"	const e3 = document.createElement(\"br\");",
+				"	e1.append(e3);",
+				"	e1.append(document.createTextNode(formatDecimal(price)));",
+				"	return { fragment, refs };",
+				"};",
+				"export const mount = (root: ParentNode): refs => ({",
+				"	name: requiredRef(root.querySelector<HTMLElement>(\"[data-bind-id='name']\"), \"name\"),",
+				"});",
+			},
+		}, {
+			name:     "blocks",
+			template: `{if open: bool}<ul>{for i, item in items: string[]?}<li data-index={i}>{item}</li>{/for}</ul>{else if closed: bool}<p>Closed</p>{else}{raw note: string}{/if}`,
+			options:  Options{DOM: true},
+			expected: []string{
+				"const parseHtml = (value: unknown) => {",
+				"	const template = document.createElement('template');",
+				"	template.innerHTML = String(value ?? '');",
+				"	return template.content;",
+				"};",
+				"export interface model {",
+				"	closed: boolean;",
+				"	items?: string[] | null;",
+				"	note: string;",
+				"	open: boolean;",
+				"}",
+				"export interface refs {",
+				"}",
+				"export const render = ({closed, items, note, open}: model): { fragment: DocumentFragment; refs: refs } => {",
+				"	const fragment = document.createDocumentFragment();",
+				"	const refs = {} as refs;",
+				"	if (open) {",
+				"		const e1 = document.createElement(\"ul\");",
+				"		fragment.append(e1);",
+				"		for (const [i, item] of Array.isArray((items ?? [])) ? (items ?? []).entries() : Object.entries((items ?? []))) {",
+				"			const e2 = document.createElement(\"li\");",
+				"			e2.setAttribute(\"data-index\", String(i ?? ''));",
+				"			e1.append(e2);",
+				"			e2.append(document.createTextNode(String(item ?? '')));",
+				"		}",
+				"	} else if (closed) {",
+				"		const e3 = document.createElement(\"p\");",
+				"		fragment.append(e3);",
+				"		e3.append(document.createTextNode(\"Closed\"));",
+				"	} else {",
+				"		fragment.append(parseHtml(note));",
+				"	}",
+				"	return { fragment, refs };",
+				"};",
+			},
+		}, {
+			name:     "escaping contexts",
+			template: `<a href="/orders/{id: string}" onclick={action: string} {...attrs: map[string,string]}><svg><path d="M0"/></svg></a><style>p { color: {color: string}; }</style>`,
+			options:  Options{DOM: true, JavaScript: true, Runtime: "./guts-runtime.js"},
+			expected: []string{
+				"import { VERSION, urlEncode, scriptJson, cssEncode, setAttributes } from './guts-runtime.js';",
+				"if (VERSION !== 4) throw new Error('./guts-runtime.js is version ' + VERSION + ', expected 4');",
+				"export const render = ({action, attrs, color, id}) => {",
+				"	const fragment = document.createDocumentFragment();",
+				"	const refs = {};",
+				"	const e1 = document.createElement(\"a\");",
+				"	e1.setAttribute(\"href\", \"/orders/\" + urlEncode(id));",
+				"	e1.setAttribute(\"onclick\", scriptJson(action));",
+				"	setAttributes(e1, attrs);",
+				"	fragment.append(e1);",
+				"	const e2 = document.createElementNS(\"http://www.w3.org/2000/svg\", \"svg\");",
+				"	e1.append(e2);",
+				"	const e3 = document.createElementNS(\"http://www.w3.org/2000/svg\", \"path\");",
+				"	e3.setAttribute(\"d\", \"M0\");",
+				"	e2.append(e3);",
+				"	const e4 = document.createElement(\"style\");",
+				"	fragment.append(e4);",
+				"	e4.append(document.createTextNode(\"p { color: \"));",
+				"	e4.append(document.createTextNode(cssEncode(color)));",
+				"	e4.append(document.createTextNode(\"; }\"));",
+				"	return { fragment, refs };",
+				"};",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := parser.Parse(strings.NewReader(tt.template))
+			if err != nil {
+				t.Fatalf("failed to parse template: %v", err)
+			}
+
+			var buf bytes.Buffer
+			err = GenerateWithOptions(document, &buf, tt.options)
+			if err != nil {
+				t.Fatalf("failed to generate template: %v", err)
+			}
+			assert.Equal(t, strings.Join(tt.expected, "\n"), buf.String())
+		})
+	}
+}
+
+func TestGenerateWithOptions_Update(t *testing.T) {
+	template := strings.Join([]string{
+		`<p class="total {kind: string}" {...attrs}>Hi <b {bind:name}>{name: string}</b></p>`,
+		`{if count: int == 1}<i {bind:one}>{count} item</i>{else}many{/if}`,
+		`<ul>{for i, item in items: string[]}<li {bind:rows}>{item}</li>{/for}</ul>`,
+		`{raw html: string}`,
+	}, "\n")
+	expected := []string{
+		"import { VERSION, htmlEncode, spreadAttributes, setAttributes, parseHtml, patchAttributes, region, requiredRef } from './guts-runtime';",
+		"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+		"export interface model {",
+		"	attrs: Record<string,string>;",
+		"	count: number;",
+		"	html: string;",
+		"	items: string[];",
+		"	kind: string;",
+		"	name: string;",
+		"}",
+		"export interface refs {",
+		"	name: HTMLElement;",
+		"	one?: HTMLElement | null;",
+		"	rows: HTMLLIElement[];",
+		"}",
+		"export const render = ({attrs, count, html, items, kind, name}: model) => (`<p class=\"total ${htmlEncode(kind)}\" ${spreadAttributes(attrs)}>Hi <b data-bind-id=\"name\">${htmlEncode(name)}</b></p>",
+		"${(count == 1) && (`<i data-bind-id=\"one\">${htmlEncode(count)} item</i>`) || (`many`) || ''}",
+		"<ul>${[...(Array.isArray(items) ? items.entries() : Object.entries(items))].map(([i, item]) => (`<li data-bind-id=\"rows\">${htmlEncode(item)}</li>`)).join('')}</ul>",
+		"${html}`);",
+		"export const create = ({attrs, count, html, items, kind, name}: model): { fragment: DocumentFragment; refs: refs; update: (model: model) => void } => {",
+		"	const fragment = document.createDocumentFragment();",
+		"	const refs = { rows: [] as HTMLLIElement[] } as refs;",
+		"	const e1 = document.createElement(\"p\");",
+		"	e1.setAttribute(\"class\", \"total \" + String(kind ?? ''));",
+		"	setAttributes(e1, attrs);",
+		"	fragment.append(e1);",
+		"	e1.append(document.createTextNode(\"Hi \"));",
+		"	const e2 = document.createElement(\"b\");",
+		"	e2.setAttribute(\"data-bind-id\", \"name\");",
+		"	refs.name = e2;",
+		"	e1.append(e2);",
+		"	const t3 = document.createTextNode(String(name ?? ''));",
+		"	e2.append(t3);",
+		"	fragment.append(document.createTextNode(\"\\n\"));",
+		"	const r4 = region(fragment, () => {",
+		"		const fragment = document.createDocumentFragment();",
+		"		if (count == 1) {",
+		"			const e5 = document.createElement(\"i\");",
+		"			e5.setAttribute(\"data-bind-id\", \"one\");",
+		"			refs.one = e5;",
+		"			fragment.append(e5);",
+		"			e5.append(document.createTextNode(String(count ?? '')));",
+		"			e5.append(document.createTextNode(\" item\"));",
+		"		} else {",
+		"			fragment.append(document.createTextNode(\"many\"));",
+		"		}",
+		"		return fragment;",
+		"	});",
+		"	fragment.append(document.createTextNode(\"\\n\"));",
+		"	const e6 = document.createElement(\"ul\");",
+		"	fragment.append(e6);",
+		"	const r7 = region(e6, () => {",
+		"		const fragment = document.createDocumentFragment();",
+		"		for (const [i, item] of Array.isArray(items) ? items.entries() : Object.entries(items)) {",
+		"			const e8 = document.createElement(\"li\");",
+		"			e8.setAttribute(\"data-bind-id\", \"rows\");",
+		"			refs.rows.push(e8);",
+		"			fragment.append(e8);",
+		"			e8.append(document.createTextNode(String(item ?? '')));",
+		"		}",
+		"		return fragment;",
+		"	});",
+		"	fragment.append(document.createTextNode(\"\\n\"));",
+		"	const r9 = region(fragment, () => parseHtml(html));",
+		"	const update = (next: model) => {",
+		"		const previous = { attrs, count, html, items, kind, name };",
+		"		({ attrs, count, html, items, kind, name } = next);",
+		"		if (kind !== previous.kind) e1.setAttribute(\"class\", \"total \" + String(kind ?? ''));",
+		"		if (attrs !== previous.attrs) patchAttributes(e1, previous.attrs, attrs);",
+		"		if (name !== previous.name) t3.data = String(name ?? '');",
+		"		if (count !== previous.count) {",
+		"			refs.one = null;",
+		"			r4.update();",
+		"		}",
+		"		if (items !== previous.items) {",
+		"			refs.rows.length = 0;",
+		"			r7.update();",
+		"		}",
+		"		if (html !== previous.html) r9.update();",
+		"	};",
+		"	return { fragment, refs, update };",
+		"};",
+		"export const mount = (root: ParentNode): refs => ({",
+		"	name: requiredRef(root.querySelector<HTMLElement>(\"[data-bind-id='name']\"), \"name\"),",
+		"	one: root.querySelector<HTMLElement>(\"[data-bind-id='one']\"),",
+		"	rows: Array.from(root.querySelectorAll<HTMLLIElement>(\"[data-bind-id='rows']\")),",
+		"});",
+	}
+
+	document, err := parser.Parse(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	err = GenerateWithOptions(document, &buf, Options{Update: true, Runtime: "./guts-runtime"})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	assert.Equal(t, strings.Join(expected, "\n"), buf.String())
+}
+
+func TestGenerateWithOptions_UpdateKeyed(t *testing.T) {
+	template := `{type Row = {id: int, label: string}}<ul class={theme: string}>{for i, row in rows: Row[] key row.id}<li {bind:items}>{i}. {row.label} {theme}</li>{/for}</ul>`
+	expected := []string{
+		"import { VERSION, htmlEncode, keyedRegion } from './guts-runtime';",
+		"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+		"export interface Row {",
+		"	id: number;",
+		"	label: string;",
+		"}",
+		"export interface model {",
+		"	rows: Row[];",
+		"	theme: string;",
+		"}",
+		"export interface refs {",
+		"	items: HTMLLIElement[];",
+		"}",
+		"export const render = ({rows, theme}: model) => (`<ul class=\"${htmlEncode(theme)}\">${[...(Array.isArray(rows) ? rows.entries() : Object.entries(rows))].map(([i, row]) => (`<li data-bind-id=\"items\">${htmlEncode(i)}. ${htmlEncode(row.label)} ${htmlEncode(theme)}</li>`)).join('')}</ul>`);",
+		"export const create = ({rows, theme}: model): { fragment: DocumentFragment; refs: refs; update: (model: model) => void } => {",
+		"	const fragment = document.createDocumentFragment();",
+		"	const refs = { items: [] as HTMLLIElement[] } as refs;",
+		"	const e1 = document.createElement(\"ul\");",
+		"	e1.setAttribute(\"class\", String(theme ?? ''));",
+		"	fragment.append(e1);",
+		"	const r2 = keyedRegion(e1, () => (Array.isArray(rows) ? rows.entries() : Object.entries(rows)), (i, row) => row.id, true, (i, row) => {",
+		"		const fragment = document.createDocumentFragment();",
+		"		const e3 = document.createElement(\"li\");",
+		"		e3.setAttribute(\"data-bind-id\", \"items\");",
+		"		refs.items.push(e3);",
+		"		fragment.append(e3);",
+		"		e3.append(document.createTextNode(String(i ?? '')));",
+		"		e3.append(document.createTextNode(\". \"));",
+		"		e3.append(document.createTextNode(String(row.label ?? '')));",
+		"		e3.append(document.createTextNode(\" \"));",
+		"		e3.append(document.createTextNode(String(theme ?? '')));",
+		"		return fragment;",
+		"	});",
+		"	const update = (next: model) => {",
+		"		const previous = { rows, theme };",
+		"		({ rows, theme } = next);",
+		"		if (theme !== previous.theme) e1.setAttribute(\"class\", String(theme ?? ''));",
+		"		if (rows !== previous.rows || theme !== previous.theme) {",
+		"			r2.update(theme !== previous.theme);",
+		"			refs.items.length = 0;",
+		"			refs.items.push(...r2.select<HTMLLIElement>(\"[data-bind-id='items']\"));",
+		"		}",
+		"	};",
+		"	return { fragment, refs, update };",
+		"};",
+		"export const mount = (root: ParentNode): refs => ({",
+		"	items: Array.from(root.querySelectorAll<HTMLLIElement>(\"[data-bind-id='items']\")),",
+		"});",
+	}
+
+	document, err := parser.Parse(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	err = GenerateWithOptions(document, &buf, Options{Update: true, Runtime: "./guts-runtime"})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	assert.Equal(t, strings.Join(expected, "\n"), buf.String())
+}
+func TestGenerateWithOptions_UpdateFormControls(t *testing.T) {
+	template := `<input value="{v: string}"><input type="checkbox" checked="{done: bool}"><input title="{v}">`
+	expected := []string{
+		"import { VERSION, htmlEncode } from './guts-runtime';",
+		"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+		"export interface model {",
+		"	done: boolean;",
+		"	v: string;",
+		"}",
+		"export interface refs {",
+		"}",
+		"export const render = ({done, v}: model) => (`<input value=\"${htmlEncode(v)}\"><input type=\"checkbox\" checked=\"${htmlEncode(done)}\"><input title=\"${htmlEncode(v)}\">`);",
+		"export const create = ({done, v}: model): { fragment: DocumentFragment; refs: refs; update: (model: model) => void } => {",
+		"	const fragment = document.createDocumentFragment();",
+		"	const refs = {} as refs;",
+		"	const e1 = document.createElement(\"input\");",
+		"	e1.setAttribute(\"value\", String(v ?? ''));",
+		"	fragment.append(e1);",
+		"	const e2 = document.createElement(\"input\");",
+		"	e2.setAttribute(\"type\", \"checkbox\");",
+		"	e2.setAttribute(\"checked\", String(done ?? ''));",
+		"	fragment.append(e2);",
+		"	const e3 = document.createElement(\"input\");",
+		"	e3.setAttribute(\"title\", String(v ?? ''));",
+		"	fragment.append(e3);",
+		"	const update = (next: model) => {",
+		"		const previous = { done, v };",
+		"		({ done, v } = next);",
+		"		if (v !== previous.v) e1.value = String(v ?? '');",
+		"		if (done !== previous.done) e2.checked = Boolean(done);",
+		"		if (v !== previous.v) e3.setAttribute(\"title\", String(v ?? ''));",
+		"	};",
+		"	return { fragment, refs, update };",
+		"};",
+	}
+
+	document, err := parser.Parse(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	var buf bytes.Buffer
+	err = GenerateWithOptions(document, &buf, Options{Update: true, Runtime: "./guts-runtime"})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	assert.Equal(t, strings.Join(expected, "\n"), buf.String())
+}
+
+func TestGenerate_ComponentScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		options  Options
+		expected []string
+	}{
+		{
+			name: "mount wired to binds",
+			template: `<button {bind:save}>Save</button>
+<script type="ts">
+	const label = (count: number) => count + " saved";
+
+	export function mount(save: HTMLButtonElement) {
+		save.addEventListener("click", () => save.textContent = label(1));
+	}
+</script>`,
+			options: Options{Runtime: "./guts-runtime"},
+			expected: []string{
+				"import { VERSION, requiredRef } from './guts-runtime';",
+				"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+				"export interface model {",
+				"}",
+				"export interface refs {",
+				"	save: HTMLButtonElement;",
+				"}",
+				"const label = (count: number) => count + \" saved\";",
+				"",
+				"function mountScript(save: HTMLButtonElement) {",
+				"	save.addEventListener(\"click\", () => save.textContent = label(1));",
+				"}",
+				"export const render = ({}: model) => (`<button data-bind-id=\"save\">Save</button>",
+				"`);",
+				"export const mount = (root: ParentNode): refs => {",
+				"	const refs: refs = {",
+				"		save: requiredRef(root.querySelector<HTMLButtonElement>(\"[data-bind-id='save']\"), \"save\"),",
+				"	};",
+				"	mountScript(refs.save);",
+				"	return refs;",
+				"};",
+			},
+		},
+		{
+			name:     "mount parameters of elements in loops and conditionals",
+			template: `{if note: string?}<p {bind:note}>{note}</p>{/if}<ul>{for i, item in items: string[]}<li {bind:rows}>{item}</li>{/for}</ul><script type="ts">export function mount(rows: HTMLLIElement[], note?: HTMLParagraphElement | null) {}</script>`,
+			options:  Options{Runtime: "./guts-runtime"},
+			expected: []string{
+				"import { VERSION, htmlEncode } from './guts-runtime';",
+				"if (VERSION !== 4) throw new Error('./guts-runtime is version ' + VERSION + ', expected 4');",
+				"export interface model {",
+				"	items: string[];",
+				"	note?: string | null;",
+				"}",
+				"export interface refs {",
+				"	note?: HTMLParagraphElement | null;",
+				"	rows: HTMLLIElement[];",
+				"}",
+				"function mountScript(rows: HTMLLIElement[], note?: HTMLParagraphElement | null) {}",
+				"export const render = ({items, note}: model) => (`${(note) && (`<p data-bind-id=\"note\">${htmlEncode(note)}</p>`) || ''}<ul>${[...(Array.isArray(items) ? items.entries() : Object.entries(items))].map(([i, item]) => (`<li data-bind-id=\"rows\">${htmlEncode(item)}</li>`)).join('')}</ul>`);",
+				"export const mount = (root: ParentNode): refs => {",
+				"	const refs: refs = {",
+				"		note: root.querySelector<HTMLParagraphElement>(\"[data-bind-id='note']\"),",
+				"		rows: Array.from(root.querySelectorAll<HTMLLIElement>(\"[data-bind-id='rows']\")),",
+				"	};",
+				"	mountScript(refs.rows, refs.note);",
+				"	return refs;",
+				"};",
+			},
+		},
+		{
+			name:     "script without mount",
+			template: `<p>Hi</p><script type="ts">export const greeting = "Hi";</script>`,
+			options:  Options{DOM: true},
+			expected: []string{
+				"export interface model {",
+				"}",
+				"export interface refs {",
+				"}",
+				"export const greeting = \"Hi\";",
+				"export const render = ({}: model): { fragment: DocumentFragment; refs: refs } => {",
+				"	const fragment = document.createDocumentFragment();",
+				"	const refs = {} as refs;",
+				"	const e1 = document.createElement(\"p\");",
+				"	fragment.append(e1);",
+				"	e1.append(document.createTextNode(\"Hi\"));",
+				"	return { fragment, refs };",
+				"};",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := parser.Parse(strings.NewReader(tt.template))
+			if err != nil {
+				t.Fatalf("failed to parse template: %v", err)
+			}
+
+			var buf bytes.Buffer
+			err = GenerateWithOptions(document, &buf, tt.options)
+			if err != nil {
+				t.Fatalf("failed to generate template: %v", err)
+			}
+			assert.Equal(t, strings.Join(tt.expected, "\n"), buf.String())
+		})
+	}
+}
+
+func TestGenerate_ComponentScriptErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		options  Options
+		expected string
+	}{
+		{
+			name:     "missing bind",
+			template: `<button {bind:save}>Save</button><script type="ts">export function mount(save: HTMLButtonElement, undo: HTMLButtonElement) {}</script>`,
+			expected: "mount parameter undo has no matching {bind:undo}",
+		},
+		{
+			name:     "element of a loop",
+			template: `{for i, item in items: string[]}<li {bind:row}>{item}</li>{/for}<script type="ts">export function mount(row: HTMLLIElement) {}</script>`,
+			expected: "mount parameter row is bound in a loop and must accept HTMLLIElement[]",
+		},
+		{
+			name:     "element of a conditional",
+			template: `{if ready: bool}<button {bind:save}>Save</button>{/if}<script type="ts">export function mount(save: HTMLButtonElement | null) {}</script>`,
+			expected: "mount parameter save is bound in a conditional and must accept HTMLButtonElement | null | undefined",
+		},
+		{
+			name:     "mount declared twice",
+			template: "<script type=\"ts\">export function mount() {}</script>\n<script type=\"ts\">export const mount = () => {};</script>",
+			expected: "mount is declared by more than one script",
+		},
+		{
+			name:     "JavaScript",
+			template: `<script type="ts">export const greeting = "Hi";</script>`,
+			options:  Options{JavaScript: true},
+			expected: "<script type=\"ts\"> can only be generated as TypeScript",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := parser.Parse(strings.NewReader(tt.template))
+			if err != nil {
+				t.Fatalf("failed to parse template: %v", err)
+			}
+
+			err = GenerateWithOptions(document, io.Discard, tt.options)
+			assert.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestGenerateDeclarations(t *testing.T) {
+	document, err := parser.Parse(strings.NewReader(`{type Line = {sku: string}}{for i, line in lines: Line[]}{line.sku}{/for}`))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = GenerateDeclarations(document, &buf, Options{Name: "Order", DefaultExport: true, Validators: true, JavaScript: true})
+	if err != nil {
+		t.Fatalf("failed to generate declarations: %v", err)
+	}
+	assert.Equal(t, strings.Join([]string{
+		"export interface Line {",
+		"	sku: string;",
+		"}",
+		"export interface OrderModel {",
+		"	lines: Line[];",
+		"}",
+		"declare const renderOrder: (model: OrderModel) => string;",
+		"export declare class ModelError extends Error {",
+		"	readonly path: string;",
+		"	readonly expected: string;",
+		"	constructor(path: string, expected: string);",
+		"}",
+		"export declare function assertOrderModel(x: unknown): asserts x is OrderModel;",
+		"export declare function validateOrderModel(x: unknown): x is OrderModel;",
+		"export default renderOrder;",
+		"",
+	}, "\n"), buf.String())
+
+	document, err = parser.Parse(strings.NewReader(`<button {bind:save}>Save</button>`))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	buf.Reset()
+	err = GenerateDeclarations(document, &buf, Options{DOM: true, JavaScript: true})
+	if err != nil {
+		t.Fatalf("failed to generate declarations: %v", err)
+	}
+	assert.Equal(t, strings.Join([]string{
+		"export interface model {",
+		"}",
+		"export interface refs {",
+		"	save: HTMLButtonElement;",
+		"}",
+		"export declare const render: (model: model) => { fragment: DocumentFragment; refs: refs };",
+		"export declare const mount: (root: ParentNode) => refs;",
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestExportName(t *testing.T) {
+	tests := map[string]string{
+		"views/order-summary.guts": "OrderSummary",
+		"order_summary.html":       "OrderSummary",
+		"orderSummary.guts":        "OrderSummary",
+		"404.guts":                 "Template404",
+	}
+	for file, expected := range tests {
+		assert.Equal(t, expected, ExportName(file), file)
+	}
+}
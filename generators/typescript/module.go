@@ -0,0 +1,137 @@
+package typescript
+
+import (
+	"fmt"
+	"guts/parser/nodes"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ModuleFormat is the module system of a generated JavaScript module.
+type ModuleFormat string
+
+const (
+	ESM      ModuleFormat = "esm"
+	CommonJS ModuleFormat = "commonjs"
+)
+
+// _typeAnnotationRegex matches the TypeScript-only parts of the generator's
+// own code, such as the types of the helpers' parameters, written
+// value/*ts : unknown*/ so the same source is also valid JavaScript.
+var _typeAnnotationRegex = regexp.MustCompile(`/\*ts (.*?)\*/`)
+
+// module writes the declarations of a generated module as configured by its
+// options.
+type module struct {
+	options Options
+	// typeName is the name of the model interface, renderName the name of
+	// the render function, refsName the name of the interface of the bound
+	// elements, mountName the name of the function finding them and
+	// createName the name of the function building updatable nodes.
+	typeName   string
+	renderName string
+	refsName   string
+	mountName  string
+	createName string
+	// exports are the names a CommonJS module assigns to exports.
+	exports []string
+}
+
+func newModule(options Options) *module {
+	m := &module{options: options, typeName: "model", renderName: "render", refsName: "refs", mountName: "mount", createName: "create"}
+	if options.Name != "" {
+		m.typeName = options.Name + "Model"
+		m.renderName = "render" + options.Name
+		m.refsName = options.Name + "Refs"
+		m.mountName = "mount" + options.Name
+		m.createName = "create" + options.Name
+	}
+	return m
+}
+
+// checkNames reports a named type of a document declared with the name of the
+// model interface, which TypeScript would merge with it.
+func (m *module) checkNames(n nodes.Document) error {
+	if _, ok := n.GetNamedTypes()[m.typeName]; ok {
+		return fmt.Errorf("type %s has the name of the model", m.typeName)
+	}
+	return nil
+}
+
+// export returns the keyword exporting the declaration of name.
+func (m *module) export(name string) string {
+	if m.options.Module == CommonJS {
+		m.exports = append(m.exports, name)
+		return ""
+	}
+	return "export "
+}
+
+// exportRender returns the keyword exporting the render function, which is
+// not exported where it is declared if it is the default export.
+func (m *module) exportRender() string {
+	if m.options.DefaultExport {
+		return ""
+	}
+	return m.export(m.renderName)
+}
+
+// typed returns source, the generator's own code, with its type annotations
+// for TypeScript or without them for JavaScript. Lines that only declare
+// types are removed from JavaScript.
+func (m *module) typed(source string) string {
+	if !m.options.JavaScript {
+		return _typeAnnotationRegex.ReplaceAllString(source, "$1")
+	}
+	lines := strings.SplitAfter(source, "\n")
+	for i, line := range lines {
+		stripped := _typeAnnotationRegex.ReplaceAllString(line, "")
+		if stripped != line && strings.TrimSpace(stripped) == "" {
+			stripped = ""
+		}
+		lines[i] = stripped
+	}
+	return strings.Join(lines, "")
+}
+
+// trailer returns the statements ending the module: the default export and
+// the assignments to exports of a CommonJS module.
+func (m *module) trailer() string {
+	var statements []string
+	if m.options.Module == CommonJS {
+		for _, name := range m.exports {
+			statements = append(statements, "exports."+name+" = "+name+";")
+		}
+		if m.options.DefaultExport {
+			statements = append(statements, "exports.default = "+m.renderName+";")
+		}
+	} else if m.options.DefaultExport {
+		statements = append(statements, "export default "+m.renderName+";")
+	}
+	return strings.Join(statements, "\n")
+}
+
+// ExportName returns the base of the names exported by the module of a
+// template file, e.g. OrderSummary for order-summary.guts.
+func ExportName(file string) string {
+	base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	var name strings.Builder
+	upper := true
+	for _, r := range base {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		name.WriteRune(r)
+	}
+	if name.Len() == 0 || unicode.IsDigit([]rune(name.String())[0]) {
+		return "Template" + name.String()
+	}
+	return name.String()
+}
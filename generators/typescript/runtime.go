@@ -0,0 +1,154 @@
+package typescript
+
+import (
+	"fmt"
+	"guts/parser/nodes"
+	"io"
+	"strings"
+)
+
+// RuntimeVersion is the version of the module written by GenerateRuntime. It
+// is increased whenever a helper changes, and the modules importing their
+// helpers fail to load with a runtime of another version.
+const RuntimeVersion = 4
+
+// RuntimeFile is the base name of the runtime module in an output directory.
+const RuntimeFile = "guts-runtime"
+
+// helper is a declaration of the generator's own code that generated
+// modules call.
+type helper struct {
+	name string
+	// uses are the helpers it calls, which are declared before it.
+	uses   []string
+	source string
+}
+
+// runtimeHelpers returns all helpers, in the order they are declared.
+func runtimeHelpers() []helper {
+	helpers := append([]helper{}, escapers...)
+	helpers = append(helpers, domHelpers...)
+	helpers = append(helpers, updateHelpers...)
+	helpers = append(helpers, bindHelpers...)
+	helpers = append(helpers, formatterHelpers()...)
+	return append(helpers, validatorHelpers...)
+}
+
+func formatterHelpers() []helper {
+	helpers := make([]helper, len(formattedTypes))
+	for i, typ := range formattedTypes {
+		helpers[i] = formatters[typ]
+	}
+	return helpers
+}
+
+// usedHelpers returns the names of the helpers called by the module of a
+// document.
+func usedHelpers(n nodes.Document, options Options) map[string]bool {
+	used := map[string]bool{}
+	if options.DOM {
+		collectDOMHelpers(n, used)
+	} else {
+		collectEscapers(n, used)
+	}
+	if options.Update {
+		collectDOMHelpers(n, used)
+		collectUpdateHelpers(n, used)
+	}
+	collectFormatters(n, used)
+	for _, b := range collectBinds(n, false, false, nil) {
+		if !b.many && !b.optional {
+			used["requiredRef"] = true
+		}
+	}
+	if options.Validators {
+		for _, h := range validatorHelpers {
+			used[h.name] = true
+		}
+	}
+	return used
+}
+
+// withDependencies returns the used helpers and the helpers they call, which
+// a module declaring its helpers also declares.
+func withDependencies(used map[string]bool) map[string]bool {
+	declared := map[string]bool{}
+	for name := range used {
+		declared[name] = true
+	}
+	helpers := runtimeHelpers()
+	for i := len(helpers) - 1; i >= 0; i-- {
+		if declared[helpers[i].name] {
+			for _, name := range helpers[i].uses {
+				declared[name] = true
+			}
+		}
+	}
+	return declared
+}
+
+// writeHelpers declares the used helpers among helpers in the module.
+func writeHelpers(helpers []helper, used map[string]bool, m *module, w io.Writer) {
+	for _, h := range helpers {
+		if !used[h.name] {
+			continue
+		}
+		if h.name == "ModelError" {
+			writeString(w, m.export(h.name))
+		}
+		writeString(w, m.typed(h.source))
+	}
+}
+
+// generateImport imports the used helpers from the runtime module, and fails
+// when the runtime is of another version than the module was generated for.
+func generateImport(used map[string]bool, m *module, w io.Writer) {
+	names := []string{"VERSION"}
+	for _, h := range runtimeHelpers() {
+		if used[h.name] {
+			names = append(names, h.name)
+		}
+	}
+	if len(names) == 1 {
+		return
+	}
+	list := strings.Join(names, ", ")
+	if m.options.Module == CommonJS {
+		writeString(w, fmt.Sprintf("const { %s } = require('%s');\n", list, m.options.Runtime))
+	} else {
+		writeString(w, fmt.Sprintf("import { %s } from '%s';\n", list, m.options.Runtime))
+	}
+	writeString(w, fmt.Sprintf("if (VERSION !== %d) throw new Error('%s is version ' + VERSION + ', expected %d');\n",
+		RuntimeVersion, m.options.Runtime, RuntimeVersion))
+}
+
+// GenerateRuntime writes the runtime module the modules generated with
+// Options.Runtime import their helpers from. Every helper is a separate
+// export without side effects, so bundlers drop the ones that are not
+// imported.
+func GenerateRuntime(w io.Writer, options Options) error {
+	if options.Module == CommonJS && !options.JavaScript {
+		return fmt.Errorf("only JavaScript can be generated as a CommonJS module")
+	}
+
+	m := newModule(options)
+	writeString(w, fmt.Sprintf("// guts runtime %d. Generated, do not edit.\n", RuntimeVersion))
+	if options.Module == CommonJS {
+		writeString(w, "'use strict';\nObject.defineProperty(exports, '__esModule', { value: true });\n")
+	}
+	writeString(w, fmt.Sprintf("const VERSION = %d;\n", RuntimeVersion))
+	names := []string{"VERSION"}
+	for _, h := range runtimeHelpers() {
+		writeString(w, m.typed(h.source))
+		names = append(names, h.name)
+	}
+
+	if options.Module == CommonJS {
+		for _, name := range names {
+			writeString(w, "exports."+name+" = "+name+";\n")
+		}
+	} else {
+		writeString(w, "export { "+strings.Join(names, ", ")+" };\n")
+	}
+	return nil
+}
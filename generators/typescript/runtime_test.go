@@ -0,0 +1,160 @@
+package typescript
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"guts/parser"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateRuntime(t *testing.T) {
+	var buf bytes.Buffer
+	err := GenerateRuntime(&buf, Options{})
+	if err != nil {
+		t.Fatalf("failed to generate runtime: %v", err)
+	}
+	runtime := buf.String()
+
+	assert.True(t, strings.HasPrefix(runtime, "// guts runtime 4. Generated, do not edit.\nconst VERSION = 4;\n"))
+	assert.True(t, strings.HasSuffix(runtime, "\nexport { VERSION, htmlEncode, sanitizeUrl, urlEncode, scriptJson, cssEncode, urlAttributes, spreadAttributes, setAttributes, parseHtml, patchAttributes, region, keyedRegion, requiredRef, "+
+		"formatDate, formatDateTime, formatDuration, formatDecimal, ModelError, fail, isRecord, matches };\n"))
+	assert.Contains(t, runtime, "const htmlEncode = (value: unknown) =>")
+	assert.NotContains(t, runtime, "/*ts")
+
+	err = GenerateRuntime(&bytes.Buffer{}, Options{Module: CommonJS})
+	assert.EqualError(t, err, "only JavaScript can be generated as a CommonJS module")
+}
+
+func TestRuntimeHelpers(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	dir := t.TempDir()
+	var runtime bytes.Buffer
+	err = GenerateRuntime(&runtime, Options{JavaScript: true, Module: CommonJS})
+	if err != nil {
+		t.Fatalf("failed to generate runtime: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, RuntimeFile+".js"), runtime.Bytes(), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   any
+	}{
+		{"version", "r.VERSION", float64(RuntimeVersion)},
+		{"htmlEncode", `r.htmlEncode('<a href="x">Tom & Jerry\'s</a>')`, "&lt;a href=&quot;x&quot;&gt;Tom &amp; Jerry&#39;s&lt;/a&gt;"},
+		{"htmlEncode null", "r.htmlEncode(null)", ""},
+		{"htmlEncode number", "r.htmlEncode(42)", "42"},
+		{"sanitizeUrl https", "r.sanitizeUrl('https://example.com/?q=1')", "https://example.com/?q=1"},
+		{"sanitizeUrl relative", "r.sanitizeUrl('orders/1')", "orders/1"},
+		{"sanitizeUrl javascript", "r.sanitizeUrl('JavaScript:alert(1)')", "about:invalid#unsafe-url"},
+		{"urlEncode", "r.urlEncode('a b&c/d')", "a%20b%26c%2Fd"},
+		{"scriptJson", "r.scriptJson({ a: '</script>' })", `{"a":"\u003c/script\u003e"}`},
+		{"scriptJson undefined", "r.scriptJson(undefined)", "null"},
+		{"cssEncode", "r.cssEncode('red;}')", `red\3b \7d `},
+		{"spreadAttributes", `r.spreadAttributes({ title: 'a"b', href: 'javascript:x', onclick: 'x', 'a b': 'x' })`, `title="a&quot;b" href="about:invalid#unsafe-url"`},
+		{"setAttributes", `(() => { const attrs = {}; r.setAttributes({ setAttribute: (name, value) => attrs[name] = value }, { title: null, src: 'javascript:x', onload: 'x' }); return attrs; })()`, map[string]any{"title": "", "src": "about:invalid#unsafe-url"}},
+		{"patchAttributes", `(() => { const attrs = { lang: 'en', title: 'a' }; r.patchAttributes({ setAttribute: (name, value) => attrs[name] = value, removeAttribute: (name) => delete attrs[name] }, { lang: 'en', title: 'a' }, { title: 'b' }); return attrs; })()`, map[string]any{"title": "b"}},
+		{"patchAttributes of a form control", `(() => { const input = { value: 'edited', checked: true, setAttribute: () => {}, removeAttribute: () => {} }; r.patchAttributes(input, {}, { value: 42, checked: 0 }); return [input.value, input.checked]; })()`, []any{"42", false}},
+		{"requiredRef", "(() => { try { r.requiredRef(null, 'save'); } catch (e) { return [r.requiredRef('x', 'save'), e.message]; } })()", []any{"x", "missing bind save"}},
+		{"formatDate", "r.formatDate(null)", ""},
+		{"formatDuration", "[r.formatDuration('P1Y2M3WT4H'), r.formatDuration('PT0S')]", []any{"1y 2mo 3w 4h", "0s"}},
+		{"formatDecimal", "[r.formatDecimal('-1234567.50'), r.formatDecimal('12')]", []any{"-1,234,567.50", "12"}},
+		{"ModelError", "new r.ModelError('model.name', 'a string').message", "model.name: expected a string"},
+		{"fail", "(() => { try { r.fail('model', 'an object'); } catch (e) { return e instanceof r.ModelError && e.path; } })()", "model"},
+		{"isRecord", "[r.isRecord({}), r.isRecord([]), r.isRecord(null)]", []any{true, false, false}},
+		{"matches", "[r.matches(() => {}), r.matches(() => r.fail('model', 'x'))]", []any{true, false}},
+	}
+
+	expressions := make([]string, len(tests))
+	for i, tt := range tests {
+		expressions[i] = tt.expression
+	}
+	script := "const r = require('./" + RuntimeFile + ".js');\nconsole.log(JSON.stringify([\n" + strings.Join(expressions, ",\n") + "\n]));\n"
+	err = os.WriteFile(filepath.Join(dir, "test.js"), []byte(script), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(node, "test.js")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to run runtime: %v\n%s", err, out)
+	}
+	var results []any
+	err = json.Unmarshal(out, &results)
+	if err != nil {
+		t.Fatalf("failed to read results: %v\n%s", err, out)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, results[i])
+		})
+	}
+}
+
+// runtimeHashes are the hashes of the runtime of each version. A change of a
+// helper fails TestRuntimeVersion until RuntimeVersion is increased and the
+// hash of the new version is added.
+var runtimeHashes = map[int]string{
+	2: "b991e08af3e712d18f986741dad3aaa8f40038644b86638be48d4f08f63bb216",
+	3: "be1c68c8a441984509f3f9431e49d4c41d17f9e13bf6cbdccc3964ef3dbe45f5",
+	4: "aface421e8fb11300988135d1ae9f5a09cff09bcf6853278f71a5c14f8e17e24",
+}
+
+func TestRuntimeVersion(t *testing.T) {
+	var buf bytes.Buffer
+	err := GenerateRuntime(&buf, Options{})
+	if err != nil {
+		t.Fatalf("failed to generate runtime: %v", err)
+	}
+	hash := sha256.Sum256(buf.Bytes())
+	assert.Equal(t, runtimeHashes[RuntimeVersion], hex.EncodeToString(hash[:]), "a helper changed without increasing RuntimeVersion")
+
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	document, err := parser.Parse(strings.NewReader(`<p>{name: string}</p>`))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	dir := t.TempDir()
+	var module bytes.Buffer
+	err = GenerateWithOptions(document, &module, Options{JavaScript: true, Module: CommonJS, Runtime: "./" + RuntimeFile})
+	if err != nil {
+		t.Fatalf("failed to generate template: %v", err)
+	}
+	files := map[string]string{
+		"page.js":           module.String(),
+		RuntimeFile + ".js": "exports.VERSION = 1;\nexports.htmlEncode = String;\n",
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(node, "-e", "require('./page.js')")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(out), "./guts-runtime is version 1, expected 4")
+}
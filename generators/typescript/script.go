@@ -0,0 +1,211 @@
+package typescript
+
+import (
+	"fmt"
+	"guts/parser/nodes"
+	"guts/parser/nodes/attributes"
+	"regexp"
+	"strings"
+)
+
+// _scriptMountRegex matches the declaration of mount in a component script:
+// export function mount( or export const mount = (.
+var _scriptMountRegex = regexp.MustCompile(`(?m)^([ \t]*)export[ \t]+(async[ \t]+function[ \t]+|function[ \t]+|const[ \t]+)mount\b`)
+
+// _parameterNameRegex matches the name at the start of a parameter.
+var _parameterNameRegex = regexp.MustCompile(`^(?:\.\.\.)?([A-Za-z_$][\w$]*)`)
+
+// scriptMountName is the name of the mount function of the component
+// scripts, which the generated mount calls with the bound elements.
+const scriptMountName = "mountScript"
+
+// componentScript is the logic of a template, written in <script type="ts">
+// elements beside the markup.
+type componentScript struct {
+	source string
+	// mount is set if the script declares mount, whose parameters are the
+	// names of binds.
+	mount      bool
+	parameters []string
+}
+
+// isComponentScript reports whether n is a <script type="ts">, which is
+// compiled with the module instead of being rendered.
+func isComponentScript(n nodes.Node) bool {
+	element, ok := n.(nodes.Element)
+	if !ok || element.Name() != "script" {
+		return false
+	}
+	parts := attributeParts(element.Attributes().GetAttribute("type"))
+	if len(parts) != 1 {
+		return false
+	}
+	typ, ok := parts[0].(attributes.AttributeValueString)
+	return ok && string(typ) == "ts"
+}
+
+// collectScript returns the component scripts of a document, concatenated.
+func collectScript(n nodes.Document, binds []bind) (*componentScript, error) {
+	var sources []string
+	var collect func(n nodes.Node)
+	collect = func(n nodes.Node) {
+		if isComponentScript(n) {
+			var source strings.Builder
+			for _, child := range n.Children() {
+				if text, ok := child.(nodes.TextNode); ok {
+					source.WriteString(text.TextContent())
+				}
+			}
+			if source := dedent(source.String()); source != "" {
+				sources = append(sources, source)
+			}
+			return
+		}
+		for _, child := range descendants(n) {
+			collect(child)
+		}
+	}
+	collect(n)
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	script := &componentScript{source: strings.Join(sources, "\n")}
+	loc := _scriptMountRegex.FindStringSubmatchIndex(script.source)
+	if loc == nil {
+		return script, nil
+	}
+	if _scriptMountRegex.MatchString(script.source[loc[1]:]) {
+		return nil, fmt.Errorf("mount is declared by more than one script")
+	}
+	script.mount = true
+	parameters, err := mountParameters(script.source[loc[1]:])
+	if err != nil {
+		return nil, err
+	}
+	bound := map[string]bind{}
+	for _, b := range binds {
+		bound[b.name] = b
+	}
+	for _, parameter := range parameters {
+		b, ok := bound[parameter.name]
+		if !ok {
+			return nil, fmt.Errorf("mount parameter %s has no matching {bind:%s}", parameter.name, parameter.name)
+		}
+		if !parameter.accepts(b) {
+			where, typ := "a conditional", b.refType()+" | null | undefined"
+			if b.many {
+				where, typ = "a loop", b.refType()
+			}
+			return nil, fmt.Errorf("mount parameter %s is bound in %s and must accept %s", parameter.name, where, typ)
+		}
+		script.parameters = append(script.parameters, parameter.name)
+	}
+
+	// the generated mount is the one exported, it calls the script's, renamed
+	script.source = script.source[:loc[0]] + script.source[loc[2]:loc[3]] +
+		script.source[loc[4]:loc[5]] + scriptMountName + script.source[loc[1]:]
+	return script, nil
+}
+
+// mountParameter is a parameter of mount.
+type mountParameter struct {
+	name string
+	// typ is the annotation of the parameter, if any. optional is set for a
+	// parameter declared with a question mark.
+	typ      string
+	optional bool
+}
+
+// accepts reports whether the parameter is declared to receive the ref of b:
+// an array for an element in a loop, or null and undefined for one in a
+// conditional. Parameters without an annotation accept any ref.
+func (p mountParameter) accepts(b bind) bool {
+	switch {
+	case p.typ == "" || p.typ == "any" || p.typ == "unknown":
+		return true
+	case b.many:
+		return strings.HasSuffix(p.typ, "[]") || strings.HasPrefix(p.typ, "Array<") || strings.HasPrefix(p.typ, "ReadonlyArray<")
+	case b.optional:
+		members := map[string]bool{"undefined": p.optional}
+		for _, member := range strings.Split(p.typ, "|") {
+			members[strings.TrimSpace(member)] = true
+		}
+		return members["null"] && members["undefined"]
+	}
+	return true
+}
+
+// mountParameters returns the parameters of mount, declared by source, which
+// starts right after the name of the function.
+func mountParameters(source string) ([]mountParameter, error) {
+	start := strings.IndexByte(source, '(')
+	if start < 0 {
+		return nil, fmt.Errorf("mount must be a function")
+	}
+	var parameters []mountParameter
+	var parameter strings.Builder
+	depth := 0
+	previous := '('
+	for _, r := range source[start+1:] {
+		arrow := previous == '=' && r == '>'
+		previous = r
+		switch {
+		case depth == 0 && (r == ',' || r == ')'):
+			if text := strings.TrimSpace(parameter.String()); text != "" {
+				match := _parameterNameRegex.FindStringSubmatch(text)
+				if match == nil {
+					return nil, fmt.Errorf("unsupported mount parameter: %s", text)
+				}
+				parameter := mountParameter{name: match[1]}
+				rest := strings.TrimSpace(text[len(match[0]):])
+				rest, parameter.optional = strings.CutPrefix(rest, "?")
+				if typ, ok := strings.CutPrefix(strings.TrimSpace(rest), ":"); ok {
+					parameter.typ = strings.TrimSpace(typ)
+				}
+				parameters = append(parameters, parameter)
+			}
+			if r == ')' {
+				return parameters, nil
+			}
+			parameter.Reset()
+			continue
+		case strings.ContainsRune("([{<", r):
+			depth++
+		case strings.ContainsRune(")]}>", r) && !arrow:
+			depth--
+		}
+		parameter.WriteRune(r)
+	}
+	return nil, fmt.Errorf("unterminated mount parameters")
+}
+
+// dedent removes the blank lines around source and the indentation common to
+// its lines.
+func dedent(source string) string {
+	lines := strings.Split(strings.TrimRight(source, " \t\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	indent := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		prefix := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			indent, first = prefix, false
+			continue
+		}
+		i := 0
+		for i < len(indent) && i < len(prefix) && indent[i] == prefix[i] {
+			i++
+		}
+		indent = indent[:i]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, indent)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,396 @@
+package typescript
+
+import (
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"guts/parser/nodes/attributes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// updateHelpers are the helpers of create, in addition to the helpers of DOM
+// output.
+var updateHelpers = []helper{
+	{"patchAttributes", []string{"setAttributes"}, `const patchAttributes = (element/*ts : Element*/, previous/*ts : Record<string, unknown>*/, attrs/*ts : Record<string, unknown>*/) => {
+	for (const name of Object.keys(previous ?? {})) {
+		if (!Object.prototype.hasOwnProperty.call(attrs ?? {}, name)) element.removeAttribute(name);
+	}
+	setAttributes(element, attrs ?? {});
+	for (const name of ['value', 'checked', 'selected']) {
+		// once edited, a form control no longer follows its attributes
+		if (name in element && Object.prototype.hasOwnProperty.call(attrs ?? {}, name)) {
+			Reflect.set(element, name, name === 'value' ? String(attrs[name] ?? '') : Boolean(attrs[name]));
+		}
+	}
+};
+`},
+	{"region", nil, `const region = (parent/*ts : ParentNode*/, render/*ts : () => DocumentFragment*/) => {
+	const end = document.createComment('');
+	let nodes/*ts : ChildNode[]*/ = [];
+	const update = () => {
+		for (const node of nodes) node.remove();
+		const fragment = render();
+		nodes = Array.from(fragment.childNodes);
+		end.before(fragment);
+	};
+	parent.append(end);
+	update();
+	return { update };
+};
+`},
+	{"keyedRegion", nil, `const keyedRegion = /*ts <K, T>*/(parent/*ts : ParentNode*/, entries/*ts : () => Iterable<[K, T]>*/, key/*ts : (index: K, item: T) => unknown*/, indexed/*ts : boolean*/, render/*ts : (index: K, item: T) => DocumentFragment*/) => {
+	const end = document.createComment('');
+	let rows = new Map/*ts <unknown, { index: K; item: T; nodes: ChildNode[] }>*/();
+	const update = (rebuild = false) => {
+		// the keys are checked before any row is touched
+		const keyed = new Map/*ts <unknown, [K, T]>*/();
+		for (const [index, item] of entries()) {
+			const k = key(index, item);
+			if (keyed.has(k)) throw new Error('duplicate loop key ' + String(k));
+			keyed.set(k, [index, item]);
+		}
+		const next = new Map/*ts <unknown, { index: K; item: T; nodes: ChildNode[] }>*/();
+		for (const [k, [index, item]] of keyed) {
+			let row = rows.get(k);
+			rows.delete(k);
+			if (row && (rebuild || row.item !== item || indexed && row.index !== index)) {
+				for (const node of row.nodes) node.remove();
+				row = undefined;
+			}
+			if (!row) {
+				const fragment = render(index, item);
+				row = { index, item, nodes: Array.from(fragment.childNodes) };
+			}
+			next.set(k, row);
+		}
+		for (const row of rows.values()) {
+			for (const node of row.nodes) node.remove();
+		}
+		// only the nodes out of place are moved, as a moved element loses focus
+		let anchor/*ts : ChildNode*/ = end;
+		for (const row of Array.from(next.values()).reverse()) {
+			for (const node of Array.from(row.nodes).reverse()) {
+				if (node.nextSibling !== anchor) anchor.before(node);
+				anchor = node;
+			}
+		}
+		rows = next;
+	};
+	const select = /*ts <E extends Element>*/(selector/*ts : string*/) => {
+		const elements/*ts : E[]*/ = [];
+		for (const row of rows.values()) {
+			for (const node of row.nodes) {
+				if (!(node instanceof Element)) continue;
+				if (node.matches(selector)) elements.push(node/*ts  as E*/);
+				elements.push(...Array.from(node.querySelectorAll/*ts <E>*/(selector)));
+			}
+		}
+		return elements;
+	};
+	parent.append(end);
+	update();
+	return { update, select };
+};
+`},
+}
+
+// collectUpdateHelpers adds the helpers update needs for the nodes it
+// patches, outside of conditional and loop blocks, to used.
+func collectUpdateHelpers(n nodes.Node, used map[string]bool) {
+	for _, child := range n.Children() {
+		switch child := child.(type) {
+		case nodes.LoopBlock:
+			if child.Key() != "" {
+				used["keyedRegion"] = true
+			} else {
+				used["region"] = true
+			}
+			continue
+		case nodes.ConditionalBlock:
+			used["region"] = true
+			continue
+		case nodes.OutputBlock:
+			if textEscaper(child) == "" {
+				used["region"] = true
+			}
+		case nodes.Element:
+			if spread := child.Attributes().GetSpreadAttribute(); spread != nil && !spread.IsEmpty() {
+				used["patchAttributes"] = true
+			}
+		}
+		collectUpdateHelpers(child, used)
+	}
+}
+
+// viewType returns the interface of the value returned by create.
+func viewType(m *module) string {
+	return "{ fragment: DocumentFragment; refs: " + m.refsName + "; update: (model: " + m.typeName + ") => void }"
+}
+
+// patch is a statement of update, run when one of the fields it depends on
+// has changed.
+type patch struct {
+	fields     []string
+	statements []string
+}
+
+// generateCreate writes create, which builds the nodes of a document like
+// DOM output and returns them with update. update patches the text nodes and
+// attributes depending on the fields of the model that changed, compared by
+// identity, and rebuilds the conditional and loop blocks depending on them.
+func generateCreate(n nodes.Document, m *module, fields []string, binds []bind, w io.Writer) {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i], _, _ = strings.Cut(field, " = ")
+	}
+	b := &domBuilder{binds: map[string]bind{}, tracked: true, fields: names}
+	var arrays []string
+	for _, bind := range binds {
+		b.binds[bind.name] = bind
+		if bind.many {
+			arrays = append(arrays, propertyName(bind.name)+": []/*ts  as "+bind.refType()+"*/")
+		}
+	}
+	refs := "{}"
+	if len(arrays) > 0 {
+		refs = "{ " + strings.Join(arrays, ", ") + " }"
+	}
+	b.line(1, "const fragment = document.createDocumentFragment();")
+	b.line(1, m.typed("const refs = "+refs+"/*ts  as "+m.refsName+"*/;"))
+	b.children(n, "fragment", 1)
+	b.line(1, m.typed("const update = (next/*ts : "+m.typeName+"*/) => {"))
+	if len(b.patches) > 0 {
+		b.line(2, "const previous = { "+strings.Join(names, ", ")+" };")
+		b.line(2, "({ "+strings.Join(fields, ", ")+" } = next);")
+	}
+	for _, p := range b.patches {
+		changed := make([]string, len(p.fields))
+		for i, field := range p.fields {
+			changed[i] = field + " !== previous." + field
+		}
+		if len(p.statements) == 1 {
+			b.line(2, m.typed("if ("+strings.Join(changed, " || ")+") "+p.statements[0]))
+			continue
+		}
+		b.line(2, "if ("+strings.Join(changed, " || ")+") {")
+		for _, statement := range p.statements {
+			b.line(3, m.typed(statement))
+		}
+		b.line(2, "}")
+	}
+	b.line(1, "};")
+	b.line(1, "return { fragment, refs, update };")
+
+	writeString(w, m.export(m.createName))
+	writeString(w, "const "+m.createName+" = ({"+strings.Join(fields, ", "))
+	writeString(w, m.typed("}/*ts : "+m.typeName+"*/)/*ts : "+viewType(m)+"*/ => {\n"))
+	writeString(w, b.String())
+	writeString(w, "};")
+}
+
+// trackedNode writes the statements building a child of a node update
+// patches, and reports whether it handled it: outputs become text nodes
+// update sets, and conditional and loop blocks and raw outputs regions it
+// rebuilds.
+func (b *domBuilder) trackedNode(n nodes.Node, parent string, depth int) bool {
+	switch n := n.(type) {
+	case nodes.OutputBlock:
+		value, raw := domOutputValue(n)
+		b.vars++
+		if raw {
+			r := "r" + strconv.Itoa(b.vars)
+			b.line(depth, "const "+r+" = region("+parent+", () => parseHtml("+value+"));")
+			b.patch(n, r+".update();")
+			return true
+		}
+		t := "t" + strconv.Itoa(b.vars)
+		b.line(depth, "const "+t+" = document.createTextNode("+value+");")
+		b.line(depth, parent+".append("+t+");")
+		b.patch(n, t+".data = "+value+";")
+		return true
+	case nodes.LoopBlock:
+		if n.Key() != "" {
+			b.keyedLoop(n, parent, depth)
+			return true
+		}
+		b.region(n, parent, depth)
+		return true
+	case nodes.ConditionalBlock:
+		b.region(n, parent, depth)
+		return true
+	}
+	return false
+}
+
+// region writes a region update rebuilds when n, a conditional or loop block,
+// depends on a changed field.
+func (b *domBuilder) region(n nodes.Node, parent string, depth int) {
+	b.vars++
+	r := "r" + strconv.Itoa(b.vars)
+	b.line(depth, "const "+r+" = region("+parent+", () => {")
+	b.line(depth+1, "const fragment = document.createDocumentFragment();")
+	b.tracked = false
+	b.node(n, "fragment", depth+1)
+	b.tracked = true
+	b.line(depth+1, "return fragment;")
+	b.line(depth, "});")
+	// the refs of the rebuilt elements are set again
+	var statements []string
+	for _, bind := range collectBinds(n, false, false, nil) {
+		if b.binds[bind.name].many {
+			statements = append(statements, propertyAccess("refs", bind.name)+".length = 0;")
+		} else {
+			statements = append(statements, propertyAccess("refs", bind.name)+" = null;")
+		}
+	}
+	b.patch(n, append(statements, r+".update();")...)
+}
+
+// keyedLoop writes the region of a keyed loop: update moves the rows of the
+// items that are kept, builds the rows of new or changed items and removes
+// the others. All rows are rebuilt if another field the loop depends on has
+// changed.
+func (b *domBuilder) keyedLoop(n nodes.LoopBlock, parent string, depth int) {
+	b.vars++
+	r := "r" + strconv.Itoa(b.vars)
+	items := n.ItemsKey()
+	if isNullableKey(n, items) {
+		items = "(" + items + " ?? [])"
+	}
+	variables := "(" + n.IndexKey() + ", " + n.ValueKey() + ")"
+	// the rows of moved items are rebuilt only if they print their index
+	used := map[string]bool{}
+	index := &domBuilder{fields: []string{n.IndexKey()}}
+	for _, child := range n.Children() {
+		index.nodeFields(child, used)
+	}
+	b.line(depth, "const "+r+" = keyedRegion("+parent+", () => (Array.isArray("+items+") ? "+items+".entries() : Object.entries("+items+")), "+
+		variables+" => "+n.Key()+", "+strconv.FormatBool(used[n.IndexKey()])+", "+variables+" => {")
+	b.line(depth+1, "const fragment = document.createDocumentFragment();")
+	b.tracked = false
+	b.children(n, "fragment", depth+1)
+	b.tracked = true
+	b.line(depth+1, "return fragment;")
+	b.line(depth, "});")
+
+	fields := map[string]bool{}
+	b.nodeFields(n, fields)
+	var rebuild []string
+	for _, field := range b.ordered(fields) {
+		if field != n.ItemsKey() {
+			rebuild = append(rebuild, field+" !== previous."+field)
+		}
+	}
+	statements := []string{r + ".update(" + strings.Join(rebuild, " || ") + ");"}
+	// the kept rows are not built again, the refs are found in the rows
+	for _, bind := range collectBinds(n, false, false, nil) {
+		statements = append(statements,
+			propertyAccess("refs", bind.name)+".length = 0;",
+			propertyAccess("refs", bind.name)+".push(..."+r+".select/*ts <"+bind.typ+">*/("+jsString(bind.selector())+"));")
+	}
+	b.patch(n, statements...)
+}
+
+// trackAttribute records the statement setting an attribute of an element,
+// run again by update if the attribute has outputs.
+func (b *domBuilder) trackAttribute(value attributes.AttributeValue, statement string) {
+	if b.tracked {
+		b.addPatch(b.valueFields(value), statement)
+	}
+}
+
+// patch records the statements of update patching n.
+func (b *domBuilder) patch(n nodes.Node, statements ...string) {
+	used := map[string]bool{}
+	b.nodeFields(n, used)
+	b.addPatch(b.ordered(used), statements...)
+}
+
+func (b *domBuilder) addPatch(fields []string, statements ...string) {
+	if len(fields) > 0 {
+		b.patches = append(b.patches, patch{fields: fields, statements: statements})
+	}
+}
+
+// valueFields returns the fields of the model an attribute value depends on.
+func (b *domBuilder) valueFields(value attributes.AttributeValue) []string {
+	used := map[string]bool{}
+	for _, part := range attributeParts(value) {
+		switch part := part.(type) {
+		case attributes.AttributeValueExpression:
+			b.keyField(part.Key(), used)
+		case attributes.AttributeValueSpread:
+			b.keyField(part.Key(), used)
+		}
+	}
+	return b.ordered(used)
+}
+
+// nodeFields adds the fields of the model n and its descendants depend on to
+// used.
+func (b *domBuilder) nodeFields(n nodes.Node, used map[string]bool) {
+	switch n := n.(type) {
+	case nodes.OutputBlock:
+		if n.Expression() != nil {
+			b.expressionFields(n.Expression(), used)
+		} else {
+			b.keyField(n.Key(), used)
+		}
+	case nodes.ConditionalBlock:
+		for next := n; next != nil; next = next.Next() {
+			b.expressionFields(next.Condition(), used)
+		}
+	case nodes.LoopBlock:
+		b.keyField(n.ItemsKey(), used)
+	case nodes.Element:
+		n.Attributes().Iterator()(func(key string, value attributes.AttributeValue) bool {
+			for _, field := range b.valueFields(value) {
+				used[field] = true
+			}
+			return true
+		})
+		if spread := n.Attributes().GetSpreadAttribute(); spread != nil && !spread.IsEmpty() {
+			b.keyField(spread.Key(), used)
+		}
+	}
+	for _, child := range descendants(n) {
+		b.nodeFields(child, used)
+	}
+}
+
+func (b *domBuilder) expressionFields(n expressions.BooleanExpression, used map[string]bool) {
+	if n == nil {
+		return
+	}
+	if n.Literal() != "" {
+		b.keyField(n.Literal(), used)
+		return
+	}
+	b.expressionFields(n.Inner(), used)
+	b.expressionFields(n.Left(), used)
+	b.expressionFields(n.Right(), used)
+}
+
+// keyField adds the field of the model a key such as order.total reads to
+// used. Literals and loop variables are not fields.
+func (b *domBuilder) keyField(key string, used map[string]bool) {
+	root, _, _ := strings.Cut(key, ".")
+	root = strings.TrimSuffix(root, "?")
+	for _, field := range b.fields {
+		if field == root {
+			used[root] = true
+		}
+	}
+}
+
+// ordered returns the fields in used in the order of the model.
+func (b *domBuilder) ordered(used map[string]bool) []string {
+	var fields []string
+	for _, field := range b.fields {
+		if used[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,224 @@
+package typescript
+
+import (
+	"fmt"
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// validatorHelpers are shared by the generated assertion functions. Failures
+// throw a ModelError with the path of the invalid value, e.g.
+// model.lines[2].qty.
+var validatorHelpers = []helper{
+	{"ModelError", nil, `class ModelError extends Error {
+	/*ts readonly path: string;*/
+	/*ts readonly expected: string;*/
+	constructor(path/*ts : string*/, expected/*ts : string*/) {
+		super(` + "`${path}: expected ${expected}`" + `);
+		this.path = path;
+		this.expected = expected;
+	}
+}
+`},
+	{"fail", []string{"ModelError"}, `function fail(path/*ts : string*/, expected/*ts : string*/)/*ts : never*/ {
+	throw new ModelError(path, expected);
+}
+`},
+	{"isRecord", nil, `const isRecord = (x/*ts : unknown*/)/*ts : x is Record<string, unknown>*/ => typeof x === 'object' && x !== null && !Array.isArray(x);
+`},
+	{"matches", []string{"ModelError"}, `const matches = (check/*ts : () => void*/) => {
+	try {
+		check();
+		return true;
+	} catch (e) {
+		if (e instanceof ModelError) return false;
+		throw e;
+	}
+};
+`},
+}
+
+// generateValidators writes assertModel, which throws a ModelError for the
+// first value that does not match the model, and validateModel, a type guard.
+// The named types are asserted by assertType_<Name>, which cannot collide
+// with assertModel.
+func generateValidators(n nodes.Document, m *module, used map[string]bool, w io.Writer) {
+	if m.options.Runtime == "" {
+		writeHelpers(validatorHelpers, used, m, w)
+	} else if m.options.Module == CommonJS {
+		m.export("ModelError")
+	} else {
+		writeString(w, "export { ModelError };\n")
+	}
+
+	namedTypes := n.GetNamedTypes()
+	for _, name := range sortedNames(namedTypes) {
+		v := &validator{}
+		v.line(1, "if (!isRecord(x)) return fail(path, 'an object');")
+		for _, field := range namedTypes[name].Fields() {
+			v.assert(field.Type, "x["+strconv.Quote(field.Name)+"]", "${path}."+templateLiteralEscaper.Replace(field.Name), 1)
+		}
+		writeString(w, m.typed(fmt.Sprintf("function assertType_%s(x/*ts : unknown*/, path/*ts : string*/)/*ts : asserts x is %s*/ {\n", name, name)))
+		writeString(w, v.String())
+		writeString(w, "}\n")
+	}
+
+	v := &validator{}
+	v.line(1, "if (!isRecord(x)) return fail('model', 'an object');")
+	for _, field := range modelFields(n) {
+		v.assert(field.Type, "x["+strconv.Quote(field.Name)+"]", "model."+templateLiteralEscaper.Replace(field.Name), 1)
+	}
+	suffix := strings.ToUpper(m.typeName[:1]) + m.typeName[1:]
+	writeString(w, m.export("assert"+suffix))
+	writeString(w, m.typed(fmt.Sprintf("function assert%s(x/*ts : unknown*/)/*ts : asserts x is %s*/ {\n", suffix, m.typeName)))
+	writeString(w, v.String())
+	writeString(w, "}\n")
+	writeString(w, m.export("validate"+suffix))
+	writeString(w, m.typed(fmt.Sprintf("function validate%s(x/*ts : unknown*/)/*ts : x is %s*/ {\n", suffix, m.typeName)))
+	writeString(w, fmt.Sprintf("\treturn matches(() => assert%s(x));\n", suffix))
+	writeString(w, "}")
+}
+
+// modelFields returns the fields of the model of a document. Props with a
+// default value may be omitted.
+func modelFields(n nodes.Document) []expressions.ObjectField {
+	var fields []expressions.ObjectField
+	if n.Strict() {
+		for _, prop := range n.Props() {
+			typ := prop.Type
+			if prop.HasDefault {
+				typ = typ.WithOptional(true)
+			}
+			fields = append(fields, expressions.ObjectField{Name: prop.Name, Type: typ})
+		}
+		return fields
+	}
+	declared := n.GetDeclaredTypes()
+	for _, name := range sortedNames(declared) {
+		fields = append(fields, expressions.ObjectField{Name: name, Type: declared[name]})
+	}
+	return fields
+}
+
+// validator writes the statements of an assertion function.
+type validator struct {
+	strings.Builder
+	vars int
+}
+
+func (v *validator) line(depth int, s string) {
+	v.WriteString(strings.Repeat("\t", depth))
+	v.WriteString(s)
+	v.WriteString("\n")
+}
+
+// local binds value to a new constant, so TypeScript narrows its type.
+func (v *validator) local(value string, depth int) string {
+	v.vars++
+	name := "v" + strconv.Itoa(v.vars)
+	v.line(depth, "const "+name+" = "+value+";")
+	return name
+}
+
+// assert writes the statements checking that value, found at path, is of
+// type t. path is the content of a template literal.
+func (v *validator) assert(t expressions.ExpressionType, value, path string, depth int) {
+	if t.Optional() {
+		v.line(depth, "if ("+value+" != null) {")
+		v.assert(t.WithOptional(false), value, path, depth+1)
+		v.line(depth, "}")
+		return
+	}
+
+	fail := func(condition, expected string) {
+		v.line(depth, "if ("+condition+") return fail(`"+path+"`, "+strconv.Quote(expected)+");")
+	}
+	if literal, ok := t.Literal(); ok {
+		fail(value+" !== "+strconv.Quote(literal), strconv.Quote(literal))
+		return
+	}
+
+	switch t.BaseType() {
+	case expressions.ExpressionBaseTypeString:
+		fail("typeof "+value+" !== 'string'", "a string")
+	case expressions.ExpressionBaseTypeInt:
+		fail("!Number.isInteger("+value+")", "an integer")
+	case expressions.ExpressionBaseTypeFloat:
+		fail("typeof "+value+" !== 'number'", "a number")
+	case expressions.ExpressionBaseTypeBool:
+		fail("typeof "+value+" !== 'boolean'", "a boolean")
+	case expressions.ExpressionBaseTypeDate:
+		fail("typeof "+value+" !== 'string' || !/^\\d{4}-\\d{2}-\\d{2}$/.test("+value+")", "a date")
+	case expressions.ExpressionBaseTypeDateTime:
+		fail("!("+value+" instanceof Date) && (typeof "+value+" !== 'string' || isNaN(Date.parse("+value+")))", "a datetime")
+	case expressions.ExpressionBaseTypeDuration:
+		// the same durations as expressions.ValidValue
+		fail("typeof "+value+" !== 'string' || !/^-?P(?!$)(\\d+Y)?(\\d+M)?(\\d+W)?(\\d+D)?(T(?!$)(\\d+H)?(\\d+M)?(\\d+(\\.\\d+)?S)?)?$/.test("+value+")", "a duration")
+	case expressions.ExpressionBaseTypeDecimal:
+		fail("typeof "+value+" !== 'string' || !/^-?\\d+(\\.\\d+)?$/.test("+value+")", "a decimal string")
+	case expressions.ExpressionBaseTypeArray:
+		array := v.local(value, depth)
+		v.line(depth, "if (!Array.isArray("+array+")) return fail(`"+path+"`, 'an array');")
+		v.vars++
+		index, element := "i"+strconv.Itoa(v.vars), "e"+strconv.Itoa(v.vars)
+		v.line(depth, "for (const ["+index+", "+element+"] of "+array+".entries()) {")
+		v.assert(t.ValueType(), element, path+"[${"+index+"}]", depth+1)
+		v.line(depth, "}")
+	case expressions.ExpressionBaseTypeMap:
+		record := v.local(value, depth)
+		v.line(depth, "if (!isRecord("+record+")) return fail(`"+path+"`, 'an object');")
+		v.vars++
+		key, element := "k"+strconv.Itoa(v.vars), "e"+strconv.Itoa(v.vars)
+		v.line(depth, "for (const ["+key+", "+element+"] of Object.entries("+record+")) {")
+		if t.KeyType().BaseType() == expressions.ExpressionBaseTypeInt {
+			v.line(depth+1, "if (!/^-?\\d+$/.test("+key+")) return fail(`"+path+"`, 'integer keys');")
+		}
+		v.assert(t.ValueType(), element, path+"[${JSON.stringify("+key+")}]", depth+1)
+		v.line(depth, "}")
+	case expressions.ExpressionBaseTypeObject:
+		if t.Name() != "" {
+			v.line(depth, "assertType_"+t.Name()+"("+value+", `"+path+"`);")
+			return
+		}
+		record := v.local(value, depth)
+		v.line(depth, "if (!isRecord("+record+")) return fail(`"+path+"`, 'an object');")
+		for _, field := range t.Fields() {
+			v.assert(field.Type, record+"["+strconv.Quote(field.Name)+"]", path+"."+templateLiteralEscaper.Replace(field.Name), depth)
+		}
+	case expressions.ExpressionBaseTypeUnion:
+		if literals, ok := literalMembers(t); ok {
+			conditions := make([]string, len(literals))
+			for i, literal := range literals {
+				conditions[i] = value + " !== " + strconv.Quote(literal)
+			}
+			fail(strings.Join(conditions, " && "), getTypeScriptType(t))
+			return
+		}
+		// closures do not keep the narrowed type of x
+		value = v.local(value, depth)
+		members := make([]string, len(t.Members()))
+		for i, member := range t.Members() {
+			inner := &validator{vars: v.vars}
+			inner.assert(member, value, path, depth+1)
+			v.vars = inner.vars
+			members[i] = "matches(() => {\n" + inner.String() + strings.Repeat("\t", depth) + "})"
+		}
+		fail("!("+strings.Join(members, " || ")+")", getTypeScriptType(t))
+	}
+}
+
+// literalMembers returns the values of a union of literal types.
+func literalMembers(t expressions.ExpressionType) ([]string, bool) {
+	literals := make([]string, len(t.Members()))
+	for i, member := range t.Members() {
+		literal, ok := member.Literal()
+		if !ok || member.Optional() {
+			return nil, false
+		}
+		literals[i] = literal
+	}
+	return literals, true
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"guts/generators/typescript"
 	"guts/parser"
+	"guts/parser/jsonschema"
+	"guts/parser/nodes"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,11 +17,54 @@ import (
 	"github.com/bmatcuk/doublestar/v4"
 )
 
+// output is a file written for each template.
+type output struct {
+	extension string
+	generate  func(document nodes.Document, w io.Writer, options typescript.Options) error
+}
+
 func main() {
+	var options typescript.Options
+	flag.BoolVar(&options.Validators, "validators", false, "add assertModel and validateModel to the generated modules")
+	names := flag.Bool("names", false, "derive the exported names from the file names, e.g. renderOrderSummary for order-summary.guts")
+	flag.BoolVar(&options.DefaultExport, "default-export", false, "export the render function as the default export")
+	module := flag.String("module", string(typescript.ESM), "the module system of JavaScript output: esm or commonjs")
+	flag.BoolVar(&options.JavaScript, "js", false, "write JavaScript and a .d.ts declaration file instead of TypeScript")
+	flag.BoolVar(&options.DOM, "dom", false, "build the nodes with document.createElement instead of rendering an HTML string")
+	flag.BoolVar(&options.Update, "update", false, "add create, which builds the nodes and returns them with an update function applying new models")
+	inlineHelpers := flag.Bool("inline-helpers", false, "declare the helpers in every module instead of importing them from "+typescript.RuntimeFile)
 	flag.Parse()
 
+	options.Module = typescript.ModuleFormat(*module)
+	if options.Module != typescript.ESM && options.Module != typescript.CommonJS {
+		fmt.Fprintf(os.Stderr, "invalid module system: %s\n", *module)
+		os.Exit(2)
+	}
+
+	// guts schema <patterns> writes the JSON Schema of each template's model
+	// instead of its TypeScript module
+	args := flag.Args()
+	outputs := []output{{".ts", generateTypeScript}}
+	if options.JavaScript {
+		outputs = []output{{".js", generateTypeScript}, {".d.ts", generateDeclarations}}
+	}
+	runtimeExtension := ".ts"
+	if options.JavaScript {
+		runtimeExtension = ".js"
+	}
+	if len(args) > 0 && args[0] == "schema" {
+		args = args[1:]
+		outputs = []output{{".schema.json", generateSchema}}
+	} else if !*inlineHelpers {
+		options.Runtime = "./" + typescript.RuntimeFile
+		if options.JavaScript && options.Module == typescript.ESM {
+			// ES modules are resolved without adding extensions
+			options.Runtime += ".js"
+		}
+	}
+
 	var files []string
-	for _, pattern := range flag.Args() {
+	for _, pattern := range args {
 		matches, err := doublestar.FilepathGlob(pattern)
 		if err != nil {
 			panic(err)
@@ -27,6 +74,8 @@ func main() {
 
 	fmt.Printf("Found %d files\n", len(files))
 
+	// the runtime is written once to each directory with generated modules
+	runtimes := map[string]bool{}
 	for _, file := range files {
 		fmt.Println(file)
 
@@ -36,20 +85,65 @@ func main() {
 		}
 		defer reader.Close()
 
-		document, err := parser.Parse(bufio.NewReader(reader))
+		document, err := parser.ParseWithOptions(bufio.NewReader(reader), parser.Options{Dir: filepath.Dir(file)})
 		if err != nil {
 			panic(err)
 		}
 
-		outputFile := strings.TrimSuffix(file, filepath.Ext(file)) + ".ts"
-		writer, err := os.Create(outputFile)
-		if err != nil {
-			panic(err)
+		for _, warning := range document.Warnings() {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s\n", file, warning)
+		}
+
+		if *names {
+			options.Name = typescript.ExportName(file)
+		}
+		if dir := filepath.Dir(file); options.Runtime != "" && !runtimes[dir] {
+			runtimes[dir] = true
+			writeRuntime(filepath.Join(dir, typescript.RuntimeFile+runtimeExtension), options)
 		}
-		defer writer.Close()
+		for _, output := range outputs {
+			outputFile := strings.TrimSuffix(file, filepath.Ext(file)) + output.extension
+			writer, err := os.Create(outputFile)
+			if err != nil {
+				panic(err)
+			}
+			defer writer.Close()
 
-		typescript.Generate(document, writer)
+			err = output.generate(document, writer, options)
+			if err != nil {
+				panic(err)
+			}
 
-		fmt.Println(outputFile)
+			fmt.Println(outputFile)
+		}
+	}
+}
+
+func writeRuntime(file string, options typescript.Options) {
+	writer, err := os.Create(file)
+	if err != nil {
+		panic(err)
 	}
+	defer writer.Close()
+
+	err = typescript.GenerateRuntime(writer, options)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(file)
+}
+
+func generateTypeScript(document nodes.Document, w io.Writer, options typescript.Options) error {
+	return typescript.GenerateWithOptions(document, w, options)
+}
+
+func generateDeclarations(document nodes.Document, w io.Writer, options typescript.Options) error {
+	return typescript.GenerateDeclarations(document, w, options)
+}
+
+func generateSchema(document nodes.Document, w io.Writer, _ typescript.Options) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(jsonschema.Generate(document))
 }
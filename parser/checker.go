@@ -0,0 +1,568 @@
+package parser
+
+import (
+	"fmt"
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"guts/parser/nodes/attributes"
+	"strconv"
+	"strings"
+)
+
+type checker struct {
+	document nodes.Document
+	warned   map[string]bool
+}
+
+// guards is the set of paths known not to be null, e.g. user and user.address
+// inside {if user?.address}.
+type guards map[string]bool
+
+// with returns a copy of g that also contains paths and their prefixes.
+func (g guards) with(paths []string) guards {
+	result := make(guards, len(g)+len(paths))
+	for path := range g {
+		result[path] = true
+	}
+	for _, path := range paths {
+		parts := strings.Split(path, ".")
+		for i := range parts {
+			result[strings.Join(parts[:i+1], ".")] = true
+		}
+	}
+	return result
+}
+
+// checkTypes verifies that every named type used by the template is declared
+// and that member accesses on values of object types refer to existing fields.
+// It warns about optional values that are used without a guard.
+func checkTypes(document nodes.Document, annotations []expressions.ExpressionType) error {
+	c := &checker{document: document, warned: make(map[string]bool)}
+	for _, typ := range annotations {
+		err := c.checkNamedTypes(typ)
+		if err != nil {
+			return err
+		}
+	}
+	for _, prop := range document.Props() {
+		if prop.HasDefault && prop.Type != nil && !c.acceptsValue(prop.Type, prop.Default) {
+			return fmt.Errorf("default of prop %s is not a %s", prop.Name, prop.Type.String())
+		}
+	}
+	return c.walk(document.Children(), newScope(nil), guards{})
+}
+
+func (c *checker) checkNamedTypes(typ expressions.ExpressionType) error {
+	if typ == nil {
+		return nil
+	}
+	if typ.Name() != "" {
+		if _, ok := c.document.GetNamedTypes()[typ.Name()]; !ok {
+			return fmt.Errorf("unknown type: %s", typ.Name())
+		}
+	}
+	for _, field := range typ.Fields() {
+		err := c.checkNamedTypes(field.Type)
+		if err != nil {
+			return err
+		}
+	}
+	for _, member := range typ.Members() {
+		err := c.checkNamedTypes(member)
+		if err != nil {
+			return err
+		}
+	}
+	err := c.checkNamedTypes(typ.KeyType())
+	if err != nil {
+		return err
+	}
+	return c.checkNamedTypes(typ.ValueType())
+}
+
+// acceptsValue is expressions.AcceptsValue with the named types of typ and of
+// its members resolved.
+func (c *checker) acceptsValue(typ expressions.ExpressionType, value any) bool {
+	if _, ok := value.(map[string]any); !ok {
+		return expressions.AcceptsValue(typ, value)
+	}
+	if typ.BaseType() == expressions.ExpressionBaseTypeUnion {
+		for _, member := range typ.Members() {
+			if c.acceptsValue(member, value) {
+				return true
+			}
+		}
+		return false
+	}
+	if typ.Name() != "" {
+		typ = c.resolve(typ)
+	}
+	return typ != nil && expressions.AcceptsValue(typ, value)
+}
+
+func (c *checker) walk(children []nodes.Node, bound *scope, guarded guards) error {
+	for _, child := range children {
+		var err error
+		switch n := child.(type) {
+		case nodes.OutputBlock:
+			if n.Expression() != nil {
+				err = c.output(n.Expression(), bound, guarded)
+			} else {
+				err = c.value(n.Key(), n.ExpressionType(), bound, guarded, true)
+			}
+		case nodes.Element:
+			err = c.attributes(n.Attributes(), bound, guarded)
+			if err == nil {
+				err = c.walk(n.Children(), bound, guarded)
+			}
+		case nodes.ConditionalBlock:
+			// the paths an earlier condition of the chain tested for null are
+			// not null in the later branches
+			c.exhaustive(n, bound)
+			var negated []string
+			var block nodes.ConditionalBlock = n
+			for block != nil && err == nil {
+				branch := guarded.with(negated)
+				if block.Condition() != nil {
+					err = c.condition(block.Condition(), bound, branch)
+					negated = append(negated, nullGuards(block.Condition(), false)...)
+					branch = branch.with(nullGuards(block.Condition(), true))
+				}
+				if err == nil {
+					err = c.walk(block.Children(), bound, branch)
+				}
+				block = block.Next()
+			}
+		case nodes.LoopBlock:
+			collection := n.ExpressionType()
+			if collection == nil {
+				collection = bound.lookupType(n.ItemsKey(), c.document)
+			}
+			err = c.declared(n.ItemsKey(), bound)
+			if collection != nil && collection.Optional() && !guarded[n.ItemsKey()] {
+				c.warn(n.ItemsKey() + ": optional value is looped over without a guard")
+			}
+			inner := bound.enterLoop(n.IndexKey(), n.ValueKey(), collection)
+			if err == nil && n.Key() != "" {
+				err = c.loopKey(n, inner)
+			}
+			if err == nil {
+				err = c.walk(n.Children(), inner, guarded)
+			}
+		default:
+			err = c.walk(n.Children(), bound, guarded)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) attributes(attrs attributes.Attributes, bound *scope, guarded guards) error {
+	var err error
+	attrs.Iterator()(func(key string, value attributes.AttributeValue) bool {
+		switch v := value.(type) {
+		case attributes.AttributeValueExpression:
+			err = c.attributeExpression(v, bound, guarded)
+		case attributes.AttributeValueComposite:
+			for _, part := range v.Values() {
+				if expr, ok := part.(attributes.AttributeValueExpression); ok && err == nil {
+					err = c.attributeExpression(expr, bound, guarded)
+				}
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	spread := attrs.GetSpreadAttribute()
+	if spread != nil && !spread.IsEmpty() {
+		name, _ := rootIdentifier(spread.Key())
+		return c.declared(name, bound)
+	}
+	return nil
+}
+
+func (c *checker) attributeExpression(attr attributes.AttributeValueExpression, bound *scope, guarded guards) error {
+	expr, err := expressions.NewBooleanExpression(attr.Key())
+	if err != nil {
+		return c.value(attr.Key(), attr.ExpressionType(), bound, guarded, true)
+	}
+	return c.output(expr, bound, guarded)
+}
+
+// output checks an expression whose value is printed.
+func (c *checker) output(expr expressions.BooleanExpression, bound *scope, guarded guards) error {
+	expr = expressions.Ungroup(expr)
+	switch {
+	case expr.Literal() != "":
+		return c.value(expr.Literal(), expr.ExpressionType(), bound, guarded, true)
+	case expr.Operator() == expressions.Coalesce:
+		// the left operand may be null, the right one is the default
+		err := c.condition(expr.Left(), bound, guarded)
+		if err != nil {
+			return err
+		}
+		return c.output(expr.Right(), bound, guarded)
+	}
+	return c.condition(expr, bound, guarded)
+}
+
+// condition checks an expression whose value is only tested or compared.
+func (c *checker) condition(expr expressions.BooleanExpression, bound *scope, guarded guards) error {
+	if expr.Literal() != "" {
+		return c.value(expr.Literal(), expr.ExpressionType(), bound, guarded, false)
+	}
+	if expr.Parentheses() {
+		return c.condition(expr.Inner(), bound, guarded)
+	}
+
+	switch expr.Operator() {
+	case expressions.Equal, expressions.NotEqual, expressions.GreaterThan, expressions.LessThan,
+		expressions.GreaterThanOrEqual, expressions.LessThanOrEqual:
+		return c.comparison(expr, bound, guarded)
+	}
+
+	if expr.Left() != nil {
+		err := c.condition(expr.Left(), bound, guarded)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the right operand of && and || is only evaluated depending on the left
+	switch expr.Operator() {
+	case expressions.LogicalAnd:
+		guarded = guarded.with(nullGuards(expr.Left(), true))
+	case expressions.LogicalOr:
+		guarded = guarded.with(nullGuards(expr.Left(), false))
+	}
+	return c.condition(expr.Right(), bound, guarded)
+}
+
+// comparison checks a comparison such as a == b or a < b. Values of string
+// literal types may only be compared with the literals they can hold. Values
+// of datetime, duration and decimal types, which are strings in different
+// formats, may only be compared with null.
+func (c *checker) comparison(expr expressions.BooleanExpression, bound *scope, guarded guards) error {
+	operands := []expressions.BooleanExpression{expressions.Ungroup(expr.Left()), expressions.Ungroup(expr.Right())}
+	types := make([]expressions.ExpressionType, 2)
+	for i, operand := range operands {
+		if operand.Literal() == "" {
+			err := c.condition(operand, bound, guarded)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		typ, err := c.valueType(operand.Literal(), operand.ExpressionType(), bound, guarded, false)
+		if err != nil {
+			return err
+		}
+		types[i] = typ
+	}
+
+	equality := expr.Operator() == expressions.Equal || expr.Operator() == expressions.NotEqual
+	for i, typ := range types {
+		other := operands[1-i].Literal()
+		if formatted(c.resolve(typ)) && !(equality && strings.TrimSpace(other) == "null") {
+			return fmt.Errorf("%s is a %s and cannot be compared with %s", strings.TrimSpace(operands[i].Literal()), c.resolve(typ).BaseType(), expr.Operator())
+		}
+		value, ok := expressions.StringLiteral(other)
+		literals, enum := c.literals(typ)
+		if !equality || !ok || !enum || containsString(literals, value) {
+			continue
+		}
+		return fmt.Errorf("%s can never be %s, expected one of %s", strings.TrimSpace(operands[i].Literal()), other, quoteAll(literals))
+	}
+	return nil
+}
+
+// formatted reports whether values of typ are strings that cannot be
+// compared as strings.
+func formatted(typ expressions.ExpressionType) bool {
+	if typ == nil {
+		return false
+	}
+	switch typ.BaseType() {
+	case expressions.ExpressionBaseTypeDateTime, expressions.ExpressionBaseTypeDuration, expressions.ExpressionBaseTypeDecimal:
+		return true
+	}
+	return false
+}
+
+// literals returns the values of a string literal type or of a union of
+// string literal types.
+func (c *checker) literals(typ expressions.ExpressionType) ([]string, bool) {
+	typ = c.resolve(typ)
+	if typ == nil {
+		return nil, false
+	}
+	members := []expressions.ExpressionType{typ}
+	if typ.BaseType() == expressions.ExpressionBaseTypeUnion {
+		members = typ.Members()
+	}
+
+	values := make([]string, 0, len(members))
+	for _, member := range members {
+		value, ok := member.Literal()
+		if !ok {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// value checks a variable or member access path such as item.sku. Member
+// accesses on values of object types must refer to existing fields, optional
+// values must be guarded before their members are accessed and before they are
+// output. Paths into values that are neither maps nor objects are not checked.
+func (c *checker) value(key string, annotation expressions.ExpressionType, bound *scope, guarded guards, output bool) error {
+	_, err := c.valueType(key, annotation, bound, guarded, output)
+	return err
+}
+
+// valueType checks key like value and returns its type, or nil if it is not
+// known.
+func (c *checker) valueType(key string, annotation expressions.ExpressionType, bound *scope, guarded guards, output bool) (expressions.ExpressionType, error) {
+	key = strings.TrimSpace(key)
+	name, member := rootIdentifier(key)
+	if strings.Contains(key, "[") || !isIdentifier(name) {
+		return nil, nil
+	}
+	err := c.declared(name, bound)
+	if err != nil {
+		return nil, err
+	}
+	typ := bound.lookupType(name, c.document)
+	if typ == nil {
+		return nil, nil
+	}
+
+	parts := strings.Split(key, ".")
+	path := name
+	for i, field := range parts[1:] {
+		chained := strings.HasSuffix(parts[i], "?")
+		if typ.Optional() && !chained && !guarded[path] {
+			c.warn(path + ": optional value is accessed without a guard")
+		}
+
+		field = strings.TrimSuffix(field, "?")
+		resolved := c.resolve(typ)
+		switch {
+		case resolved == nil:
+			return nil, nil
+		case resolved.BaseType() == expressions.ExpressionBaseTypeMap:
+			typ = resolved.ValueType()
+		case resolved.BaseType() == expressions.ExpressionBaseTypeObject:
+			fieldType, ok := resolved.Field(field)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s has no field %s", key, typ.WithOptional(false).String(), field)
+			}
+			typ = fieldType
+		default:
+			return nil, nil
+		}
+		path += "." + field
+	}
+
+	if member && annotation != nil && !annotation.WithOptional(false).Equals(typ.WithOptional(false)) {
+		return nil, fmt.Errorf("%s is a field of type %s", path, typ.String())
+	}
+	if output && typ.Optional() && !guarded[path] && !strings.Contains(key, "?") {
+		c.warn(path + ": optional value is output without a guard")
+	}
+	return typ, nil
+}
+
+// loopKey checks the key of a loop, which identifies its items across renders:
+// it must be the index or the value of the loop or one of their fields, and a
+// string or an int.
+func (c *checker) loopKey(n nodes.LoopBlock, bound *scope) error {
+	name, _ := rootIdentifier(n.Key())
+	if name != n.IndexKey() && name != n.ValueKey() {
+		return fmt.Errorf("loop key %s is not %s, %s or one of their fields", n.Key(), n.IndexKey(), n.ValueKey())
+	}
+	typ, err := c.valueType(n.Key(), nil, bound, guards{}, false)
+	if err != nil || typ == nil {
+		return err
+	}
+	resolved := c.resolve(typ)
+	members := []expressions.ExpressionType{resolved}
+	if resolved.BaseType() == expressions.ExpressionBaseTypeUnion {
+		members = resolved.Members()
+	}
+	for _, member := range members {
+		base := c.resolve(member).BaseType()
+		if member.Optional() || base != expressions.ExpressionBaseTypeString && base != expressions.ExpressionBaseTypeInt {
+			return fmt.Errorf("loop key %s of type %s is neither a string nor an int", n.Key(), typ.String())
+		}
+	}
+	return nil
+}
+
+// exhaustive warns if an if/else if chain without an else compares a value
+// of a string literal union type with literals, but not with all of them.
+func (c *checker) exhaustive(block nodes.ConditionalBlock, bound *scope) {
+	var path string
+	var handled []string
+	for current := block; current != nil; current = current.Next() {
+		if current.Condition() == nil {
+			return
+		}
+		p, values, ok := comparedLiterals(current.Condition())
+		if !ok || (path != "" && p != path) {
+			return
+		}
+		path = p
+		handled = append(handled, values...)
+	}
+	if block.Next() == nil {
+		// a single if is not meant to handle every value
+		return
+	}
+
+	typ, err := c.valueType(path, nil, bound, guards{}, false)
+	if err != nil {
+		return
+	}
+	literals, ok := c.literals(typ)
+	if !ok {
+		return
+	}
+	var missing []string
+	for _, literal := range literals {
+		if !containsString(handled, literal) {
+			missing = append(missing, literal)
+		}
+	}
+	if len(missing) > 0 {
+		c.warn(path + ": if chain does not handle " + quoteAll(missing))
+	}
+}
+
+// comparedLiterals returns the path compared with string literals in a
+// condition such as status == "pending" || status == "shipped".
+func comparedLiterals(expr expressions.BooleanExpression) (string, []string, bool) {
+	expr = expressions.Ungroup(expr)
+	switch expr.Operator() {
+	case expressions.LogicalOr:
+		leftPath, leftValues, ok := comparedLiterals(expr.Left())
+		if !ok {
+			return "", nil, false
+		}
+		rightPath, rightValues, ok := comparedLiterals(expr.Right())
+		if !ok || leftPath != rightPath {
+			return "", nil, false
+		}
+		return leftPath, append(leftValues, rightValues...), true
+	case expressions.Equal:
+		left, right := expressions.Ungroup(expr.Left()).Literal(), expressions.Ungroup(expr.Right()).Literal()
+		if value, ok := expressions.StringLiteral(right); ok && isPath(left) {
+			return guardPath(left), []string{value}, true
+		}
+		if value, ok := expressions.StringLiteral(left); ok && isPath(right) {
+			return guardPath(right), []string{value}, true
+		}
+	}
+	return "", nil, false
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (c *checker) warn(message string) {
+	if !c.warned[message] {
+		c.warned[message] = true
+		c.document.AddWarning(message)
+	}
+}
+
+// nullGuards returns the paths that are not null if expr is truthy, or if it
+// is falsy when truthy is false.
+func nullGuards(expr expressions.BooleanExpression, truthy bool) []string {
+	expr = expressions.Ungroup(expr)
+	if expr.Literal() != "" {
+		if truthy && isPath(expr.Literal()) {
+			return []string{guardPath(expr.Literal())}
+		}
+		return nil
+	}
+
+	switch expr.Operator() {
+	case expressions.LogicalNot:
+		return nullGuards(expr.Right(), !truthy)
+	case expressions.LogicalAnd:
+		if truthy {
+			return append(nullGuards(expr.Left(), true), nullGuards(expr.Right(), true)...)
+		}
+	case expressions.LogicalOr:
+		if !truthy {
+			return append(nullGuards(expr.Left(), false), nullGuards(expr.Right(), false)...)
+		}
+	case expressions.Equal, expressions.NotEqual:
+		if (expr.Operator() == expressions.NotEqual) != truthy {
+			return nil
+		}
+		left, right := expressions.Ungroup(expr.Left()), expressions.Ungroup(expr.Right())
+		switch {
+		case right.Literal() == "null" && isPath(left.Literal()):
+			return []string{guardPath(left.Literal())}
+		case left.Literal() == "null" && isPath(right.Literal()):
+			return []string{guardPath(right.Literal())}
+		}
+	}
+	return nil
+}
+
+func isPath(literal string) bool {
+	name, _ := rootIdentifier(literal)
+	return isIdentifier(name)
+}
+
+// guardPath returns the path of a literal without optional chaining, e.g.
+// user.address for user?.address.
+func guardPath(literal string) string {
+	return strings.ReplaceAll(strings.TrimSpace(literal), "?", "")
+}
+
+// declared fails if the document is strict and name is neither a loop
+// variable nor one of its props.
+func (c *checker) declared(name string, bound *scope) error {
+	if !c.document.Strict() {
+		return nil
+	}
+	if _, ok := bound.lookup(name); ok {
+		return nil
+	}
+	if _, ok := c.document.GetDeclaredTypes()[name]; !ok {
+		return fmt.Errorf("%s is not declared in props", name)
+	}
+	return nil
+}
+
+// resolve returns the declaration of a named type, or typ itself.
+func (c *checker) resolve(typ expressions.ExpressionType) expressions.ExpressionType {
+	if typ == nil || typ.Name() == "" {
+		return typ
+	}
+	return c.document.GetNamedTypes()[typ.Name()]
+}
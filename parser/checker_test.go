@@ -0,0 +1,567 @@
+package parser
+
+import (
+	"guts/parser/expressions"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamedTypes(t *testing.T) {
+	lineItem := expressions.NewObjectType([]expressions.ObjectField{
+		{Name: "sku", Type: _stringType},
+		{Name: "qty", Type: _intType},
+		{Name: "price", Type: _floatType},
+	})
+
+	tests := []struct {
+		name       string
+		html       string
+		types      map[string]expressions.ExpressionType
+		namedTypes map[string]expressions.ExpressionType
+		message    string
+	}{
+		{
+			name: "named type used in a loop",
+			html: `{type LineItem = {sku: string, qty: int, price: float}}
+				<ul>{for i, item in items: LineItem[]}<li>{item.sku}: {if item.qty > 1}{item.qty} x {/if}{item.price}</li>{/for}</ul>`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewNamedType("LineItem")),
+			},
+			namedTypes: map[string]expressions.ExpressionType{
+				"LineItem": lineItem,
+			},
+		}, {
+			name: "nested named types declared after use",
+			html: `<p title={order.customer.name}>{order: Order}</p>
+				{type Order = {customer: Customer, lines: LineItem[], notes: map[string, string]}}
+				{type Customer = {name: string, address: {city: string}}}
+				{type LineItem = {sku: string, qty: int, price: float}}
+				<p>{order.customer.address.city} {order.notes.gift}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"order": expressions.NewNamedType("Order"),
+			},
+			namedTypes: map[string]expressions.ExpressionType{
+				"Order": expressions.NewObjectType([]expressions.ObjectField{
+					{Name: "customer", Type: expressions.NewNamedType("Customer")},
+					{Name: "lines", Type: expressions.NewArrayType(expressions.NewNamedType("LineItem"))},
+					{Name: "notes", Type: expressions.NewMapType(_stringType, _stringType)},
+				}),
+				"Customer": expressions.NewObjectType([]expressions.ObjectField{
+					{Name: "name", Type: _stringType},
+					{Name: "address", Type: expressions.NewObjectType([]expressions.ObjectField{
+						{Name: "city", Type: _stringType},
+					})},
+				}),
+				"LineItem": lineItem,
+			},
+		}, {
+			name: "annotated member of a named type",
+			html: `{type LineItem = {sku: string, qty: int}}{for i, item in items: LineItem[]}{if item.qty: int > 1}many{/if}{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewNamedType("LineItem")),
+			},
+			namedTypes: map[string]expressions.ExpressionType{
+				"LineItem": expressions.NewObjectType([]expressions.ObjectField{
+					{Name: "sku", Type: _stringType},
+					{Name: "qty", Type: _intType},
+				}),
+			},
+		}, {
+			name:    "unknown field",
+			html:    `{type LineItem = {sku: string}}{for i, item in items: LineItem[]}{item.skuu}{/for}`,
+			message: "item.skuu: LineItem has no field skuu",
+		}, {
+			name:    "unknown field of a nested object",
+			html:    `{type Customer = {address: {city: string}}}{customer?.address?.zip}{customer: Customer}`,
+			message: "customer?.address?.zip: {city: string} has no field zip",
+		}, {
+			name:    "annotated member conflicts with field type",
+			html:    `{type LineItem = {qty: int}}{for i, item in items: LineItem[]}{item.qty: string}{/for}`,
+			message: "item.qty is a field of type int",
+		}, {
+			name:    "unknown type",
+			html:    `{for i, item in items: Product[]}{item}{/for}`,
+			message: "unknown type: Product",
+		}, {
+			name:    "unknown type in a declaration",
+			html:    `{type Order = {lines: LineItem[]}}`,
+			message: "unknown type: LineItem",
+		}, {
+			name:    "unknown type in a union",
+			html:    `{props status: Foo | "a", name: string}{name}`,
+			message: "unknown type: Foo",
+		}, {
+			name:    "duplicate declaration",
+			html:    `{type Item = {sku: string}}{type Item = {id: int}}`,
+			message: "type Item is already declared",
+		}, {
+			name:    "declaration of a type that is not an object",
+			html:    `{type Items = string[]}`,
+			message: "type Items must be an object type",
+		}, {
+			name:    "lower case type name",
+			html:    `{type item = {sku: string}}`,
+			message: "type name must start with an upper case letter: item",
+		}, {
+			name:    "duplicate field",
+			html:    `{type Item = {sku: string, sku: int}}`,
+			message: "invalid type declaration type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := Parse(strings.NewReader(tt.html))
+			if tt.message != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+
+			for _, actual := range []struct {
+				expected map[string]expressions.ExpressionType
+				types    map[string]expressions.ExpressionType
+			}{
+				{tt.types, document.GetDeclaredTypes()},
+				{tt.namedTypes, document.GetNamedTypes()},
+			} {
+				assert.Equal(t, len(actual.expected), len(actual.types), "number of types mismatch")
+				for key, expectedType := range actual.expected {
+					actualType, exists := actual.types[key]
+					assert.True(t, exists, "type for %s should exist", key)
+					if exists {
+						assert.True(t, expectedType.Equals(actualType),
+							"type mismatch for %s: expected %s, got %s",
+							key, expectedType.String(), actualType.String())
+					}
+				}
+			}
+			assert.Empty(t, document.Warnings())
+		})
+	}
+}
+
+func TestOptionalGuards(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		warnings []string
+	}{
+		{
+			name:     "output without a guard",
+			html:     `<p>{nickname: string?}</p>`,
+			warnings: []string{"nickname: optional value is output without a guard"},
+		}, {
+			name: "output guarded by a truthy check",
+			html: `{if nickname: string | null}<p>{nickname}</p>{/if}`,
+		}, {
+			name: "output guarded by a null check",
+			html: `{if null != nickname: string?}<p>{nickname}</p>{/if}`,
+		}, {
+			name: "output guarded in an else branch",
+			html: `{if nickname: string? == null}anonymous{else if !verified: bool}{nickname}?{else}{nickname}{/if}`,
+		}, {
+			name: "output with a default",
+			html: `<p>{nickname: string? ?? "anonymous"}</p>`,
+		}, {
+			name:     "output in an attribute",
+			html:     `<a title={nickname: string?} href="/users/{id: int}">profile</a>`,
+			warnings: []string{"nickname: optional value is output without a guard"},
+		}, {
+			name: "member access without a guard",
+			html: `{type User = {name: string, age: int}}<p>{user.name}</p>{if user: User? != null && user.age > 18}adult{/if}{if user.age < 18}minor{/if}`,
+			warnings: []string{
+				"user: optional value is accessed without a guard",
+			},
+		}, {
+			name: "member access with optional chaining",
+			html: `{type User = {name: string}}<p>{user?.name}</p>{if user: User? == null || user.name == ""}anonymous{/if}`,
+		}, {
+			name:     "optional field",
+			html:     `{type User = {nickname: string?}}{for i, user in users: User[]}{if user.nickname}{user.nickname}{/if}<p>{user.nickname}</p>{/for}`,
+			warnings: []string{"user.nickname: optional value is output without a guard"},
+		}, {
+			name:     "loop without a guard",
+			html:     `<ul>{for i, item in items: string[]?}<li>{item}</li>{/for}</ul>`,
+			warnings: []string{"items: optional value is looped over without a guard"},
+		}, {
+			name: "loop with a guard",
+			html: `{if items}<ul>{for i, item in items: string[] | null}<li>{item}</li>{/for}</ul>{/if}`,
+		}, {
+			name:     "optional loop elements",
+			html:     `<ul>{for i, item in items: string?[]}<li>{item}</li>{if item}<li>{item}</li>{/if}{/for}</ul>`,
+			warnings: []string{"item: optional value is output without a guard"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := Parse(strings.NewReader(tt.html))
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+			assert.Equal(t, tt.warnings, document.Warnings())
+		})
+	}
+}
+
+func TestStringLiteralUnions(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		warnings []string
+		message  string
+	}{
+		{
+			name:    "comparison with a literal that is not a member",
+			html:    `{type Order = {status: "pending" | "shipped" | "cancelled"}}{for i, order in orders: Order[]}{if order.status == "shiped"}shipped{/if}{/for}`,
+			message: `order.status can never be "shiped", expected one of "pending", "shipped", "cancelled"`,
+		}, {
+			name:    "inequality with a literal that is not a member",
+			html:    `{if "lost" != status: "pending" | "shipped"}found{/if}`,
+			message: `status can never be "lost", expected one of "pending", "shipped"`,
+		}, {
+			name:    "comparison with a single literal type",
+			html:    `{if kind: 'order' == 'invoice'}invoice{/if}`,
+			message: `kind can never be 'invoice', expected one of "order"`,
+		}, {
+			name: "comparisons with members",
+			html: `{if status: "pending" | "shipped" == "pending" || status != 'shipped'}open{/if}`,
+		}, {
+			name: "comparison with a union of other types",
+			html: `{if id: string | int == "x"}x{/if}`,
+		}, {
+			name:     "if chain that does not handle every member",
+			html:     `{if status: "pending" | "shipped" | "cancelled" | "returned" == "pending"}pending{else if "shipped" == status}shipped{/if}`,
+			warnings: []string{`status: if chain does not handle "cancelled", "returned"`},
+		}, {
+			name: "if chain with else",
+			html: `{if status: "pending" | "shipped" | "cancelled" == "pending"}pending{else if status == "shipped"}shipped{else}cancelled{/if}`,
+		}, {
+			name: "if chain handling several members in one branch",
+			html: `{if status: "pending" | "shipped" | "cancelled" == "pending" || status == "shipped"}open{else if status == "cancelled"}closed{/if}`,
+		}, {
+			name: "single if",
+			html: `{if status: "pending" | "shipped" == "pending"}pending{/if}`,
+		}, {
+			name: "if chain over different values",
+			html: `{if status: "pending" | "shipped" == "pending"}pending{else if mode: "a" | "b" == "a"}a{/if}`,
+		}, {
+			name:     "if chain over an object field",
+			html:     `{type Order = {status: "pending" | "shipped"}}{for i, order in orders: Order[]}{if order.status == "pending"}pending{else if order?.status == "pending"}again{/if}{/for}`,
+			warnings: []string{`order.status: if chain does not handle "shipped"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := Parse(strings.NewReader(tt.html))
+			if tt.message != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+			assert.Equal(t, tt.warnings, document.Warnings())
+		})
+	}
+}
+
+func TestProps(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		types    map[string]expressions.ExpressionType
+		warnings []string
+		message  string
+	}{
+		{
+			name: "props declare the inputs",
+			html: `<!-- order summary -->
+				{props
+					title: string
+					items: string[] = []
+					nickname: string? = null
+				}
+				<h1>{title}</h1>{for i, item in items}<li>{item}</li>{/for}<p>{nickname ?? "anonymous"}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"title":    _stringType,
+				"items":    expressions.NewArrayType(_stringType),
+				"nickname": _stringType.WithOptional(true),
+			},
+		}, {
+			name:  "empty props",
+			html:  `{props}<p>static</p>`,
+			types: map[string]expressions.ExpressionType{},
+		}, {
+			name: "props with object types and matching annotations",
+			html: `{props user: {name: string, age: int}, count: int = 0}{if count: int > 0}<p>{user.name}</p>{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"user": expressions.NewObjectType([]expressions.ObjectField{
+					{Name: "name", Type: _stringType},
+					{Name: "age", Type: _intType},
+				}),
+				"count": _intType,
+			},
+		}, {
+			name: "props are not inferred",
+			html: `{props label: string}<p>{label ?? "none"}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"label": _stringType,
+			},
+		}, {
+			name:     "optional prop without a guard",
+			html:     `{props nickname: string?}<p>{nickname}</p>`,
+			types:    map[string]expressions.ExpressionType{"nickname": _stringType.WithOptional(true)},
+			warnings: []string{"nickname: optional value is output without a guard"},
+		}, {
+			name:    "undeclared output",
+			html:    `{props title: string}<p>{subtitle}</p>`,
+			message: "subtitle is not declared in props",
+		}, {
+			name:    "undeclared annotated output",
+			html:    `{props title: string}<p>{subtitle: string}</p>`,
+			message: "subtitle is not declared in props",
+		}, {
+			name:    "undeclared loop",
+			html:    `{props title: string}{for i, item in items}{item}{/for}`,
+			message: "items is not declared in props",
+		}, {
+			name:    "undeclared condition",
+			html:    `{props title: string}{if visible}{title}{/if}`,
+			message: "visible is not declared in props",
+		}, {
+			name:    "undeclared spread",
+			html:    `{props title: string}<div {...attrs}>{title}</div>`,
+			message: "attrs is not declared in props",
+		}, {
+			name:    "annotation that conflicts with props",
+			html:    `{props count: int}<p>{count: string}</p>`,
+			message: "count is declared in props as int at 1:1 and annotated as string at 1:22",
+		}, {
+			name:    "props after content",
+			html:    `<p>hello</p>{props title: string}`,
+			message: "props must be declared before any content",
+		}, {
+			name:    "nested props",
+			html:    `<div>{props title: string}</div>`,
+			message: "props must be declared at the top level",
+		}, {
+			name:    "duplicate props",
+			html:    `{props title: string}{props count: int}`,
+			message: "props are already declared",
+		}, {
+			name:    "invalid default",
+			html:    `{props count: int = "many"}`,
+			message: "invalid props: default of prop count is not a int",
+		}, {
+			name:    "empty object default of a named type with a required field",
+			html:    `{type Filter = {query: string, page: int?}}{props filter: Filter = {}}`,
+			message: "default of prop filter is not a Filter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := Parse(strings.NewReader(tt.html))
+			if tt.message != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+
+			assert.True(t, document.Strict())
+			types := document.GetDeclaredTypes()
+			assert.Equal(t, len(tt.types), len(types), "number of types mismatch")
+			for key, expectedType := range tt.types {
+				actualType, exists := types[key]
+				assert.True(t, exists, "type for %s should exist", key)
+				if exists {
+					assert.True(t, expectedType.Equals(actualType),
+						"type mismatch for %s: expected %s, got %s",
+						key, expectedType.String(), actualType.String())
+				}
+			}
+			assert.Equal(t, tt.warnings, document.Warnings())
+		})
+	}
+}
+
+func TestPropsFrom(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/app\n",
+		"views/views.go": "package views\n\n" +
+			"type OrderPage struct {\n" +
+			"\tTitle string `json:\"title\"`\n" +
+			"\tLines []LineItem `json:\"lines\"`\n" +
+			"\tNote *string `json:\"note\"`\n" +
+			"}\n\n" +
+			"type LineItem struct {\n" +
+			"\tSKU string `json:\"sku\"`\n" +
+			"\tQty int `json:\"qty\"`\n" +
+			"}\n",
+		"templates/order.json": `{
+			"type": "object",
+			"properties": {
+				"title": {"type": "string"},
+				"lines": {"type": "array", "items": {"$ref": "#/$defs/LineItem"}},
+				"note": {"type": "string"}
+			},
+			"required": ["title", "lines"],
+			"$defs": {
+				"LineItem": {"type": "object", "properties": {"sku": {"type": "string"}, "qty": {"type": "integer"}}, "required": ["sku", "qty"]}
+			}
+		}`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	}
+	templates := filepath.Join(dir, "templates")
+
+	tests := []struct {
+		name     string
+		html     string
+		warnings []string
+		message  string
+	}{
+		{
+			name: "template checked against the Go type",
+			html: `{props from "example.com/app/views.OrderPage"}<h1>{title}</h1>{if lines}{for i, line in lines}<li>{line.sku} x {line.qty}</li>{/for}{/if}{if note}{note}{/if}`,
+		}, {
+			name:     "nil slice from a Go type",
+			html:     `{props from "example.com/app/views.OrderPage"}{for i, line in lines}<li>{line.sku}</li>{/for}`,
+			warnings: []string{"lines: optional value is looped over without a guard"},
+		}, {
+			name: "relative package path",
+			html: `{props from '../views.OrderPage'}<h1>{title}</h1>{note}`,
+			warnings: []string{
+				"note: optional value is output without a guard",
+			},
+		}, {
+			name: "JSON Schema",
+			html: `{props from "order.json"}<h1>{title}</h1>{for i, line in lines}<li>{line.sku} x {line.qty}</li>{/for}{note ?? ""}`,
+		}, {
+			name:    "unknown field in a JSON Schema",
+			html:    `{props from "order.json"}{for i, line in lines}{line.price}{/for}`,
+			message: "line.price: LineItem has no field price",
+		}, {
+			name:    "missing JSON Schema",
+			html:    `{props from "page.json"}`,
+			message: "invalid props: page.json",
+		}, {
+			name:    "unknown field",
+			html:    `{props from "example.com/app/views.OrderPage"}{for i, line in lines}{line.price}{/for}`,
+			message: "line.price: LineItem has no field price",
+		}, {
+			name:    "undeclared input",
+			html:    `{props from "example.com/app/views.OrderPage"}{subtitle}`,
+			message: "subtitle is not declared in props",
+		}, {
+			name:    "annotation that conflicts with the Go type",
+			html:    `{props from "example.com/app/views.OrderPage"}{title: int}`,
+			message: "title is declared in props as string at 1:1 and annotated as int at 1:47",
+		}, {
+			name:    "missing type",
+			html:    `{props from "example.com/app/views.Page"}`,
+			message: "invalid props: type Page is not declared in package example.com/app/views",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := ParseWithOptions(strings.NewReader(tt.html), Options{Dir: templates})
+			if tt.message != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+			assert.Equal(t, []string{"LineItem"}, keys(document.GetNamedTypes()))
+			assert.Len(t, document.Props(), 3)
+			assert.Equal(t, tt.warnings, document.Warnings())
+		})
+	}
+}
+
+func keys(types map[string]expressions.ExpressionType) []string {
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestFormattedTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		message string
+	}{
+		{
+			name: "dates compare as strings",
+			html: `{if due: date < "2024-01-01"}overdue{/if}`,
+		}, {
+			name: "comparison with null",
+			html: `{if placed: datetime? != null}{placed}{/if}{if total: decimal == null}free{/if}`,
+		}, {
+			name:    "decimal compared with a number",
+			html:    `{if total: decimal > 100}large{/if}`,
+			message: "total is a decimal and cannot be compared with >",
+		}, {
+			name:    "decimal field compared for equality",
+			html:    `{type Line = {price: decimal}}{for i, line in lines: Line[]}{if line.price == "0.00"}free{/if}{/for}`,
+			message: "line.price is a decimal and cannot be compared with ==",
+		}, {
+			name:    "datetimes compared with each other",
+			html:    `{if placed: datetime >= shipped: datetime}shipped{/if}`,
+			message: "placed is a datetime and cannot be compared with >=",
+		}, {
+			name:    "duration compared with a string",
+			html:    `{if "PT1H" <= elapsed: duration}slow{/if}`,
+			message: "elapsed is a duration and cannot be compared with <=",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.html))
+			if tt.message == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.message)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ const (
 	LogicalAnd         BooleanOperator = "&&"
 	LogicalOr          BooleanOperator = "||"
 	LogicalNot         BooleanOperator = "!"
+	Coalesce           BooleanOperator = "??"
 )
 
 var _booleanOperators = map[string]BooleanOperator{
@@ -29,25 +30,30 @@ var _booleanOperators = map[string]BooleanOperator{
 	"&&": LogicalAnd,
 	"||": LogicalOr,
 	"!":  LogicalNot,
+	"??": Coalesce,
 }
 
+// BooleanExpression is a node of an expression tree. It is either a literal
+// (with an optional type annotation), a unary or binary operation, or a group
+// wrapping a parenthesized expression.
 type BooleanExpression interface {
 	Left() BooleanExpression
 	Right() BooleanExpression
 	Operator() BooleanOperator
 	Parentheses() bool
+	Inner() BooleanExpression
 	Literal() string
 	ExpressionType() ExpressionType
 	String() string
 }
 
 type booleanExpression struct {
-	left        BooleanExpression
-	right       BooleanExpression
-	operator    BooleanOperator
-	literal     string
-	typ         ExpressionType
-	parentheses bool
+	left     BooleanExpression
+	right    BooleanExpression
+	operator BooleanOperator
+	literal  string
+	typ      ExpressionType
+	inner    BooleanExpression
 }
 
 func NewBooleanExpression(s string) (BooleanExpression, error) {
@@ -67,8 +73,14 @@ func (b *booleanExpression) Operator() BooleanOperator {
 	return b.operator
 }
 
+// Parentheses reports whether the expression is a group.
 func (b *booleanExpression) Parentheses() bool {
-	return b.parentheses
+	return b.inner != nil
+}
+
+// Inner returns the parenthesized expression of a group, or nil.
+func (b *booleanExpression) Inner() BooleanExpression {
+	return b.inner
 }
 
 func (b *booleanExpression) Literal() string {
@@ -79,6 +91,8 @@ func (b *booleanExpression) ExpressionType() ExpressionType {
 	return b.typ
 }
 
+// String returns the canonical form of the expression, which parses back to
+// an identical tree.
 func (b *booleanExpression) String() string {
 	if b.literal != "" {
 		if b.typ != nil {
@@ -88,10 +102,11 @@ func (b *booleanExpression) String() string {
 		return b.literal
 	}
 
-	var buf bytes.Buffer
-	if b.parentheses {
-		buf.WriteByte('(')
+	if b.inner != nil {
+		return "(" + b.inner.String() + ")"
 	}
+
+	var buf bytes.Buffer
 	if b.left != nil {
 		buf.WriteString(b.left.String())
 		buf.WriteByte(' ')
@@ -101,8 +116,13 @@ func (b *booleanExpression) String() string {
 		buf.WriteByte(' ')
 		buf.WriteString(b.right.String())
 	}
-	if b.parentheses {
-		buf.WriteByte(')')
-	}
 	return buf.String()
 }
+
+// Ungroup returns the expression inside any number of parentheses.
+func Ungroup(expr BooleanExpression) BooleanExpression {
+	for expr.Parentheses() {
+		expr = expr.Inner()
+	}
+	return expr
+}
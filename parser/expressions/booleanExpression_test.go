@@ -0,0 +1,122 @@
+package expressions
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _randomLiterals = []func(r *rand.Rand) *booleanExpression{
+	func(r *rand.Rand) *booleanExpression {
+		return &booleanExpression{literal: []string{"a", "qty", "user.name", "user?.address?.city", "_private"}[r.Intn(5)]}
+	},
+	func(r *rand.Rand) *booleanExpression {
+		return &booleanExpression{literal: strconv.Itoa(r.Intn(1000))}
+	},
+	func(r *rand.Rand) *booleanExpression {
+		return &booleanExpression{literal: strconv.FormatFloat(r.Float64()*100, 'f', 2, 64)}
+	},
+	func(r *rand.Rand) *booleanExpression {
+		return &booleanExpression{literal: []string{`"shipped"`, `'it\'s'`, `"a && (b)"`, `""`}[r.Intn(4)]}
+	},
+	func(r *rand.Rand) *booleanExpression {
+		return &booleanExpression{literal: []string{"true", "false", "null"}[r.Intn(3)]}
+	},
+	func(r *rand.Rand) *booleanExpression {
+		types := []string{"string", "int", "float", "bool", "string[]", "map[string,int]"}
+		typ, _ := ParseExpressionType(types[r.Intn(len(types))])
+		return &booleanExpression{literal: []string{"a", "qty", "items"}[r.Intn(3)], typ: typ}
+	},
+}
+
+var _randomOperators = []BooleanOperator{
+	Equal, NotEqual, GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual,
+	LogicalAnd, LogicalOr, Coalesce,
+}
+
+// randomExpression generates expression trees that the parser can produce:
+// operands bind at least as tightly as their operator, unless grouped.
+type randomExpression struct {
+	expr *booleanExpression
+}
+
+func (randomExpression) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(randomExpression{expr: generateExpression(r, r.Intn(6))})
+}
+
+func generateExpression(r *rand.Rand, depth int) *booleanExpression {
+	if depth == 0 {
+		return _randomLiterals[r.Intn(len(_randomLiterals))](r)
+	}
+
+	switch r.Intn(5) {
+	case 0:
+		return &booleanExpression{inner: generateExpression(r, depth-1)}
+	case 1:
+		right := generateExpression(r, depth-1)
+		if right.operator != "" && right.operator != LogicalNot {
+			right = &booleanExpression{inner: right}
+		}
+		return &booleanExpression{operator: LogicalNot, right: right}
+	default:
+		op := _randomOperators[r.Intn(len(_randomOperators))]
+		left := generateExpression(r, depth-1)
+		right := generateExpression(r, depth-1)
+		if left.operator != "" && precedence(left.operator) < precedence(op) {
+			left = &booleanExpression{inner: left}
+		}
+		if right.operator != "" && precedence(right.operator) <= precedence(op) {
+			right = &booleanExpression{inner: right}
+		}
+		return &booleanExpression{operator: op, left: left, right: right}
+	}
+}
+
+func TestBooleanExpressionRoundTrip(t *testing.T) {
+	roundTrip := func(r randomExpression) bool {
+		printed := r.expr.String()
+		parsed, _, err := ParseBooleanExpression(printed)
+		if err != nil {
+			t.Logf("%s: %v", printed, err)
+			return false
+		}
+		if !reflect.DeepEqual(BooleanExpression(r.expr), parsed) {
+			t.Logf("%s reparsed as %s", printed, parsed.String())
+			return false
+		}
+		return parsed.String() == printed
+	}
+
+	err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(1))})
+	assert.NoError(t, err)
+}
+
+func TestBooleanExpressionCanonicalForm(t *testing.T) {
+	// formatting differences in the source disappear in the canonical form,
+	// which is stable under reparsing
+	inputs := []string{
+		"a==b&&(c:int>1||!d)",
+		"(  qty : int )",
+		"nickname??name ?? 'anonymous'",
+		"!(!(a))",
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			first, _, err := ParseBooleanExpression(input)
+			if !assert.NoError(t, err) {
+				return
+			}
+			second, _, err := ParseBooleanExpression(first.String())
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, first, second)
+			assert.Equal(t, first.String(), second.String())
+		})
+	}
+}
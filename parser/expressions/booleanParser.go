@@ -19,6 +19,7 @@ type parser struct {
 // 3. comparison operators
 // 4. logical AND
 // 5. logical OR
+// 6. nullish coalescing (??)
 func ParseBooleanExpression(s string) (BooleanExpression, map[string]ExpressionType, error) {
 	p := &parser{
 		tokens: tokenize(s),
@@ -33,6 +34,9 @@ func ParseBooleanExpression(s string) (BooleanExpression, map[string]ExpressionT
 	if err != nil {
 		return nil, nil, err
 	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected token: %s", p.tokens[p.pos])
+	}
 
 	return expr, p.types, nil
 }
@@ -40,14 +44,16 @@ func ParseBooleanExpression(s string) (BooleanExpression, map[string]ExpressionT
 // Get precedence level for operators
 func precedence(op BooleanOperator) int {
 	switch op {
-	case LogicalOr:
+	case Coalesce:
 		return 1
-	case LogicalAnd:
+	case LogicalOr:
 		return 2
-	case Equal, NotEqual, GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual:
+	case LogicalAnd:
 		return 3
-	case LogicalNot:
+	case Equal, NotEqual, GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual:
 		return 4
+	case LogicalNot:
+		return 5
 	default:
 		return 0
 	}
@@ -63,6 +69,27 @@ func tokenize(s string) []string {
 		c := runes[i]
 
 		switch c {
+		case '"', '\'':
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+
+			// Quoted strings are a single token, quotes included
+			token.WriteRune(c)
+			for i++; i < len(runes); i++ {
+				token.WriteRune(runes[i])
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					token.WriteRune(runes[i])
+					continue
+				}
+				if runes[i] == c {
+					break
+				}
+			}
+			tokens = append(tokens, token.String())
+			token.Reset()
 		case ' ', '\t', '\n', '\r':
 			if token.Len() > 0 {
 				tokens = append(tokens, token.String())
@@ -95,6 +122,18 @@ func tokenize(s string) []string {
 				}
 			}
 			tokens = append(tokens, string(c))
+		case '?':
+			// "?." is optional chaining and belongs to the identifier
+			if i+1 < len(runes) && runes[i+1] == '?' {
+				if token.Len() > 0 {
+					tokens = append(tokens, token.String())
+					token.Reset()
+				}
+				tokens = append(tokens, "??")
+				i++
+				continue
+			}
+			token.WriteRune(c)
 		default:
 			token.WriteRune(c)
 		}
@@ -112,6 +151,9 @@ func (p *parser) parseWithPrecedence(precedenceLevel int) (BooleanExpression, er
 	var left BooleanExpression
 
 	// Handle prefix operators and literals
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
 	token := p.tokens[p.pos]
 	p.pos++
 
@@ -125,13 +167,7 @@ func (p *parser) parseWithPrecedence(precedenceLevel int) (BooleanExpression, er
 			return nil, fmt.Errorf("missing closing parenthesis")
 		}
 		p.pos++
-		left = &booleanExpression{
-			left:        expr.Left(),
-			right:       expr.Right(),
-			operator:    expr.Operator(),
-			literal:     expr.Literal(),
-			parentheses: true,
-		}
+		left = &booleanExpression{inner: expr}
 	case "!":
 		right, err := p.parseWithPrecedence(precedence(LogicalNot))
 		if err != nil {
@@ -150,6 +186,11 @@ func (p *parser) parseWithPrecedence(precedenceLevel int) (BooleanExpression, er
 			}
 			typeStr := p.tokens[p.pos]
 			p.pos++
+			// unions such as string | null are split by the tokenizer
+			for p.pos+1 < len(p.tokens) && p.tokens[p.pos] == "|" {
+				typeStr += " | " + p.tokens[p.pos+1]
+				p.pos += 2
+			}
 
 			// Parse the type
 			typ, ok := ParseExpressionType(typeStr)
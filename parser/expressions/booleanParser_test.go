@@ -24,8 +24,8 @@ func TestParseBooleanExpression(t *testing.T) {
 			input: "a:string == b:string",
 			want:  "a:string == b:string",
 			wantTypes: map[string]ExpressionType{
-				"a": NewExpressionType(ExpressionBaseTypeString, "", ExpressionBaseTypeString),
-				"b": NewExpressionType(ExpressionBaseTypeString, "", ExpressionBaseTypeString),
+				"a": NewPrimitiveType(ExpressionBaseTypeString),
+				"b": NewPrimitiveType(ExpressionBaseTypeString),
 			},
 		},
 		{
@@ -53,6 +53,11 @@ func TestParseBooleanExpression(t *testing.T) {
 			input: "x > 5 || y < 3",
 			want:  "x > 5 || y < 3",
 		},
+		{
+			name:  "quoted string with spaces and operators",
+			input: `status == "in stock && ready" || label != 'it\'s (new)'`,
+			want:  `status == "in stock && ready" || label != 'it\'s (new)'`,
+		},
 		{
 			name:  "logical NOT",
 			input: "!isValid",
@@ -63,7 +68,7 @@ func TestParseBooleanExpression(t *testing.T) {
 			input: "!isValid:bool",
 			want:  "! isValid:bool",
 			wantTypes: map[string]ExpressionType{
-				"isValid": NewExpressionType(ExpressionBaseTypeBool, "", ExpressionBaseTypeBool),
+				"isValid": NewPrimitiveType(ExpressionBaseTypeBool),
 			},
 		},
 		{
@@ -91,10 +96,57 @@ func TestParseBooleanExpression(t *testing.T) {
 			input: "((a:int == b:int) && c:bool) || d:bool",
 			want:  "((a:int == b:int) && c:bool) || d:bool",
 			wantTypes: map[string]ExpressionType{
-				"a": NewExpressionType(ExpressionBaseTypeInt, "", ExpressionBaseTypeInt),
-				"b": NewExpressionType(ExpressionBaseTypeInt, "", ExpressionBaseTypeInt),
-				"c": NewExpressionType(ExpressionBaseTypeBool, "", ExpressionBaseTypeBool),
-				"d": NewExpressionType(ExpressionBaseTypeBool, "", ExpressionBaseTypeBool),
+				"a": NewPrimitiveType(ExpressionBaseTypeInt),
+				"b": NewPrimitiveType(ExpressionBaseTypeInt),
+				"c": NewPrimitiveType(ExpressionBaseTypeBool),
+				"d": NewPrimitiveType(ExpressionBaseTypeBool),
+			},
+		},
+		{
+			name:  "nullish coalescing",
+			input: "nickname??name",
+			want:  "nickname ?? name",
+		},
+		{
+			name:  "nullish coalescing has the lowest precedence",
+			input: "a || b ?? c && d",
+			want:  "a || b ?? c && d",
+		},
+		{
+			name:  "optional chaining",
+			input: `user?.address?.city != "London"`,
+			want:  `user?.address?.city != "London"`,
+		},
+		{
+			name:    "unexpected trailing token",
+			input:   "a b",
+			wantErr: true,
+		},
+		{
+			name:    "missing operand",
+			input:   "a ==",
+			wantErr: true,
+		},
+		{
+			name:  "parenthesized type declaration",
+			input: "(qty: int) > (0)",
+			want:  "(qty:int) > (0)",
+			wantTypes: map[string]ExpressionType{
+				"qty": NewPrimitiveType(ExpressionBaseTypeInt),
+			},
+		},
+		{
+			name:  "redundant parentheses are kept",
+			input: "((!(ready: bool)))",
+			want:  "((! (ready:bool)))",
+		},
+		{
+			name:  "optional type declarations",
+			input: "nickname: string? ?? name: string | null",
+			want:  "nickname:string? ?? name:string?",
+			wantTypes: map[string]ExpressionType{
+				"nickname": NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true),
+				"name":     NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true),
 			},
 		},
 		{
@@ -0,0 +1,299 @@
+package expressions
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// UndefinedVariableError is returned when an expression references a
+// variable, or a member of a variable, that does not exist.
+type UndefinedVariableError struct {
+	Name string
+}
+
+func (e *UndefinedVariableError) Error() string {
+	return "undefined variable: " + e.Name
+}
+
+// TypeMismatchError is returned when an operator is applied to operands it
+// does not support.
+type TypeMismatchError struct {
+	Operator BooleanOperator
+	Left     any
+	Right    any
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("type mismatch: cannot apply %s to %T and %T", e.Operator, e.Left, e.Right)
+}
+
+// Evaluate computes the value of expr, resolving variables from env.
+// Members of maps with string keys and exported struct fields can be
+// accessed with dots, e.g. user.name. Optional chaining (user?.name)
+// evaluates to nil instead of failing when the value on its left is nil, and
+// a ?? b evaluates to b when a is nil.
+//
+// Integers of any size are returned as int64 and floats as float64. Numbers
+// of different kinds are compared numerically, strings are compared
+// lexically and bools and nil only support == and !=. Logical operators use
+// the same truthiness as the generated TypeScript: nil, false, 0, NaN and ""
+// are falsy, every other value is truthy.
+func Evaluate(expr BooleanExpression, env map[string]any) (any, error) {
+	if expr.Literal() != "" {
+		return evaluateLiteral(expr.Literal(), env)
+	}
+	if expr.Parentheses() {
+		return Evaluate(expr.Inner(), env)
+	}
+
+	switch expr.Operator() {
+	case LogicalNot:
+		right, err := Evaluate(expr.Right(), env)
+		if err != nil {
+			return nil, err
+		}
+		return !Truthy(right), nil
+	case LogicalAnd, LogicalOr:
+		left, err := Evaluate(expr.Left(), env)
+		if err != nil {
+			return nil, err
+		}
+		if Truthy(left) == (expr.Operator() == LogicalOr) {
+			return Truthy(left), nil
+		}
+		right, err := Evaluate(expr.Right(), env)
+		if err != nil {
+			return nil, err
+		}
+		return Truthy(right), nil
+	case Coalesce:
+		left, err := Evaluate(expr.Left(), env)
+		if err != nil || !isNil(left) {
+			return left, err
+		}
+		return Evaluate(expr.Right(), env)
+	}
+
+	left, err := Evaluate(expr.Left(), env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := Evaluate(expr.Right(), env)
+	if err != nil {
+		return nil, err
+	}
+	return compare(expr.Operator(), left, right)
+}
+
+// Truthy reports whether v is truthy.
+func Truthy(v any) bool {
+	if isNil(v) {
+		return false
+	}
+	switch val := normalize(v).(type) {
+	case bool:
+		return val
+	case int64:
+		return val != 0
+	case float64:
+		return val != 0 && !math.IsNaN(val)
+	case string:
+		return val != ""
+	}
+	return true
+}
+
+func evaluateLiteral(literal string, env map[string]any) (any, error) {
+	switch {
+	case literal == "true":
+		return true, nil
+	case literal == "false":
+		return false, nil
+	case literal == "null":
+		return nil, nil
+	case strings.HasPrefix(literal, "\"") || strings.HasPrefix(literal, "'"):
+		value, ok := StringLiteral(literal)
+		if !ok {
+			return nil, fmt.Errorf("invalid string literal: %s", literal)
+		}
+		return value, nil
+	}
+
+	if i, err := strconv.ParseInt(literal, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(literal, 64); err == nil {
+		return f, nil
+	}
+
+	return lookup(literal, env)
+}
+
+// StringLiteral returns the value of a single or double quoted string literal.
+func StringLiteral(literal string) (string, bool) {
+	if strings.HasPrefix(literal, "'") {
+		if len(literal) < 2 || !strings.HasSuffix(literal, "'") {
+			return "", false
+		}
+		inner := strings.ReplaceAll(literal[1:len(literal)-1], "\\'", "'")
+		literal = "\"" + strings.ReplaceAll(inner, "\"", "\\\"") + "\""
+	}
+	value, err := strconv.Unquote(literal)
+	return value, err == nil && strings.HasPrefix(literal, "\"")
+}
+
+func lookup(path string, env map[string]any) (any, error) {
+	parts := strings.Split(path, ".")
+	value, ok := env[strings.TrimSuffix(parts[0], "?")]
+	if !ok {
+		return nil, &UndefinedVariableError{Name: strings.TrimSuffix(parts[0], "?")}
+	}
+
+	for i, part := range parts[1:] {
+		name := strings.Join(parts[:i+2], ".")
+		if isNil(value) {
+			if strings.HasSuffix(parts[i], "?") {
+				// optional chaining short-circuits the rest of the path
+				return nil, nil
+			}
+			return nil, &UndefinedVariableError{Name: name}
+		}
+
+		part = strings.TrimSuffix(part, "?")
+		v := reflect.Indirect(reflect.ValueOf(value))
+		switch {
+		case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+			member := v.MapIndex(reflect.ValueOf(part).Convert(v.Type().Key()))
+			if !member.IsValid() {
+				value = nil
+				continue
+			}
+			value = member.Interface()
+		case v.Kind() == reflect.Struct:
+			field := v.FieldByName(part)
+			if !field.IsValid() || !field.CanInterface() {
+				return nil, &UndefinedVariableError{Name: name}
+			}
+			value = field.Interface()
+		default:
+			return nil, &UndefinedVariableError{Name: name}
+		}
+	}
+
+	return value, nil
+}
+
+func compare(op BooleanOperator, left, right any) (any, error) {
+	mismatch := &TypeMismatchError{Operator: op, Left: left, Right: right}
+
+	if isNil(left) || isNil(right) {
+		switch op {
+		case Equal:
+			return isNil(left) && isNil(right), nil
+		case NotEqual:
+			return !(isNil(left) && isNil(right)), nil
+		}
+		return nil, mismatch
+	}
+
+	var cmp int
+	switch l := normalize(left).(type) {
+	case int64:
+		switch r := normalize(right).(type) {
+		case int64:
+			cmp = compareOrdered(l, r)
+		case float64:
+			cmp = compareOrdered(float64(l), r)
+		default:
+			return nil, mismatch
+		}
+	case float64:
+		switch r := normalize(right).(type) {
+		case int64:
+			cmp = compareOrdered(l, float64(r))
+		case float64:
+			cmp = compareOrdered(l, r)
+		default:
+			return nil, mismatch
+		}
+	case string:
+		r, ok := normalize(right).(string)
+		if !ok {
+			return nil, mismatch
+		}
+		cmp = compareOrdered(l, r)
+	case bool:
+		r, ok := normalize(right).(bool)
+		if !ok || (op != Equal && op != NotEqual) {
+			return nil, mismatch
+		}
+		if l != r {
+			cmp = 1
+		}
+	default:
+		return nil, mismatch
+	}
+
+	switch op {
+	case Equal:
+		return cmp == 0, nil
+	case NotEqual:
+		return cmp != 0, nil
+	case GreaterThan:
+		return cmp > 0, nil
+	case LessThan:
+		return cmp < 0, nil
+	case GreaterThanOrEqual:
+		return cmp >= 0, nil
+	case LessThanOrEqual:
+		return cmp <= 0, nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", op)
+}
+
+func compareOrdered[T int64 | float64 | string](l, r T) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// normalize converts numbers to int64 or float64 and unwraps named types.
+func normalize(v any) any {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return float64(u)
+		}
+		return int64(u)
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+	return v
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
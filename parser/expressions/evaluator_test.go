@@ -0,0 +1,137 @@
+package expressions
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluate(t *testing.T) {
+	type address struct {
+		City string
+	}
+	type user struct {
+		Name    string
+		Age     uint8
+		Address *address
+	}
+
+	env := map[string]any{
+		"qty":     3,
+		"price":   float32(2.5),
+		"name":    "guts",
+		"empty":   "",
+		"active":  true,
+		"missing": nil,
+		"items":   []string{},
+		"nan":     math.NaN(),
+		"user":    user{Name: "Ada", Age: 36, Address: &address{City: "London"}},
+		"nobody":  (*user)(nil),
+		"labels":  map[string]any{"title": "Hello", "count": int16(2)},
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  any
+	}{
+		{name: "int literal", input: "42", want: int64(42)},
+		{name: "float literal", input: "4.2", want: 4.2},
+		{name: "double quoted string literal", input: `"hello world"`, want: "hello world"},
+		{name: "single quoted string literal", input: `'it\'s'`, want: "it's"},
+		{name: "bool literal", input: "true", want: true},
+		{name: "null literal", input: "null", want: nil},
+		{name: "variable", input: "qty", want: 3},
+		{name: "typed variable", input: "qty:int", want: 3},
+		{name: "struct field", input: "user.Name", want: "Ada"},
+		{name: "nested pointer field", input: "user.Address.City", want: "London"},
+		{name: "map member", input: "labels.title", want: "Hello"},
+		{name: "absent map member", input: "labels.other", want: nil},
+		{name: "int equality", input: "qty == 3", want: true},
+		{name: "int and float are compared numerically", input: "qty > price", want: true},
+		{name: "unsigned and signed ints", input: "user.Age >= 36", want: true},
+		{name: "small int map member", input: "labels.count < qty", want: true},
+		{name: "float equality", input: "price == 2.5", want: true},
+		{name: "string equality", input: `name == "guts"`, want: true},
+		{name: "string inequality", input: `name != 'guts'`, want: false},
+		{name: "string ordering", input: `name < "h"`, want: true},
+		{name: "bool equality", input: "active == true", want: true},
+		{name: "nil equality", input: "missing == null", want: true},
+		{name: "nil pointer equality", input: "nobody == null", want: true},
+		{name: "nil inequality", input: "name != null", want: true},
+		{name: "logical not", input: "!active", want: false},
+		{name: "empty string is falsy", input: "!empty", want: true},
+		{name: "NaN is falsy", input: "!nan", want: true},
+		{name: "empty slice is truthy", input: "!items", want: false},
+		{name: "logical and", input: "qty > 1 && active", want: true},
+		{name: "logical and short circuits", input: "missing != null && missing.value == 1", want: false},
+		{name: "logical or", input: "qty > 10 || name == \"guts\"", want: true},
+		{name: "logical or short circuits", input: "active || undefined", want: true},
+		{name: "parentheses", input: "!(qty > 1 && empty)", want: true},
+		{name: "optional chaining", input: "user?.Address?.City", want: "London"},
+		{name: "optional chaining on nil", input: "nobody?.Address.City", want: nil},
+		{name: "optional chaining on absent member", input: "labels.other?.value", want: nil},
+		{name: "nullish coalescing", input: "missing ?? name", want: "guts"},
+		{name: "nullish coalescing keeps falsy values", input: "empty ?? name", want: ""},
+		{name: "nullish coalescing of optional chain", input: "nobody?.Name ?? 'anonymous'", want: "anonymous"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := NewBooleanExpression(tt.input)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, err := Evaluate(expr, env)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	env := map[string]any{
+		"qty":     3,
+		"name":    "guts",
+		"active":  true,
+		"missing": nil,
+		"user":    struct{ Name string }{Name: "Ada"},
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		undefined string
+		mismatch  BooleanOperator
+	}{
+		{name: "undefined variable", input: "count > 1", undefined: "count"},
+		{name: "undefined struct field", input: "user.Email == \"\"", undefined: "user.Email"},
+		{name: "member of a string", input: "name.first", undefined: "name.first"},
+		{name: "member of nil without optional chaining", input: "missing.name ?? name", undefined: "missing.name"},
+		{name: "int and string", input: "qty == \"3\"", mismatch: Equal},
+		{name: "ordering bools", input: "active > false", mismatch: GreaterThan},
+		{name: "ordering nil", input: "null < qty", mismatch: LessThan},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := NewBooleanExpression(tt.input)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = Evaluate(expr, env)
+			if tt.undefined != "" {
+				var undefinedErr *UndefinedVariableError
+				if assert.ErrorAs(t, err, &undefinedErr) {
+					assert.Equal(t, tt.undefined, undefinedErr.Name)
+				}
+			} else {
+				var mismatchErr *TypeMismatchError
+				if assert.ErrorAs(t, err, &mismatchErr) {
+					assert.Equal(t, tt.mismatch, mismatchErr.Operator)
+				}
+			}
+		})
+	}
+}
@@ -1,28 +1,46 @@
 package expressions
 
 import (
-	"regexp"
+	"strconv"
 	"strings"
 )
 
 type ExpressionBaseType string
 
 const (
-	ExpressionBaseTypeString ExpressionBaseType = "string"
-	ExpressionBaseTypeInt    ExpressionBaseType = "int"
-	ExpressionBaseTypeFloat  ExpressionBaseType = "float"
-	ExpressionBaseTypeBool   ExpressionBaseType = "bool"
-	ExpressionBaseTypeArray  ExpressionBaseType = "array"
-	ExpressionBaseTypeMap    ExpressionBaseType = "map"
+	ExpressionBaseTypeString  ExpressionBaseType = "string"
+	ExpressionBaseTypeInt     ExpressionBaseType = "int"
+	ExpressionBaseTypeFloat   ExpressionBaseType = "float"
+	ExpressionBaseTypeBool    ExpressionBaseType = "bool"
+	ExpressionBaseTypeArray   ExpressionBaseType = "array"
+	ExpressionBaseTypeMap     ExpressionBaseType = "map"
+	ExpressionBaseTypeObject  ExpressionBaseType = "object"
+	ExpressionBaseTypeUnion   ExpressionBaseType = "union"
+	ExpressionBaseTypeUnknown ExpressionBaseType = "unknown"
+	// ExpressionBaseTypeDate is a calendar date such as "2024-01-31".
+	ExpressionBaseTypeDate ExpressionBaseType = "date"
+	// ExpressionBaseTypeDateTime is an RFC 3339 timestamp such as
+	// "2024-01-31T09:30:00Z".
+	ExpressionBaseTypeDateTime ExpressionBaseType = "datetime"
+	// ExpressionBaseTypeDuration is an ISO 8601 duration such as "PT1H30M".
+	ExpressionBaseTypeDuration ExpressionBaseType = "duration"
+	// ExpressionBaseTypeDecimal is an exact decimal number such as "19.90".
+	// Decimals are strings so that amounts of money never become floats.
+	ExpressionBaseTypeDecimal ExpressionBaseType = "decimal"
 )
 
 var expressionBaseTypeMap = map[string]ExpressionBaseType{
-	"string": ExpressionBaseTypeString,
-	"int":    ExpressionBaseTypeInt,
-	"float":  ExpressionBaseTypeFloat,
-	"bool":   ExpressionBaseTypeBool,
-	"array":  ExpressionBaseTypeArray,
-	"map":    ExpressionBaseTypeMap,
+	"string":   ExpressionBaseTypeString,
+	"int":      ExpressionBaseTypeInt,
+	"float":    ExpressionBaseTypeFloat,
+	"bool":     ExpressionBaseTypeBool,
+	"array":    ExpressionBaseTypeArray,
+	"map":      ExpressionBaseTypeMap,
+	"unknown":  ExpressionBaseTypeUnknown,
+	"date":     ExpressionBaseTypeDate,
+	"datetime": ExpressionBaseTypeDateTime,
+	"duration": ExpressionBaseTypeDuration,
+	"decimal":  ExpressionBaseTypeDecimal,
 }
 
 func ParseExpressionBaseType(s string) (ExpressionBaseType, bool) {
@@ -30,97 +48,287 @@ func ParseExpressionBaseType(s string) (ExpressionBaseType, bool) {
 	return t, ok
 }
 
+// ExpressionType is a possibly nested type: a primitive, a string literal, an
+// array of an element type, a map from a key type to a value type, an object
+// or a union of other types.
 type ExpressionType interface {
 	BaseType() ExpressionBaseType
-	KeyType() ExpressionBaseType
-	ValueType() ExpressionBaseType
+	// KeyType is the key type of a map, int for arrays and nil otherwise.
+	KeyType() ExpressionType
+	// ValueType is the element type of an array or map and nil otherwise.
+	ValueType() ExpressionType
+	// Name is the name of a reference to a named object type, e.g. LineItem.
+	// The fields of a named type are only known to the document declaring it.
+	Name() string
+	// Fields are the fields of an anonymous object type in declaration order.
+	Fields() []ObjectField
+	Field(name string) (ExpressionType, bool)
+	// Literal is the value of a string literal type such as "pending", whose
+	// base type is string.
+	Literal() (string, bool)
+	// Members are the members of a union type.
+	Members() []ExpressionType
+	Optional() bool
+	WithOptional(optional bool) ExpressionType
 	Equals(other ExpressionType) bool
 	String() string
 }
 
+// ObjectField is a field of an object type.
+type ObjectField struct {
+	Name string
+	Type ExpressionType
+}
+
 type expressionType struct {
 	baseType  ExpressionBaseType
-	keyType   ExpressionBaseType
-	valueType ExpressionBaseType
+	keyType   ExpressionType
+	valueType ExpressionType
+	name      string
+	fields    []ObjectField
+	literal   *string
+	members   []ExpressionType
+	optional  bool
+}
+
+func NewPrimitiveType(baseType ExpressionBaseType) ExpressionType {
+	return &expressionType{baseType: baseType}
 }
 
-func NewExpressionType(baseType ExpressionBaseType, keyType ExpressionBaseType, valueType ExpressionBaseType) ExpressionType {
+func NewArrayType(valueType ExpressionType) ExpressionType {
 	return &expressionType{
-		baseType:  baseType,
+		baseType:  ExpressionBaseTypeArray,
+		keyType:   NewPrimitiveType(ExpressionBaseTypeInt),
+		valueType: valueType,
+	}
+}
+
+func NewMapType(keyType ExpressionType, valueType ExpressionType) ExpressionType {
+	return &expressionType{
+		baseType:  ExpressionBaseTypeMap,
 		keyType:   keyType,
 		valueType: valueType,
 	}
 }
 
+func NewObjectType(fields []ObjectField) ExpressionType {
+	return &expressionType{
+		baseType: ExpressionBaseTypeObject,
+		fields:   fields,
+	}
+}
+
+// NewNamedType returns a reference to the object type declared as name.
+func NewNamedType(name string) ExpressionType {
+	return &expressionType{
+		baseType: ExpressionBaseTypeObject,
+		name:     name,
+	}
+}
+
+func NewLiteralType(value string) ExpressionType {
+	return &expressionType{
+		baseType: ExpressionBaseTypeString,
+		literal:  &value,
+	}
+}
+
+// NewUnionType returns the union of members. Nested unions are flattened,
+// duplicates removed and optional members make the union optional. A union of
+// a single type is that type.
+func NewUnionType(members []ExpressionType) ExpressionType {
+	var flat []ExpressionType
+	optional := false
+	for _, member := range members {
+		optional = optional || member.Optional()
+		inner := []ExpressionType{member.WithOptional(false)}
+		if member.BaseType() == ExpressionBaseTypeUnion {
+			inner = member.Members()
+		}
+		for _, m := range inner {
+			if !containsType(flat, m) {
+				flat = append(flat, m)
+			}
+		}
+	}
+
+	if len(flat) == 1 {
+		return flat[0].WithOptional(optional)
+	}
+	return &expressionType{
+		baseType: ExpressionBaseTypeUnion,
+		members:  flat,
+		optional: optional,
+	}
+}
+
+func containsType(types []ExpressionType, t ExpressionType) bool {
+	for _, other := range types {
+		if other.Equals(t) {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *expressionType) BaseType() ExpressionBaseType {
 	return e.baseType
 }
 
-func (e *expressionType) KeyType() ExpressionBaseType {
+func (e *expressionType) KeyType() ExpressionType {
 	return e.keyType
 }
 
-func (e *expressionType) ValueType() ExpressionBaseType {
+func (e *expressionType) ValueType() ExpressionType {
 	return e.valueType
 }
 
-func (e *expressionType) Equals(other ExpressionType) bool {
-	return e.baseType == other.BaseType() &&
-		e.keyType == other.KeyType() &&
-		e.valueType == other.ValueType()
+func (e *expressionType) Name() string {
+	return e.name
 }
 
-func (e *expressionType) String() string {
-	if e.baseType == ExpressionBaseTypeArray {
-		return string(e.valueType) + "[]"
+func (e *expressionType) Fields() []ObjectField {
+	return e.fields
+}
+
+func (e *expressionType) Field(name string) (ExpressionType, bool) {
+	for _, field := range e.fields {
+		if field.Name == name {
+			return field.Type, true
+		}
 	}
+	return nil, false
+}
 
-	if e.baseType == ExpressionBaseTypeMap {
-		return "map[" + string(e.keyType) + "," + string(e.valueType) + "]"
+func (e *expressionType) Literal() (string, bool) {
+	if e.literal == nil {
+		return "", false
 	}
+	return *e.literal, true
+}
 
-	return string(e.baseType)
+func (e *expressionType) Members() []ExpressionType {
+	return e.members
 }
 
-var _arrayTypeRegex = regexp.MustCompile(`^\s*(\w+)\[\]\s*$`)
-var _mapTypeRegex = regexp.MustCompile(`^\s*map\[\s*(.*)\s*,\s*(.*)\s*\]\s*$`)
+// Optional reports whether a value of this type may be null or absent.
+func (e *expressionType) Optional() bool {
+	return e.optional
+}
 
-func ParseExpressionType(s string) (ExpressionType, bool) {
-	s = strings.TrimSpace(s)
+// WithOptional returns a copy of the type with the given optionality.
+func (e *expressionType) WithOptional(optional bool) ExpressionType {
+	clone := *e
+	clone.optional = optional
+	return &clone
+}
+
+// Equals compares types structurally. Named types are equal if their names
+// are, the fields of anonymous objects and the members of unions are compared
+// regardless of their order.
+func (e *expressionType) Equals(other ExpressionType) bool {
+	if other == nil {
+		return false
+	}
+	literal, isLiteral := e.Literal()
+	otherLiteral, otherIsLiteral := other.Literal()
+	return e.baseType == other.BaseType() &&
+		e.optional == other.Optional() &&
+		e.name == other.Name() &&
+		isLiteral == otherIsLiteral && literal == otherLiteral &&
+		typesEqual(e.keyType, other.KeyType()) &&
+		typesEqual(e.valueType, other.ValueType()) &&
+		fieldsEqual(e, other) &&
+		membersEqual(e, other)
+}
 
-	if strings.HasSuffix(s, "[]") {
-		matches := _arrayTypeRegex.FindStringSubmatch(s)
-		if len(matches) != 2 {
-			return nil, false
+func membersEqual(a, b ExpressionType) bool {
+	if len(a.Members()) != len(b.Members()) {
+		return false
+	}
+	for _, member := range a.Members() {
+		if !containsType(b.Members(), member) {
+			return false
 		}
+	}
+	return true
+}
 
-		valueType, ok := ParseExpressionBaseType(s[:len(s)-2])
-		if !ok {
-			return nil, false
+func fieldsEqual(a, b ExpressionType) bool {
+	if len(a.Fields()) != len(b.Fields()) {
+		return false
+	}
+	for _, field := range a.Fields() {
+		typ, ok := b.Field(field.Name)
+		if !ok || !field.Type.Equals(typ) {
+			return false
 		}
-		return NewExpressionType(ExpressionBaseTypeArray, ExpressionBaseTypeInt, valueType), true
 	}
+	return true
+}
+
+func typesEqual(a, b ExpressionType) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equals(b)
+}
 
-	if strings.HasPrefix(s, "map[") {
-		matches := _mapTypeRegex.FindStringSubmatch(s)
-		if len(matches) != 3 {
-			return nil, false
+func (e *expressionType) String() string {
+	if e.optional {
+		if e.baseType == ExpressionBaseTypeUnion {
+			return e.WithOptional(false).String() + " | null"
 		}
+		return e.WithOptional(false).String() + "?"
+	}
+
+	if literal, ok := e.Literal(); ok {
+		return strconv.Quote(literal)
+	}
 
-		keyType, ok := ParseExpressionBaseType(matches[1])
-		if !ok {
-			return nil, false
+	if e.baseType == ExpressionBaseTypeArray {
+		if e.valueType.BaseType() == ExpressionBaseTypeUnion {
+			return "(" + e.valueType.String() + ")[]"
 		}
-		valueType, ok := ParseExpressionBaseType(matches[2])
-		if !ok {
-			return nil, false
+		return e.valueType.String() + "[]"
+	}
+
+	if e.baseType == ExpressionBaseTypeMap {
+		return "map[" + e.keyType.String() + "," + e.valueType.String() + "]"
+	}
+
+	if e.name != "" {
+		return e.name
+	}
+
+	if e.baseType == ExpressionBaseTypeObject {
+		fields := make([]string, len(e.fields))
+		for i, field := range e.fields {
+			fields[i] = field.Name + ": " + field.Type.String()
 		}
-		return NewExpressionType(ExpressionBaseTypeMap, keyType, valueType), true
+		return "{" + strings.Join(fields, ", ") + "}"
 	}
 
-	if t, ok := ParseExpressionBaseType(s); ok {
-		return NewExpressionType(t, "", t), ok
+	if e.baseType == ExpressionBaseTypeUnion {
+		members := make([]string, len(e.members))
+		for i, member := range e.members {
+			members[i] = member.String()
+		}
+		return strings.Join(members, " | ")
 	}
 
-	return nil, false
+	return string(e.baseType)
+}
+
+// ParseExpressionType parses type syntax such as int, string[][],
+// map[string,int[]], {sku: string, qty: int}, "pending" | "shipped" or
+// LineItem[]. Names starting with an upper case letter refer to named object
+// types, which are not resolved here.
+func ParseExpressionType(s string) (ExpressionType, bool) {
+	p := &typeParser{runes: []rune(s)}
+	t, err := p.parse()
+	if err != nil {
+		return nil, false
+	}
+	return t, true
 }
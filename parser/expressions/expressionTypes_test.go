@@ -16,39 +16,177 @@ func TestParseExpressionType(t *testing.T) {
 		{
 			name:     "simple string type",
 			input:    "string",
-			want:     NewExpressionType(ExpressionBaseTypeString, "", ExpressionBaseTypeString),
+			want:     NewPrimitiveType(ExpressionBaseTypeString),
 			wantBool: true,
 		},
 		{
 			name:     "simple int type",
 			input:    "int",
-			want:     NewExpressionType(ExpressionBaseTypeInt, "", ExpressionBaseTypeInt),
+			want:     NewPrimitiveType(ExpressionBaseTypeInt),
 			wantBool: true,
 		},
 		{
 			name:     "array of strings",
 			input:    "string[]",
-			want:     NewExpressionType(ExpressionBaseTypeArray, ExpressionBaseTypeInt, ExpressionBaseTypeString),
+			want:     NewArrayType(NewPrimitiveType(ExpressionBaseTypeString)),
 			wantBool: true,
 		},
 		{
 			name:     "array of ints",
 			input:    "int[]",
-			want:     NewExpressionType(ExpressionBaseTypeArray, ExpressionBaseTypeInt, ExpressionBaseTypeInt),
+			want:     NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)),
 			wantBool: true,
 		},
 		{
 			name:     "map with string key and int value",
 			input:    "map[string, int]",
-			want:     NewExpressionType(ExpressionBaseTypeMap, ExpressionBaseTypeString, ExpressionBaseTypeInt),
+			want:     NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewPrimitiveType(ExpressionBaseTypeInt)),
 			wantBool: true,
 		},
 		{
 			name:     "map with int key and bool value",
 			input:    "map[int, bool]",
-			want:     NewExpressionType(ExpressionBaseTypeMap, ExpressionBaseTypeInt, ExpressionBaseTypeBool),
+			want:     NewMapType(NewPrimitiveType(ExpressionBaseTypeInt), NewPrimitiveType(ExpressionBaseTypeBool)),
 			wantBool: true,
 		},
+		{
+			name:     "nested array",
+			input:    "string[][]",
+			want:     NewArrayType(NewArrayType(NewPrimitiveType(ExpressionBaseTypeString))),
+			wantBool: true,
+		},
+		{
+			name:     "map with array values",
+			input:    "map[string, int[]]",
+			want:     NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt))),
+			wantBool: true,
+		},
+		{
+			name:     "array of maps",
+			input:    "map[string,int][]",
+			want:     NewArrayType(NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewPrimitiveType(ExpressionBaseTypeInt))),
+			wantBool: true,
+		},
+		{
+			name:     "map with map values",
+			input:    "map[string, map[int, bool[]]]",
+			want:     NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewMapType(NewPrimitiveType(ExpressionBaseTypeInt), NewArrayType(NewPrimitiveType(ExpressionBaseTypeBool)))),
+			wantBool: true,
+		},
+		{
+			name:  "object",
+			input: "{sku: string, tags: string[], dimensions: {width: float}}",
+			want: NewObjectType([]ObjectField{
+				{Name: "sku", Type: NewPrimitiveType(ExpressionBaseTypeString)},
+				{Name: "tags", Type: NewArrayType(NewPrimitiveType(ExpressionBaseTypeString))},
+				{Name: "dimensions", Type: NewObjectType([]ObjectField{{Name: "width", Type: NewPrimitiveType(ExpressionBaseTypeFloat)}})},
+			}),
+			wantBool: true,
+		},
+		{
+			name:     "array of named types",
+			input:    "LineItem[]",
+			want:     NewArrayType(NewNamedType("LineItem")),
+			wantBool: true,
+		},
+		{
+			name:     "map of named types",
+			input:    "map[string, Customer]",
+			want:     NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewNamedType("Customer")),
+			wantBool: true,
+		},
+		{
+			name:     "optional type",
+			input:    "string?",
+			want:     NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true),
+			wantBool: true,
+		},
+		{
+			name:     "union with null",
+			input:    "string | null",
+			want:     NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true),
+			wantBool: true,
+		},
+		{
+			name:     "array of optional elements",
+			input:    "null | int?[]",
+			want:     NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt).WithOptional(true)).WithOptional(true),
+			wantBool: true,
+		},
+		{
+			name:     "optional array",
+			input:    "LineItem[]?",
+			want:     NewArrayType(NewNamedType("LineItem")).WithOptional(true),
+			wantBool: true,
+		},
+		{
+			name:  "optional map values and fields",
+			input: "map[string, {nickname: string | null}?]",
+			want: NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewObjectType([]ObjectField{
+				{Name: "nickname", Type: NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true)},
+			}).WithOptional(true)),
+			wantBool: true,
+		},
+		{
+			name:     "null alone",
+			input:    "null",
+			want:     nil,
+			wantBool: false,
+		},
+		{
+			name:     "union of types",
+			input:    "string | int",
+			want:     NewUnionType([]ExpressionType{NewPrimitiveType(ExpressionBaseTypeString), NewPrimitiveType(ExpressionBaseTypeInt)}),
+			wantBool: true,
+		},
+		{
+			name:  "string literal union",
+			input: `"pending" | 'shipped' | "can\"celled"`,
+			want: NewUnionType([]ExpressionType{
+				NewLiteralType("pending"),
+				NewLiteralType("shipped"),
+				NewLiteralType("can\"celled"),
+			}),
+			wantBool: true,
+		},
+		{
+			name:  "optional array of unions",
+			input: `("small" | "large" | null)[] | null`,
+			want: NewArrayType(NewUnionType([]ExpressionType{
+				NewLiteralType("small"),
+				NewLiteralType("large"),
+			}).WithOptional(true)).WithOptional(true),
+			wantBool: true,
+		},
+		{
+			name:     "union of a single type",
+			input:    `"pending" | "pending"`,
+			want:     NewLiteralType("pending"),
+			wantBool: true,
+		},
+		{
+			name:  "date, time and decimal types",
+			input: "{due: date, placed: datetime?, elapsed: duration, totals: map[string, decimal]}",
+			want: NewObjectType([]ObjectField{
+				{Name: "due", Type: NewPrimitiveType(ExpressionBaseTypeDate)},
+				{Name: "placed", Type: NewPrimitiveType(ExpressionBaseTypeDateTime).WithOptional(true)},
+				{Name: "elapsed", Type: NewPrimitiveType(ExpressionBaseTypeDuration)},
+				{Name: "totals", Type: NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewPrimitiveType(ExpressionBaseTypeDecimal))},
+			}),
+			wantBool: true,
+		},
+		{
+			name:     "unterminated string literal",
+			input:    `"pending | "shipped"`,
+			want:     nil,
+			wantBool: false,
+		},
+		{
+			name:     "unterminated parentheses",
+			input:    `("small" | "large"[]`,
+			want:     nil,
+			wantBool: false,
+		},
 		{
 			name:     "invalid type",
 			input:    "invalid",
@@ -67,6 +205,36 @@ func TestParseExpressionType(t *testing.T) {
 			want:     nil,
 			wantBool: false,
 		},
+		{
+			name:     "map without key and value types",
+			input:    "map",
+			want:     nil,
+			wantBool: false,
+		},
+		{
+			name:     "nested array with invalid element type",
+			input:    "invalid[][]",
+			want:     nil,
+			wantBool: false,
+		},
+		{
+			name:     "object with duplicate field",
+			input:    "{sku: string, sku: int}",
+			want:     nil,
+			wantBool: false,
+		},
+		{
+			name:     "object without field type",
+			input:    "{sku}",
+			want:     nil,
+			wantBool: false,
+		},
+		{
+			name:     "unterminated object",
+			input:    "{sku: string",
+			want:     nil,
+			wantBool: false,
+		},
 		{
 			name:     "map with invalid key type",
 			input:    "map[invalid, int]",
@@ -91,15 +259,7 @@ func TestParseExpressionType(t *testing.T) {
 			if !tt.wantBool {
 				return
 			}
-			if got.BaseType() != tt.want.BaseType() {
-				t.Errorf("ParseExpressionType() baseType = %v, want %v", got.BaseType(), tt.want.BaseType())
-			}
-			if got.KeyType() != tt.want.KeyType() {
-				t.Errorf("ParseExpressionType() keyType = %v, want %v", got.KeyType(), tt.want.KeyType())
-			}
-			if got.ValueType() != tt.want.ValueType() {
-				t.Errorf("ParseExpressionType() valueType = %v, want %v", got.ValueType(), tt.want.ValueType())
-			}
+			assert.True(t, tt.want.Equals(got), "ParseExpressionType() = %v, want %v", got, tt.want)
 		})
 	}
 }
@@ -112,44 +272,82 @@ func TestExpressionType_String(t *testing.T) {
 	}{
 		{
 			name: "string type",
-			expr: NewExpressionType(ExpressionBaseTypeString, "", ExpressionBaseTypeString),
+			expr: NewPrimitiveType(ExpressionBaseTypeString),
 			want: "string",
 		},
 		{
 			name: "int type",
-			expr: NewExpressionType(ExpressionBaseTypeInt, "", ExpressionBaseTypeInt),
+			expr: NewPrimitiveType(ExpressionBaseTypeInt),
 			want: "int",
 		},
 		{
 			name: "bool type",
-			expr: NewExpressionType(ExpressionBaseTypeBool, "", ExpressionBaseTypeBool),
+			expr: NewPrimitiveType(ExpressionBaseTypeBool),
 			want: "bool",
 		},
 		{
 			name: "float type",
-			expr: NewExpressionType(ExpressionBaseTypeFloat, "", ExpressionBaseTypeFloat),
+			expr: NewPrimitiveType(ExpressionBaseTypeFloat),
 			want: "float",
 		},
 		{
 			name: "string array",
-			expr: NewExpressionType(ExpressionBaseTypeArray, ExpressionBaseTypeInt, ExpressionBaseTypeString),
+			expr: NewArrayType(NewPrimitiveType(ExpressionBaseTypeString)),
 			want: "string[]",
 		},
 		{
 			name: "int array",
-			expr: NewExpressionType(ExpressionBaseTypeArray, ExpressionBaseTypeInt, ExpressionBaseTypeInt),
+			expr: NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)),
 			want: "int[]",
 		},
 		{
 			name: "map[string, int]",
-			expr: NewExpressionType(ExpressionBaseTypeMap, ExpressionBaseTypeString, ExpressionBaseTypeInt),
+			expr: NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewPrimitiveType(ExpressionBaseTypeInt)),
 			want: "map[string,int]",
 		},
 		{
 			name: "map[int, bool]",
-			expr: NewExpressionType(ExpressionBaseTypeMap, ExpressionBaseTypeInt, ExpressionBaseTypeBool),
+			expr: NewMapType(NewPrimitiveType(ExpressionBaseTypeInt), NewPrimitiveType(ExpressionBaseTypeBool)),
 			want: "map[int,bool]",
 		},
+		{
+			name: "nested array",
+			expr: NewArrayType(NewArrayType(NewPrimitiveType(ExpressionBaseTypeString))),
+			want: "string[][]",
+		},
+		{
+			name: "array of maps",
+			expr: NewArrayType(NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)))),
+			want: "map[string,int[]][]",
+		},
+		{
+			name: "object",
+			expr: NewObjectType([]ObjectField{
+				{Name: "sku", Type: NewPrimitiveType(ExpressionBaseTypeString)},
+				{Name: "lines", Type: NewArrayType(NewNamedType("LineItem"))},
+			}),
+			want: "{sku: string, lines: LineItem[]}",
+		},
+		{
+			name: "optional array of optional elements",
+			expr: NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt).WithOptional(true)).WithOptional(true),
+			want: "int?[]?",
+		},
+		{
+			name: "string literal",
+			expr: NewLiteralType(`say "hi"`),
+			want: `"say \"hi\""`,
+		},
+		{
+			name: "optional union",
+			expr: NewUnionType([]ExpressionType{NewLiteralType("a"), NewPrimitiveType(ExpressionBaseTypeInt)}).WithOptional(true),
+			want: `"a" | int | null`,
+		},
+		{
+			name: "array of optional unions",
+			expr: NewArrayType(NewUnionType([]ExpressionType{NewLiteralType("a"), NewLiteralType("b").WithOptional(true)})),
+			want: `("a" | "b" | null)[]`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -159,3 +357,34 @@ func TestExpressionType_String(t *testing.T) {
 		})
 	}
 }
+
+func TestExpressionType_Equals(t *testing.T) {
+	matrix := NewArrayType(NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)))
+
+	assert.True(t, matrix.Equals(NewArrayType(NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)))))
+	assert.False(t, matrix.Equals(NewArrayType(NewArrayType(NewPrimitiveType(ExpressionBaseTypeFloat)))))
+	assert.False(t, matrix.Equals(NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt))))
+	assert.False(t, matrix.Equals(NewMapType(NewPrimitiveType(ExpressionBaseTypeInt), NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)))))
+	assert.False(t, matrix.Equals(nil))
+
+	item := NewObjectType([]ObjectField{
+		{Name: "sku", Type: NewPrimitiveType(ExpressionBaseTypeString)},
+		{Name: "qty", Type: NewPrimitiveType(ExpressionBaseTypeInt)},
+	})
+	assert.True(t, item.Equals(NewObjectType([]ObjectField{
+		{Name: "qty", Type: NewPrimitiveType(ExpressionBaseTypeInt)},
+		{Name: "sku", Type: NewPrimitiveType(ExpressionBaseTypeString)},
+	})))
+	assert.False(t, item.Equals(NewObjectType([]ObjectField{
+		{Name: "sku", Type: NewPrimitiveType(ExpressionBaseTypeString)},
+	})))
+	assert.False(t, item.Equals(NewNamedType("LineItem")))
+	assert.True(t, NewNamedType("LineItem").Equals(NewNamedType("LineItem")))
+	assert.False(t, NewNamedType("LineItem").Equals(NewNamedType("Product")))
+
+	status := NewUnionType([]ExpressionType{NewLiteralType("pending"), NewLiteralType("shipped")})
+	assert.True(t, status.Equals(NewUnionType([]ExpressionType{NewLiteralType("shipped"), NewLiteralType("pending")})))
+	assert.False(t, status.Equals(NewUnionType([]ExpressionType{NewLiteralType("pending"), NewLiteralType("cancelled")})))
+	assert.False(t, NewLiteralType("pending").Equals(NewPrimitiveType(ExpressionBaseTypeString)))
+	assert.False(t, NewLiteralType("").Equals(NewPrimitiveType(ExpressionBaseTypeString)))
+}
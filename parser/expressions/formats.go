@@ -0,0 +1,29 @@
+package expressions
+
+import (
+	"regexp"
+	"time"
+)
+
+var (
+	_durationRegex = regexp.MustCompile(`^-?P(?:\d+Y)?(?:\d+M)?(?:\d+W)?(?:\d+D)?(?:T(?:\d+H)?(?:\d+M)?(?:\d+(?:\.\d+)?S)?)?$`)
+	_decimalRegex  = regexp.MustCompile(`^-?\d+(?:\.\d+)?$`)
+)
+
+// ValidValue reports whether s is a valid value of a date, datetime,
+// duration or decimal type, which are all represented as strings.
+func ValidValue(baseType ExpressionBaseType, s string) bool {
+	switch baseType {
+	case ExpressionBaseTypeDate:
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case ExpressionBaseTypeDateTime:
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case ExpressionBaseTypeDuration:
+		return _durationRegex.MatchString(s) && s != "P" && s != "-P" && s[len(s)-1] != 'T'
+	case ExpressionBaseTypeDecimal:
+		return _decimalRegex.MatchString(s)
+	}
+	return false
+}
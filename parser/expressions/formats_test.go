@@ -0,0 +1,39 @@
+package expressions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidValue(t *testing.T) {
+	tests := []struct {
+		baseType ExpressionBaseType
+		value    string
+		want     bool
+	}{
+		{ExpressionBaseTypeDate, "2024-01-31", true},
+		{ExpressionBaseTypeDate, "2024-02-30", false},
+		{ExpressionBaseTypeDate, "2024-01-31T09:30:00Z", false},
+		{ExpressionBaseTypeDateTime, "2024-01-31T09:30:00Z", true},
+		{ExpressionBaseTypeDateTime, "2024-01-31T09:30:00.250+01:00", true},
+		{ExpressionBaseTypeDateTime, "2024-01-31", false},
+		{ExpressionBaseTypeDuration, "PT1H30M", true},
+		{ExpressionBaseTypeDuration, "P1DT0.5S", true},
+		{ExpressionBaseTypeDuration, "-P2W", true},
+		{ExpressionBaseTypeDuration, "P", false},
+		{ExpressionBaseTypeDuration, "PT", false},
+		{ExpressionBaseTypeDuration, "1h30m", false},
+		{ExpressionBaseTypeDecimal, "19.90", true},
+		{ExpressionBaseTypeDecimal, "-1000", true},
+		{ExpressionBaseTypeDecimal, "1e3", false},
+		{ExpressionBaseTypeDecimal, ".5", false},
+		{ExpressionBaseTypeString, "19.90", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.baseType)+" "+tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, ValidValue(tt.baseType, tt.value))
+		})
+	}
+}
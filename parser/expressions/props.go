@@ -0,0 +1,191 @@
+package expressions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Prop is an input of a template declared in its props block.
+type Prop struct {
+	Name string
+	Type ExpressionType
+	// Default is the value used when the prop is not given: a string, int64,
+	// float64, bool, nil, an empty []any or an empty map[string]any.
+	Default    any
+	HasDefault bool
+}
+
+// ParseProps parses the declarations of a props block, e.g.
+//
+//	title: string, count: int = 0
+//	status: "open" | "closed" = "open"
+//
+// Declarations are separated by commas or new lines.
+func ParseProps(s string) ([]Prop, error) {
+	p := &typeParser{runes: []rune(s)}
+	var props []Prop
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.runes) {
+			return props, nil
+		}
+
+		prop, err := p.parseProp()
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range props {
+			if other.Name == prop.Name {
+				return nil, fmt.Errorf("prop %s is already declared", prop.Name)
+			}
+		}
+		props = append(props, prop)
+		if !p.consume(",") && !p.skipLine() {
+			return nil, fmt.Errorf("expected , or a new line after prop %s", prop.Name)
+		}
+	}
+}
+
+// skipLine skips whitespace, reporting whether the whitespace around the
+// position, which parsing the last value may already have skipped, contains a
+// new line or reaches the end of the input.
+func (p *typeParser) skipLine() bool {
+	start := p.pos
+	for start > 0 && unicode.IsSpace(p.runes[start-1]) {
+		start--
+	}
+	p.skipSpace()
+	return p.pos >= len(p.runes) || strings.ContainsRune(string(p.runes[start:p.pos]), '\n')
+}
+
+func (p *typeParser) parseProp() (Prop, error) {
+	name := p.parseIdentifier()
+	if name == "" {
+		return Prop{}, fmt.Errorf("expected prop name")
+	}
+	if !p.consume(":") {
+		return Prop{}, fmt.Errorf("expected : after prop %s", name)
+	}
+	typ, err := p.parseType()
+	if err != nil {
+		return Prop{}, fmt.Errorf("invalid type of prop %s: %w", name, err)
+	}
+
+	prop := Prop{Name: name, Type: typ}
+	if p.consume("=") {
+		prop.Default, err = p.parseValue()
+		if err != nil {
+			return Prop{}, fmt.Errorf("invalid default of prop %s: %w", name, err)
+		}
+		prop.HasDefault = true
+		if !AcceptsValue(typ, prop.Default) {
+			return Prop{}, fmt.Errorf("default of prop %s is not a %s", name, typ.String())
+		}
+	}
+	return prop, nil
+}
+
+// AcceptsValue reports whether value, a default as returned by ParseProps, is
+// of type t. An empty object is accepted for objects whose fields are all
+// optional and for named types, which the caller resolves.
+func AcceptsValue(t ExpressionType, value any) bool {
+	if t.BaseType() == ExpressionBaseTypeUnknown {
+		return true
+	}
+	if value == nil {
+		return t.Optional()
+	}
+	if t.BaseType() == ExpressionBaseTypeUnion {
+		for _, member := range t.Members() {
+			if AcceptsValue(member, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch v := value.(type) {
+	case string:
+		if literal, ok := t.Literal(); ok {
+			return literal == v
+		}
+		return t.BaseType() == ExpressionBaseTypeString || ValidValue(t.BaseType(), v)
+	case int64:
+		return t.BaseType() == ExpressionBaseTypeInt || t.BaseType() == ExpressionBaseTypeFloat
+	case float64:
+		return t.BaseType() == ExpressionBaseTypeFloat
+	case bool:
+		return t.BaseType() == ExpressionBaseTypeBool
+	case []any:
+		return t.BaseType() == ExpressionBaseTypeArray
+	case map[string]any:
+		if t.BaseType() == ExpressionBaseTypeMap || t.Name() != "" {
+			return true
+		}
+		if t.BaseType() != ExpressionBaseTypeObject {
+			return false
+		}
+		for _, field := range t.Fields() {
+			if !field.Type.Optional() {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// parseValue parses a literal default value.
+func (p *typeParser) parseValue() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.runes) {
+		return nil, fmt.Errorf("expected value")
+	}
+
+	switch r := p.runes[p.pos]; {
+	case r == '"' || r == '\'':
+		t, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		value, _ := t.Literal()
+		return value, nil
+	case r == '-' || unicode.IsDigit(r):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.runes) && (unicode.IsDigit(p.runes[p.pos]) || p.runes[p.pos] == '.') {
+			p.pos++
+		}
+		number := string(p.runes[start:p.pos])
+		if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(number, 64); err == nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("invalid number: %s", number)
+	case p.consume("["):
+		if !p.consume("]") {
+			return nil, fmt.Errorf("only empty arrays are supported as values")
+		}
+		return []any{}, nil
+	case p.consume("{"):
+		if !p.consume("}") {
+			return nil, fmt.Errorf("only empty objects are supported as values")
+		}
+		return map[string]any{}, nil
+	}
+
+	switch keyword := p.parseIdentifier(); keyword {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected value: %s", keyword)
+	}
+}
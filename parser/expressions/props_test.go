@@ -0,0 +1,147 @@
+package expressions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProps(t *testing.T) {
+	status := NewUnionType([]ExpressionType{NewLiteralType("open"), NewLiteralType("closed")})
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []Prop
+		wantErr string
+	}{
+		{
+			name:  "empty",
+			input: " \n ",
+		},
+		{
+			name: "separated by commas and new lines",
+			input: `title: string, items: LineItem[]
+				count: int = 0,
+				status: "open" | "closed" = 'closed'
+				ratio: float = -0.5
+				nickname: string | null = null,
+				tags: map[string, string] = {}
+				ids: int[] = []
+				visible: bool = true,`,
+			want: []Prop{
+				{Name: "title", Type: NewPrimitiveType(ExpressionBaseTypeString)},
+				{Name: "items", Type: NewArrayType(NewNamedType("LineItem"))},
+				{Name: "count", Type: NewPrimitiveType(ExpressionBaseTypeInt), Default: int64(0), HasDefault: true},
+				{Name: "status", Type: status, Default: "closed", HasDefault: true},
+				{Name: "ratio", Type: NewPrimitiveType(ExpressionBaseTypeFloat), Default: -0.5, HasDefault: true},
+				{Name: "nickname", Type: NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true), Default: nil, HasDefault: true},
+				{Name: "tags", Type: NewMapType(NewPrimitiveType(ExpressionBaseTypeString), NewPrimitiveType(ExpressionBaseTypeString)), Default: map[string]any{}, HasDefault: true},
+				{Name: "ids", Type: NewArrayType(NewPrimitiveType(ExpressionBaseTypeInt)), Default: []any{}, HasDefault: true},
+				{Name: "visible", Type: NewPrimitiveType(ExpressionBaseTypeBool), Default: true, HasDefault: true},
+			},
+		},
+		{
+			name:  "int default of a float",
+			input: "price: float = 1",
+			want: []Prop{
+				{Name: "price", Type: NewPrimitiveType(ExpressionBaseTypeFloat), Default: int64(1), HasDefault: true},
+			},
+		},
+		{
+			name:  "date and decimal defaults",
+			input: `due: date = "2024-01-31", total: decimal = "0.00"`,
+			want: []Prop{
+				{Name: "due", Type: NewPrimitiveType(ExpressionBaseTypeDate), Default: "2024-01-31", HasDefault: true},
+				{Name: "total", Type: NewPrimitiveType(ExpressionBaseTypeDecimal), Default: "0.00", HasDefault: true},
+			},
+		},
+		{
+			name:    "decimal default that is a number",
+			input:   "total: decimal = 0",
+			wantErr: "default of prop total is not a decimal",
+		},
+		{
+			name:    "invalid date default",
+			input:   `due: date = "31/01/2024"`,
+			wantErr: "default of prop due is not a date",
+		},
+		{
+			name:    "duplicate prop",
+			input:   "title: string, title: int",
+			wantErr: "prop title is already declared",
+		},
+		{
+			name:    "missing type",
+			input:   "title",
+			wantErr: "expected : after prop title",
+		},
+		{
+			name:    "invalid type",
+			input:   "title: text",
+			wantErr: "invalid type of prop title",
+		},
+		{
+			name:    "default of another type",
+			input:   `count: int = "0"`,
+			wantErr: "default of prop count is not a int",
+		},
+		{
+			name:    "default that is not a member",
+			input:   `status: "open" | "closed" = "pending"`,
+			wantErr: `default of prop status is not a "open" | "closed"`,
+		},
+		{
+			name:    "null default of a required prop",
+			input:   "title: string = null",
+			wantErr: "default of prop title is not a string",
+		},
+		{
+			name:  "empty object default of an object with optional fields",
+			input: "filter: {query: string?, page: int?} = {}",
+			want: []Prop{
+				{Name: "filter", Type: NewObjectType([]ObjectField{
+					{Name: "query", Type: NewPrimitiveType(ExpressionBaseTypeString).WithOptional(true)},
+					{Name: "page", Type: NewPrimitiveType(ExpressionBaseTypeInt).WithOptional(true)},
+				}), Default: map[string]any{}, HasDefault: true},
+			},
+		},
+		{
+			name:    "empty object default of an object with a required field",
+			input:   "filter: {query: string, page: int?} = {}",
+			wantErr: "default of prop filter is not a",
+		},
+		{
+			name:    "declarations without a separator",
+			input:   "a: string b: int",
+			wantErr: "expected , or a new line after prop a",
+		},
+		{
+			name:    "non-empty array default",
+			input:   "ids: int[] = [1]",
+			wantErr: "only empty arrays are supported as values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseProps(tt.input)
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if !assert.Equal(t, len(tt.want), len(got)) {
+				return
+			}
+			for i, prop := range tt.want {
+				assert.Equal(t, prop.Name, got[i].Name)
+				assert.True(t, prop.Type.Equals(got[i].Type), "type of %s: expected %s, got %s", prop.Name, prop.Type, got[i].Type)
+				assert.Equal(t, prop.Default, got[i].Default)
+				assert.Equal(t, prop.HasDefault, got[i].HasDefault)
+			}
+		})
+	}
+}
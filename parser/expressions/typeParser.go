@@ -0,0 +1,230 @@
+package expressions
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// typeParser is a recursive descent parser for type syntax:
+//
+//	type    = postfix { "|" postfix }
+//	postfix = primary { "[]" | "?" } | "null"
+//	primary = "map" "[" type "," type "]" | object | string | "(" type ")" | identifier
+//	object  = "{" [ field { "," field } [ "," ] ] "}"
+//	field   = identifier ":" type
+type typeParser struct {
+	runes []rune
+	pos   int
+}
+
+func (p *typeParser) parse() (ExpressionType, error) {
+	t, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.runes) {
+		return nil, fmt.Errorf("unexpected %q in type", p.runes[p.pos])
+	}
+	return t, nil
+}
+
+// parseType parses a type or a union of types. Unions with null are
+// optional, e.g. string | null.
+func (p *typeParser) parseType() (ExpressionType, error) {
+	var members []ExpressionType
+	nullable := false
+	for {
+		if p.consumeKeyword("null") {
+			nullable = true
+		} else {
+			member, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member)
+		}
+
+		if !p.consume("|") {
+			break
+		}
+	}
+
+	if len(members) == 0 {
+		return nil, fmt.Errorf("null is not a type")
+	}
+	t := NewUnionType(members)
+	if nullable {
+		t = t.WithOptional(true)
+	}
+	return t, nil
+}
+
+func (p *typeParser) parsePostfix() (ExpressionType, error) {
+	t, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.consume("?"):
+			t = t.WithOptional(true)
+		case p.consume("["):
+			if !p.consume("]") {
+				return nil, fmt.Errorf("expected ] in array type")
+			}
+			t = NewArrayType(t)
+		default:
+			return t, nil
+		}
+	}
+}
+
+func (p *typeParser) parsePrimary() (ExpressionType, error) {
+	if p.consume("{") {
+		return p.parseObject()
+	}
+
+	if p.consume("(") {
+		t, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("expected ) in type")
+		}
+		return t, nil
+	}
+
+	if p.pos < len(p.runes) && (p.runes[p.pos] == '"' || p.runes[p.pos] == '\'') {
+		return p.parseString()
+	}
+
+	name := p.parseIdentifier()
+	if name == "" {
+		return nil, fmt.Errorf("expected type name")
+	}
+
+	if name == string(ExpressionBaseTypeMap) {
+		if !p.consume("[") {
+			return nil, fmt.Errorf("expected [ after map")
+		}
+		keyType, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(",") {
+			return nil, fmt.Errorf("expected , in map type")
+		}
+		valueType, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume("]") {
+			return nil, fmt.Errorf("expected ] in map type")
+		}
+		return NewMapType(keyType, valueType), nil
+	}
+
+	if unicode.IsUpper([]rune(name)[0]) {
+		return NewNamedType(name), nil
+	}
+
+	baseType, ok := ParseExpressionBaseType(name)
+	if !ok || baseType == ExpressionBaseTypeArray {
+		return nil, fmt.Errorf("unknown type: %s", name)
+	}
+	return NewPrimitiveType(baseType), nil
+}
+
+func (p *typeParser) parseObject() (ExpressionType, error) {
+	var fields []ObjectField
+	for !p.consume("}") {
+		name := p.parseIdentifier()
+		if name == "" {
+			return nil, fmt.Errorf("expected field name in object type")
+		}
+		for _, field := range fields {
+			if field.Name == name {
+				return nil, fmt.Errorf("duplicate field %s in object type", name)
+			}
+		}
+		if !p.consume(":") {
+			return nil, fmt.Errorf("expected : after field %s", name)
+		}
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, ObjectField{Name: name, Type: typ})
+
+		if !p.consume(",") {
+			if !p.consume("}") {
+				return nil, fmt.Errorf("expected , or } in object type")
+			}
+			break
+		}
+	}
+	return NewObjectType(fields), nil
+}
+
+func (p *typeParser) parseString() (ExpressionType, error) {
+	quote := p.runes[p.pos]
+	start := p.pos
+	for p.pos++; p.pos < len(p.runes); p.pos++ {
+		switch p.runes[p.pos] {
+		case '\\':
+			p.pos++
+		case quote:
+			p.pos++
+			value, ok := StringLiteral(string(p.runes[start:p.pos]))
+			if !ok {
+				return nil, fmt.Errorf("invalid string literal type: %s", string(p.runes[start:p.pos]))
+			}
+			return NewLiteralType(value), nil
+		}
+	}
+	return nil, fmt.Errorf("unterminated string literal type")
+}
+
+func (p *typeParser) parseIdentifier() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.runes) {
+		r := p.runes[p.pos]
+		if r != '_' && !unicode.IsLetter(r) && !(p.pos > start && unicode.IsDigit(r)) {
+			break
+		}
+		p.pos++
+	}
+	return string(p.runes[start:p.pos])
+}
+
+// consumeKeyword skips whitespace and the identifier keyword, reporting
+// whether it was found.
+func (p *typeParser) consumeKeyword(keyword string) bool {
+	pos := p.pos
+	if p.parseIdentifier() == keyword {
+		return true
+	}
+	p.pos = pos
+	return false
+}
+
+// consume skips whitespace and the token s, reporting whether s was found.
+func (p *typeParser) consume(s string) bool {
+	p.skipSpace()
+	token := []rune(s)
+	if p.pos+len(token) > len(p.runes) || string(p.runes[p.pos:p.pos+len(token)]) != s {
+		return false
+	}
+	p.pos += len(token)
+	return true
+}
+
+func (p *typeParser) skipSpace() {
+	for p.pos < len(p.runes) && unicode.IsSpace(p.runes[p.pos]) {
+		p.pos++
+	}
+}
@@ -0,0 +1,428 @@
+// Package gomodel loads Go struct types as template models, so a template can
+// declare its props with {props from "example.com/app/views.OrderPage"}.
+package gomodel
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"guts/parser/expressions"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Model is a Go struct type loaded as the model of a template.
+type Model struct {
+	// Props are the fields of the struct as they are encoded to JSON.
+	Props []expressions.Prop
+	// NamedTypes are the struct types referenced by the fields, by name.
+	NamedTypes map[string]expressions.ExpressionType
+}
+
+// Load loads the struct type ref, e.g. "example.com/app/views.OrderPage", from
+// the module containing dir. Relative package paths such as "./views.Page"
+// are resolved against dir.
+func Load(dir, ref string) (*Model, error) {
+	pkgPath, name, ok := splitRef(ref)
+	if !ok {
+		return nil, fmt.Errorf("invalid Go type reference: %s", ref)
+	}
+
+	l, err := newLoader(dir)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(pkgPath, ".") {
+		pkgPath, err = l.importPath(filepath.Join(dir, pkgPath))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pkg, err := l.Import(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+	if !ok {
+		return nil, fmt.Errorf("type %s is not declared in package %s", name, pkgPath)
+	}
+	st, ok := obj.Type().Underlying().(*types.Struct)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct type", ref)
+	}
+
+	c := &converter{named: map[string]expressions.ExpressionType{}, origins: map[string]string{}}
+	fields, err := c.fields(st)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	model := &Model{NamedTypes: c.named}
+	for _, field := range fields {
+		model.Props = append(model.Props, expressions.Prop{Name: field.Name, Type: field.Type})
+	}
+	return model, nil
+}
+
+// splitRef splits "example.com/app/views.OrderPage" into the package path and
+// the type name.
+func splitRef(ref string) (string, string, bool) {
+	slash := strings.LastIndex(ref, "/")
+	dot := strings.LastIndex(ref, ".")
+	if dot <= slash+1 || dot == len(ref)-1 {
+		return "", "", false
+	}
+	return ref[:dot], ref[dot+1:], token.IsIdentifier(ref[dot+1:])
+}
+
+// loader type checks the packages of a module from source. Packages outside
+// the module are imported with the source importer of the go command.
+type loader struct {
+	fset     *token.FileSet
+	module   string
+	root     string
+	packages map[string]*types.Package
+	fallback types.Importer
+}
+
+func newLoader(dir string) (*loader, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		module, err := modulePath(filepath.Join(root, "go.mod"))
+		if err == nil {
+			fset := token.NewFileSet()
+			return &loader{
+				fset:     fset,
+				module:   module,
+				root:     root,
+				packages: map[string]*types.Package{},
+				fallback: importer.ForCompiler(fset, "source", nil),
+			}, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return nil, fmt.Errorf("no go.mod found in %s or any parent directory", dir)
+		}
+		root = parent
+	}
+}
+
+// modulePath reads the module path declared in a go.mod file.
+func modulePath(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s does not declare a module path", file)
+}
+
+// importPath returns the import path of a directory of the module.
+func (l *loader) importPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(l.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in module %s", dir, l.module)
+	}
+	return path.Join(l.module, filepath.ToSlash(rel)), nil
+}
+
+func (l *loader) Import(pkgPath string) (*types.Package, error) {
+	if pkg, ok := l.packages[pkgPath]; ok {
+		return pkg, nil
+	}
+	rel, ok := strings.CutPrefix(pkgPath, l.module)
+	if !ok || (rel != "" && rel[0] != '/') {
+		return l.fallback.Import(pkgPath)
+	}
+
+	dir := filepath.Join(l.root, filepath.FromSlash(rel))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("package %s: %w", pkgPath, err)
+	}
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if match, err := build.Default.MatchFile(dir, name); err != nil || !match {
+			continue
+		}
+		file, err := parser.ParseFile(l.fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("package %s has no Go files", pkgPath)
+	}
+
+	conf := types.Config{Importer: l}
+	pkg, err := conf.Check(pkgPath, l.fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	l.packages[pkgPath] = pkg
+	return pkg, nil
+}
+
+// converter maps Go types to expression types the way encoding/json encodes
+// them.
+type converter struct {
+	named map[string]expressions.ExpressionType
+	// origins are the qualified names of the named types, to detect two
+	// structs with the same name in different packages.
+	origins map[string]string
+}
+
+func (c *converter) convert(t types.Type) (expressions.ExpressionType, error) {
+	switch t := types.Unalias(t).(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Time" {
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDateTime), nil
+		}
+		if isDecimal(obj) {
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDecimal), nil
+		}
+		if hasMethod(t, "MarshalJSON") {
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown), nil
+		}
+		if hasMethod(t, "MarshalText") {
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), nil
+		}
+		st, ok := t.Underlying().(*types.Struct)
+		if !ok {
+			return c.convert(t.Underlying())
+		}
+		return c.namedStruct(obj, st)
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsBoolean != 0:
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeBool), nil
+		case t.Info()&types.IsInteger != 0:
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt), nil
+		case t.Info()&types.IsFloat != 0:
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeFloat), nil
+		case t.Info()&types.IsString != 0:
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), nil
+		}
+	case *types.Pointer:
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return elem.WithOptional(true), nil
+	case *types.Slice:
+		// a nil slice is encoded as null
+		if basic, ok := t.Elem().Underlying().(*types.Basic); ok && basic.Kind() == types.Byte {
+			// encoded as a base64 string
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString).WithOptional(true), nil
+		}
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return expressions.NewArrayType(elem).WithOptional(true), nil
+	case *types.Array:
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return expressions.NewArrayType(elem), nil
+	case *types.Map:
+		key, err := c.convert(t.Key())
+		if err != nil {
+			return nil, err
+		}
+		if key.BaseType() != expressions.ExpressionBaseTypeString && key.BaseType() != expressions.ExpressionBaseTypeInt {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		// a nil map is encoded as null
+		return expressions.NewMapType(key, elem).WithOptional(true), nil
+	case *types.Struct:
+		fields, err := c.fields(t)
+		if err != nil {
+			return nil, err
+		}
+		return expressions.NewObjectType(fields), nil
+	case *types.Interface:
+		return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// namedStruct declares a struct type by its name and returns a reference to it.
+func (c *converter) namedStruct(obj *types.TypeName, st *types.Struct) (expressions.ExpressionType, error) {
+	name := obj.Name()
+	qualified := obj.Pkg().Path() + "." + name
+	if origin, ok := c.origins[name]; ok {
+		if origin != qualified {
+			return nil, fmt.Errorf("type %s is declared in both %s and %s", name, origin, qualified)
+		}
+		return expressions.NewNamedType(name), nil
+	}
+
+	// register the name first so recursive types refer to themselves
+	c.origins[name] = qualified
+	fields, err := c.fields(st)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	c.named[name] = expressions.NewObjectType(fields)
+	return expressions.NewNamedType(name), nil
+}
+
+// fields returns the JSON fields of a struct. Fields of embedded structs
+// without a json name are promoted, unless the outer struct declares a field
+// with the same name.
+func (c *converter) fields(st *types.Struct) ([]expressions.ObjectField, error) {
+	var fields, promoted []expressions.ObjectField
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Embedded() && name == "" {
+			embedded := field.Type()
+			optional := false
+			if ptr, ok := types.Unalias(embedded).(*types.Pointer); ok {
+				embedded, optional = ptr.Elem(), true
+			}
+			if st, ok := embedded.Underlying().(*types.Struct); ok {
+				inner, err := c.fields(st)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range inner {
+					if optional {
+						f.Type = f.Type.WithOptional(true)
+					}
+					promoted = append(promoted, f)
+				}
+				continue
+			}
+		}
+		if !field.Exported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name()
+		}
+
+		typ, err := c.convert(field.Type())
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name(), err)
+		}
+		if hasOption(options, "string") {
+			switch typ.BaseType() {
+			case expressions.ExpressionBaseTypeInt, expressions.ExpressionBaseTypeFloat, expressions.ExpressionBaseTypeBool:
+				typ = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString).WithOptional(typ.Optional())
+			}
+		}
+		if hasOption(options, "omitempty") || hasOption(options, "omitzero") {
+			typ = typ.WithOptional(true)
+		}
+		fields = append(fields, expressions.ObjectField{Name: name, Type: typ})
+	}
+
+	for _, f := range promoted {
+		if !containsField(fields, f.Name) {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func containsField(fields []expressions.ObjectField, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decimalTypes are the types of known decimal packages, which encode their
+// values as strings, by package path without a major version suffix.
+var decimalTypes = map[string]string{
+	"github.com/shopspring/decimal":    "Decimal",
+	"github.com/cockroachdb/apd":       "Decimal",
+	"github.com/ericlagergren/decimal": "Big",
+	"github.com/govalues/decimal":      "Decimal",
+}
+
+// isDecimal reports whether obj is the type of a known decimal package.
+func isDecimal(obj *types.TypeName) bool {
+	if obj.Pkg() == nil {
+		return false
+	}
+	pkgPath := obj.Pkg().Path()
+	if version, ok := strings.CutPrefix(path.Base(pkgPath), "v"); ok && isDigits(version) {
+		pkgPath = path.Dir(pkgPath)
+	}
+	return decimalTypes[pkgPath] == obj.Name()
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+func hasMethod(t types.Type, name string) bool {
+	for _, typ := range []types.Type{t, types.NewPointer(t)} {
+		methods := types.NewMethodSet(typ)
+		for i := 0; i < methods.Len(); i++ {
+			if methods.At(i).Obj().Name() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package gomodel
+
+import (
+	"go/token"
+	"go/types"
+	"guts/parser/expressions"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_stringType = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)
+	_intType    = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)
+	_boolType   = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeBool)
+)
+
+// writeModule writes the files of a module to a temporary directory.
+func writeModule(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		"views/order.go": `package views
+
+import (
+	"time"
+
+	"example.com/app/money"
+)
+
+type Status string
+
+type OrderPage struct {
+	Title    string            ` + "`json:\"title\"`" + `
+	Customer *Customer         ` + "`json:\"customer\"`" + `
+	Lines    []LineItem        ` + "`json:\"lines\"`" + `
+	Totals   [2]int            ` + "`json:\"totals\"`" + `
+	Tags     map[string][]byte ` + "`json:\"tags\"`" + `
+	Notes    map[string]string ` + "`json:\"notes,omitempty\"`" + `
+	Status   Status            ` + "`json:\"status\"`" + `
+	Placed   time.Time         ` + "`json:\"placed\"`" + `
+	Count    int64             ` + "`json:\"count,string\"`" + `
+	Extra    any
+	Secret   string ` + "`json:\"-\"`" + `
+	internal bool
+	Audit
+}
+
+type Audit struct {
+	CreatedBy string ` + "`json:\"createdBy\"`" + `
+	Title     string ` + "`json:\"title\"`" + `
+}
+
+type Customer struct {
+	Name     string    ` + "`json:\"name\"`" + `
+	Referrer *Customer ` + "`json:\"referrer\"`" + `
+}
+
+type LineItem struct {
+	SKU   string      ` + "`json:\"sku\"`" + `
+	Qty   int         ` + "`json:\"qty\"`" + `
+	Price money.Price ` + "`json:\"price\"`" + `
+	Gift  bool        ` + "`json:\"gift\"`" + `
+}
+`,
+		"views/order_test.go": "package views\n\nfunc broken() { undefined() }\n",
+		"money/money.go": `package money
+
+type Price struct {
+	Amount   Decimal ` + "`json:\"amount\"`" + `
+	Currency string  ` + "`json:\"currency\"`" + `
+}
+
+type Decimal struct {
+	value string
+}
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.value), nil
+}
+`,
+	})
+
+	model, err := Load(filepath.Join(dir, "templates"), "example.com/app/views.OrderPage")
+	assert.NoError(t, err)
+	if model == nil {
+		return
+	}
+
+	expectedProps := []expressions.ObjectField{
+		{Name: "title", Type: _stringType},
+		{Name: "customer", Type: expressions.NewNamedType("Customer").WithOptional(true)},
+		{Name: "lines", Type: expressions.NewArrayType(expressions.NewNamedType("LineItem")).WithOptional(true)},
+		{Name: "totals", Type: expressions.NewArrayType(_intType)},
+		{Name: "tags", Type: expressions.NewMapType(_stringType, _stringType.WithOptional(true)).WithOptional(true)},
+		{Name: "notes", Type: expressions.NewMapType(_stringType, _stringType).WithOptional(true)},
+		{Name: "status", Type: _stringType},
+		{Name: "placed", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDateTime)},
+		{Name: "count", Type: _stringType},
+		{Name: "Extra", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)},
+		{Name: "createdBy", Type: _stringType},
+	}
+	if assert.Equal(t, len(expectedProps), len(model.Props)) {
+		for i, expected := range expectedProps {
+			assert.Equal(t, expected.Name, model.Props[i].Name)
+			assert.True(t, expected.Type.Equals(model.Props[i].Type),
+				"type mismatch for %s: expected %s, got %s", expected.Name, expected.Type, model.Props[i].Type)
+		}
+	}
+
+	expectedTypes := map[string]expressions.ExpressionType{
+		"Customer": expressions.NewObjectType([]expressions.ObjectField{
+			{Name: "name", Type: _stringType},
+			{Name: "referrer", Type: expressions.NewNamedType("Customer").WithOptional(true)},
+		}),
+		"LineItem": expressions.NewObjectType([]expressions.ObjectField{
+			{Name: "sku", Type: _stringType},
+			{Name: "qty", Type: _intType},
+			{Name: "price", Type: expressions.NewNamedType("Price")},
+			{Name: "gift", Type: _boolType},
+		}),
+		"Price": expressions.NewObjectType([]expressions.ObjectField{
+			// a Decimal of an unknown package is only a text marshaler
+			{Name: "amount", Type: _stringType},
+			{Name: "currency", Type: _stringType},
+		}),
+	}
+	assert.Len(t, model.NamedTypes, len(expectedTypes))
+	for name, expected := range expectedTypes {
+		actual, ok := model.NamedTypes[name]
+		if assert.True(t, ok, "type %s should exist", name) {
+			assert.True(t, expected.Equals(actual), "type mismatch for %s: expected %s, got %s", name, expected, actual)
+		}
+	}
+
+	relative, err := Load(filepath.Join(dir, "templates"), "../views.Customer")
+	assert.NoError(t, err)
+	if relative != nil {
+		assert.Len(t, relative.Props, 2)
+	}
+}
+
+func TestIsDecimal(t *testing.T) {
+	tests := []struct {
+		pkgPath  string
+		name     string
+		expected bool
+	}{
+		{pkgPath: "github.com/shopspring/decimal", name: "Decimal", expected: true},
+		{pkgPath: "github.com/cockroachdb/apd/v3", name: "Decimal", expected: true},
+		{pkgPath: "github.com/ericlagergren/decimal", name: "Big", expected: true},
+		{pkgPath: "github.com/shopspring/decimal", name: "NullDecimal"},
+		{pkgPath: "example.com/app/money", name: "Decimal"},
+		{pkgPath: "example.com/app/v2", name: "Decimal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pkgPath+"."+tt.name, func(t *testing.T) {
+			obj := types.NewTypeName(token.NoPos, types.NewPackage(tt.pkgPath, path.Base(tt.pkgPath)), tt.name, nil)
+			assert.Equal(t, tt.expected, isDecimal(obj))
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module example.com/app\n",
+		"views/views.go": `package views
+
+type Page struct {
+	Title string
+}
+
+type Title string
+
+type Events struct {
+	Handlers map[string]func()
+}
+
+type Grid struct {
+	Cells map[[2]int]string
+}
+`,
+		"broken/broken.go": "package broken\n\ntype Page struct {\n\tTitle Missing\n}\n",
+		"a/a.go":           "package a\n\ntype Item struct{ ID int }\n",
+		"b/b.go":           "package b\n\nimport \"example.com/app/a\"\n\ntype Item struct{ ID string }\n\ntype Page struct {\n\tA a.Item\n\tB Item\n}\n",
+	})
+
+	tests := []struct {
+		name    string
+		dir     string
+		ref     string
+		message string
+	}{
+		{name: "invalid reference", dir: dir, ref: "views", message: "invalid Go type reference: views"},
+		{name: "missing type", dir: dir, ref: "example.com/app/views.Order", message: "type Order is not declared in package example.com/app/views"},
+		{name: "not a struct", dir: dir, ref: "example.com/app/views.Title", message: "example.com/app/views.Title is not a struct type"},
+		{name: "missing package", dir: dir, ref: "example.com/app/pages.Page", message: "package example.com/app/pages"},
+		{name: "type errors", dir: dir, ref: "example.com/app/broken.Page", message: "undefined: Missing"},
+		{name: "unsupported field", dir: dir, ref: "example.com/app/views.Events", message: "field Handlers: unsupported type func()"},
+		{name: "unsupported map key", dir: dir, ref: "example.com/app/views.Grid", message: "unsupported map key type [2]int"},
+		{name: "types with the same name", dir: dir, ref: "example.com/app/b.Page", message: "type Item is declared in both example.com/app/a.Item and example.com/app/b.Item"},
+		{name: "outside a module", dir: os.TempDir(), ref: "example.com/app/views.Page", message: "no go.mod found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.dir, tt.ref)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.message)
+			}
+		})
+	}
+}
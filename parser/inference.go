@@ -0,0 +1,348 @@
+package parser
+
+import (
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"guts/parser/nodes/attributes"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// typeHint is a type suggested by a single usage of a variable.
+// Fallback hints are only used when no usage says anything more specific.
+type typeHint struct {
+	typ      expressions.ExpressionType
+	fallback bool
+}
+
+type inference struct {
+	document      nodes.Document
+	names         []string
+	hints         map[string][]typeHint
+	optional      map[string]bool
+	loopVariables []string
+}
+
+var (
+	_stringType  = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)
+	_intType     = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)
+	_floatType   = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeFloat)
+	_boolType    = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeBool)
+	_unknownType = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)
+)
+
+// inferTypes collects every free variable referenced by the document that has
+// no type annotation, infers a type for it from how it is used and adds the
+// result to the document's declared types.
+func inferTypes(document nodes.Document) {
+	inf := &inference{
+		document: document,
+		hints:    make(map[string][]typeHint),
+		optional: make(map[string]bool),
+	}
+	inf.walk(document.Children(), newScope(nil))
+	inf.apply()
+
+	declared := document.GetDeclaredTypes()
+	for _, name := range inf.loopVariables {
+		if _, ok := declared[name]; ok {
+			document.AddWarning(name + ": loop variable shadows model field " + name)
+		}
+	}
+}
+
+func (inf *inference) walk(children []nodes.Node, bound *scope) {
+	for _, child := range children {
+		switch n := child.(type) {
+		case nodes.OutputBlock:
+			if n.Expression() != nil {
+				inf.output(n.Expression(), bound)
+			} else {
+				inf.use(n.Key(), bound, &typeHint{typ: _stringType, fallback: true})
+			}
+		case nodes.Element:
+			inf.attributes(n.Attributes(), bound)
+			inf.walk(n.Children(), bound)
+		case nodes.ConditionalBlock:
+			var block nodes.ConditionalBlock = n
+			for block != nil {
+				if block.Condition() != nil {
+					inf.condition(block.Condition(), bound, true)
+				}
+				inf.walk(block.Children(), bound)
+				block = block.Next()
+			}
+		case nodes.LoopBlock:
+			arrayType := expressions.NewArrayType(_unknownType)
+			inf.use(n.ItemsKey(), bound, &typeHint{typ: arrayType, fallback: true})
+
+			collection := n.ExpressionType()
+			if collection == nil {
+				collection = bound.lookupType(n.ItemsKey(), inf.document)
+			}
+			for _, name := range []string{n.IndexKey(), n.ValueKey()} {
+				if _, ok := bound.lookup(name); ok {
+					inf.document.AddWarning(name + ": loop variable shadows loop variable " + name + " of an enclosing loop")
+				}
+				inf.loopVariables = append(inf.loopVariables, name)
+			}
+			inf.walk(n.Children(), bound.enterLoop(n.IndexKey(), n.ValueKey(), collection))
+		default:
+			inf.walk(n.Children(), bound)
+		}
+	}
+}
+
+func (inf *inference) attributes(attrs attributes.Attributes, bound *scope) {
+	attrs.Iterator()(func(key string, value attributes.AttributeValue) bool {
+		switch v := value.(type) {
+		case attributes.AttributeValueExpression:
+			inf.attributeExpression(v.Key(), bound)
+		case attributes.AttributeValueComposite:
+			for _, part := range v.Values() {
+				if expr, ok := part.(attributes.AttributeValueExpression); ok {
+					inf.attributeExpression(expr.Key(), bound)
+				}
+			}
+		}
+		return true
+	})
+
+	spread := attrs.GetSpreadAttribute()
+	if spread != nil && !spread.IsEmpty() {
+		mapType := expressions.NewMapType(_stringType, _stringType)
+		inf.use(spread.Key(), bound, &typeHint{typ: mapType})
+	}
+}
+
+// attributeExpression records the usages in an attribute expression, which is
+// output like an output block.
+func (inf *inference) attributeExpression(key string, bound *scope) {
+	expr, err := expressions.NewBooleanExpression(key)
+	if err != nil {
+		inf.use(key, bound, &typeHint{typ: _stringType, fallback: true})
+		return
+	}
+	inf.output(expr, bound)
+}
+
+// output records the usages in a computed output expression.
+func (inf *inference) output(expr expressions.BooleanExpression, bound *scope) {
+	expr = expressions.Ungroup(expr)
+	switch {
+	case expr.Literal() != "":
+		inf.use(expr.Literal(), bound, &typeHint{typ: _stringType, fallback: true})
+	case expr.Operator() == expressions.Coalesce:
+		inf.markOptional(expr.Left(), bound)
+		inf.output(expr.Left(), bound)
+		inf.output(expr.Right(), bound)
+	default:
+		inf.condition(expr, bound, false)
+	}
+}
+
+// markOptional records that the variable in expr may be null, because it is
+// the left operand of ??.
+func (inf *inference) markOptional(expr expressions.BooleanExpression, bound *scope) {
+	name, member := rootIdentifier(expressions.Ungroup(expr).Literal())
+	if member || !isIdentifier(name) {
+		return
+	}
+	if _, ok := bound.lookup(name); !ok {
+		inf.optional[name] = true
+	}
+}
+
+func (inf *inference) condition(expr expressions.BooleanExpression, bound *scope, boolContext bool) {
+	expr = expressions.Ungroup(expr)
+	if expr.Literal() != "" {
+		if boolContext {
+			inf.use(expr.Literal(), bound, &typeHint{typ: _boolType})
+		} else {
+			inf.use(expr.Literal(), bound, nil)
+		}
+		return
+	}
+
+	switch expr.Operator() {
+	case expressions.LogicalNot:
+		inf.condition(expr.Right(), bound, true)
+	case expressions.LogicalAnd, expressions.LogicalOr:
+		inf.condition(expr.Left(), bound, true)
+		inf.condition(expr.Right(), bound, true)
+	case expressions.Coalesce:
+		inf.markOptional(expr.Left(), bound)
+		inf.comparisonOperand(expr.Left(), expr.Right(), bound)
+		inf.comparisonOperand(expr.Right(), expr.Left(), bound)
+	default:
+		inf.comparisonOperand(expr.Left(), expr.Right(), bound)
+		inf.comparisonOperand(expr.Right(), expr.Left(), bound)
+	}
+}
+
+// comparisonOperand records a usage of operand, which is compared to other.
+func (inf *inference) comparisonOperand(operand, other expressions.BooleanExpression, bound *scope) {
+	operand = expressions.Ungroup(operand)
+	if operand.Literal() == "" {
+		inf.condition(operand, bound, false)
+		return
+	}
+
+	if typ := inf.operandType(other, bound); typ != nil {
+		inf.use(operand.Literal(), bound, &typeHint{typ: typ})
+	} else {
+		inf.use(operand.Literal(), bound, nil)
+	}
+}
+
+// operandType returns the type of a literal or already typed variable, or nil.
+func (inf *inference) operandType(expr expressions.BooleanExpression, bound *scope) expressions.ExpressionType {
+	expr = expressions.Ungroup(expr)
+	literal := expr.Literal()
+	switch {
+	case literal == "":
+		return nil
+	case expr.ExpressionType() != nil:
+		return expr.ExpressionType()
+	case literal == "true" || literal == "false":
+		return _boolType
+	case strings.HasPrefix(literal, "\"") || strings.HasPrefix(literal, "'"):
+		return _stringType
+	}
+
+	if _, err := strconv.Atoi(literal); err == nil {
+		return _intType
+	}
+	if _, err := strconv.ParseFloat(literal, 64); err == nil {
+		return _floatType
+	}
+	return bound.lookupType(literal, inf.document)
+}
+
+// use records a usage of key. A nil hint still registers the variable.
+func (inf *inference) use(key string, bound *scope, hint *typeHint) {
+	name, member := rootIdentifier(key)
+	if !isIdentifier(name) {
+		return
+	}
+	if _, ok := bound.lookup(name); ok {
+		return
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(key)[len(name):], "?.") {
+		inf.optional[name] = true
+	}
+
+	if member {
+		// the variable is accessed as an object; nothing is known about its fields
+		mapType := expressions.NewMapType(_stringType, _unknownType)
+		hint = &typeHint{typ: mapType}
+	}
+
+	if _, ok := inf.hints[name]; !ok {
+		inf.names = append(inf.names, name)
+		inf.hints[name] = nil
+	}
+	if hint != nil {
+		inf.hints[name] = append(inf.hints[name], *hint)
+	}
+}
+
+func (inf *inference) apply() {
+	if inf.document.Strict() {
+		// the props block declares every input
+		return
+	}
+	declared := inf.document.GetDeclaredTypes()
+
+	for _, name := range inf.names {
+		if _, ok := declared[name]; ok {
+			continue
+		}
+
+		var strong, fallback []expressions.ExpressionType
+		for _, hint := range inf.hints[name] {
+			if hint.fallback {
+				fallback = append(fallback, hint.typ)
+			} else {
+				strong = append(strong, hint.typ)
+			}
+		}
+
+		var typ expressions.ExpressionType
+		switch {
+		case len(strong) > 0:
+			var ok bool
+			typ, ok = unifyTypes(strong)
+			if !ok {
+				inf.document.AddWarning(name + ": conflicting usages, falling back to unknown")
+				typ = _unknownType
+			}
+		case len(fallback) > 0:
+			typ = fallback[0]
+			for _, t := range fallback {
+				if !t.Equals(_stringType) {
+					typ = t
+					break
+				}
+			}
+			inf.document.AddWarning(name + ": type could not be inferred from usage, falling back to " + typ.String())
+		default:
+			typ = _unknownType
+			inf.document.AddWarning(name + ": type could not be inferred from usage, falling back to unknown")
+		}
+
+		// cannot conflict, the name is not declared yet
+		inf.document.AddDeclaredType(name, typ, nodes.Position{})
+	}
+
+	for _, name := range inf.names {
+		if inf.optional[name] {
+			// cannot conflict, only the optionality differs
+			inf.document.AddDeclaredType(name, declared[name].WithOptional(true), nodes.Position{})
+		}
+	}
+}
+
+// unifyTypes returns the single type satisfying all usages. int widens to float.
+func unifyTypes(types []expressions.ExpressionType) (expressions.ExpressionType, bool) {
+	result := types[0]
+	for _, t := range types[1:] {
+		switch {
+		case result.Equals(t):
+		case isNumeric(result) && isNumeric(t):
+			result = _floatType
+		default:
+			return nil, false
+		}
+	}
+	return result, true
+}
+
+func isNumeric(t expressions.ExpressionType) bool {
+	return t.BaseType() == expressions.ExpressionBaseTypeInt || t.BaseType() == expressions.ExpressionBaseTypeFloat
+}
+
+// rootIdentifier returns the variable name at the start of a member access path
+// such as item.name or user?.name, and whether the key accessed a member of it.
+func rootIdentifier(key string) (string, bool) {
+	key = strings.TrimSpace(key)
+	if i := strings.IndexAny(key, ".[?"); i >= 0 {
+		return key[:i], true
+	}
+	return key, false
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || s == "true" || s == "false" || s == "null" {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"guts/parser/expressions"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		types    map[string]expressions.ExpressionType
+		warnings []string
+	}{
+		{
+			name: "comparison with int literal",
+			html: `{if qty > 1}You have {qty} items.{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"qty": _intType,
+			},
+		}, {
+			name: "int and float usages widen to float",
+			html: `{if price > 1}expensive{else if price < 0.5}cheap{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"price": _floatType,
+			},
+		}, {
+			name: "comparison with annotated variable",
+			html: `{if count == limit: int}full{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"count": _intType,
+				"limit": _intType,
+			},
+		}, {
+			name: "logical operands are bools",
+			html: `{if visible && !disabled}shown{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"visible":  _boolType,
+				"disabled": _boolType,
+			},
+		}, {
+			name: "spread is a string map",
+			html: `<img {...attrs}>`,
+			types: map[string]expressions.ExpressionType{
+				"attrs": expressions.NewMapType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+			},
+		}, {
+			name: "member access is an object",
+			html: `<p>{user.name}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"user": expressions.NewMapType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)),
+			},
+		}, {
+			name: "left operand of nullish coalescing is optional",
+			html: `<p>{nickname ?? name: string}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"nickname": _stringType.WithOptional(true),
+				"name":     _stringType,
+			},
+			warnings: []string{"nickname: type could not be inferred from usage, falling back to string"},
+		}, {
+			name: "nullish coalescing in a condition",
+			html: `{if (count ?? 0) > 1}many{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"count": _intType.WithOptional(true),
+			},
+		}, {
+			name: "optional chaining makes the root optional",
+			html: `<p>{user?.address?.city}</p><p title={user.name}></p>`,
+			types: map[string]expressions.ExpressionType{
+				"user": expressions.NewMapType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)).WithOptional(true),
+			},
+		}, {
+			name: "annotated field used with nullish coalescing",
+			html: `<p>{nickname: string}</p><p>{nickname ?? "anonymous"}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"nickname": _stringType.WithOptional(true),
+			},
+		}, {
+			name: "output falls back to string",
+			html: `<p>{name}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"name": _stringType,
+			},
+			warnings: []string{"name: type could not be inferred from usage, falling back to string"},
+		}, {
+			name: "attribute expressions fall back to string",
+			html: `<a href={url} title="Go to {title}">link</a>`,
+			types: map[string]expressions.ExpressionType{
+				"url":   _stringType,
+				"title": _stringType,
+			},
+			warnings: []string{
+				"url: type could not be inferred from usage, falling back to string",
+				"title: type could not be inferred from usage, falling back to string",
+			},
+		}, {
+			name: "loop collection falls back to unknown array",
+			html: `<ul>{for i, item in items}<li data-index={i}>{item}</li>{/for}</ul>`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)),
+			},
+			warnings: []string{"items: type could not be inferred from usage, falling back to unknown[]"},
+		}, {
+			name: "annotation elsewhere wins",
+			html: `<p>{name}</p><p>{name: string}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"name": _stringType,
+			},
+		}, {
+			name: "comparison between free variables",
+			html: `{if a == b}same{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"a": _unknownType,
+				"b": _unknownType,
+			},
+			warnings: []string{
+				"a: type could not be inferred from usage, falling back to unknown",
+				"b: type could not be inferred from usage, falling back to unknown",
+			},
+		}, {
+			name: "conflicting usages",
+			html: `{if flag == "yes"}yes{/if}{if flag > 1}more{/if}`,
+			types: map[string]expressions.ExpressionType{
+				"flag": _unknownType,
+			},
+			warnings: []string{"flag: conflicting usages, falling back to unknown"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := Parse(strings.NewReader(tt.html))
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+
+			declaredTypes := document.GetDeclaredTypes()
+			assert.Equal(t, len(tt.types), len(declaredTypes), "number of declared types mismatch")
+			for key, expectedType := range tt.types {
+				actualType, exists := declaredTypes[key]
+				assert.True(t, exists, "type for %s should exist", key)
+				if exists {
+					assert.True(t, expectedType.Equals(actualType),
+						"type mismatch for %s: expected %s, got %s",
+						key, expectedType.String(), actualType.String())
+				}
+			}
+			assert.Equal(t, tt.warnings, document.Warnings())
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"guts/parser/expressions"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Model is the model of a template described by a schema.
+type Model struct {
+	// Props are the properties of the root schema, sorted by name.
+	Props []expressions.Prop
+	// NamedTypes are the object definitions referenced by the props.
+	NamedTypes map[string]expressions.ExpressionType
+}
+
+// Load reads a schema file and returns the model it describes.
+func Load(file string) (*Model, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse returns the model described by a schema. The root schema must be an
+// object; properties that are not required are optional unless they have a
+// default.
+func Parse(data []byte) (*Model, error) {
+	var schema Schema
+	err := json.Unmarshal(data, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if schema.Properties == nil && !schema.is("object") {
+		return nil, fmt.Errorf("the root schema must be an object")
+	}
+
+	c := &converter{
+		defs:  map[string]*Schema{},
+		named: map[string]expressions.ExpressionType{},
+	}
+	for name, def := range schema.Definitions {
+		c.defs["#/definitions/"+name] = def
+	}
+	for name, def := range schema.Defs {
+		c.defs["#/$defs/"+name] = def
+	}
+
+	model := &Model{NamedTypes: c.named}
+	for _, name := range sortedKeys(schema.Properties) {
+		property := schema.Properties[name]
+		typ, err := c.convert(property)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", name, err)
+		}
+
+		prop := expressions.Prop{Name: name, Type: typ}
+		if property.Default != nil {
+			prop.Default, err = defaultValue(property.Default)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %w", name, err)
+			}
+			if !expressions.AcceptsValue(typ, prop.Default) {
+				return nil, fmt.Errorf("property %s: default is not a %s", name, typ.String())
+			}
+			prop.HasDefault = true
+		} else if !contains(schema.Required, name) {
+			prop.Type = typ.WithOptional(true)
+		}
+		model.Props = append(model.Props, prop)
+	}
+	return model, nil
+}
+
+// defaultValue converts a default to the values supported by props.
+func defaultValue(data json.RawMessage) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case []any:
+		if len(v) > 0 {
+			return nil, fmt.Errorf("only empty arrays are supported as defaults")
+		}
+	case map[string]any:
+		if len(v) > 0 {
+			return nil, fmt.Errorf("only empty objects are supported as defaults")
+		}
+	}
+	return value, nil
+}
+
+type converter struct {
+	defs  map[string]*Schema
+	named map[string]expressions.ExpressionType
+}
+
+func (c *converter) convert(schema *Schema) (expressions.ExpressionType, error) {
+	if schema.Ref != "" {
+		return c.ref(schema.Ref)
+	}
+	if schema.Const != nil {
+		return expressions.NewLiteralType(*schema.Const), nil
+	}
+	if schema.Enum != nil {
+		var members []expressions.ExpressionType
+		optional := false
+		for _, value := range schema.Enum {
+			if value == nil {
+				optional = true
+				continue
+			}
+			members = append(members, expressions.NewLiteralType(*value))
+		}
+		if len(members) == 0 {
+			return nil, fmt.Errorf("enum has no string values")
+		}
+		return expressions.NewUnionType(members).WithOptional(optional), nil
+	}
+	if schema.AnyOf != nil || schema.OneOf != nil {
+		var members []expressions.ExpressionType
+		optional := false
+		for _, member := range append(schema.AnyOf, schema.OneOf...) {
+			if len(member.Type) == 1 && member.Type[0] == "null" {
+				optional = true
+				continue
+			}
+			typ, err := c.convert(member)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, typ)
+		}
+		if len(members) == 0 {
+			return nil, fmt.Errorf("union has no types besides null")
+		}
+		union := expressions.NewUnionType(members)
+		return union.WithOptional(optional || union.Optional()), nil
+	}
+
+	var members []expressions.ExpressionType
+	optional := false
+	for _, name := range schema.Type {
+		if name == "null" {
+			optional = true
+			continue
+		}
+		typ, err := c.convertType(name, schema)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, typ)
+	}
+	switch len(members) {
+	case 0:
+		if optional {
+			return nil, fmt.Errorf("type null is not supported")
+		}
+		// the empty schema accepts any value
+		return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown), nil
+	case 1:
+		return members[0].WithOptional(optional), nil
+	}
+	return expressions.NewUnionType(members).WithOptional(optional), nil
+}
+
+func (c *converter) convertType(name string, schema *Schema) (expressions.ExpressionType, error) {
+	switch name {
+	case "string":
+		if schema.Pattern == decimalPattern {
+			return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDecimal), nil
+		}
+		for baseType, format := range formats {
+			if schema.Format == format {
+				return expressions.NewPrimitiveType(baseType), nil
+			}
+		}
+		return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), nil
+	case "integer":
+		return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt), nil
+	case "number":
+		return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeFloat), nil
+	case "boolean":
+		return expressions.NewPrimitiveType(expressions.ExpressionBaseTypeBool), nil
+	case "array":
+		if schema.Items == nil {
+			return expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)), nil
+		}
+		items, err := c.convert(schema.Items)
+		if err != nil {
+			return nil, err
+		}
+		return expressions.NewArrayType(items), nil
+	case "object":
+		if schema.Properties != nil {
+			return c.object(schema)
+		}
+		key := expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)
+		if schema.PropertyNames != nil && schema.PropertyNames.Pattern == integerKeys {
+			key = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)
+		}
+		value := expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)
+		if schema.AdditionalProperties != nil {
+			var err error
+			value, err = c.convert(schema.AdditionalProperties)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return expressions.NewMapType(key, value), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", name)
+}
+
+func (c *converter) object(schema *Schema) (expressions.ExpressionType, error) {
+	var fields []expressions.ObjectField
+	for _, name := range sortedKeys(schema.Properties) {
+		typ, err := c.convert(schema.Properties[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if !contains(schema.Required, name) {
+			typ = typ.WithOptional(true)
+		}
+		fields = append(fields, expressions.ObjectField{Name: name, Type: typ})
+	}
+	return expressions.NewObjectType(fields), nil
+}
+
+// ref resolves a reference to a definition. Object definitions become named
+// types, other definitions are inlined.
+func (c *converter) ref(ref string) (expressions.ExpressionType, error) {
+	def, ok := c.defs[ref]
+	if !ok {
+		return nil, fmt.Errorf("unresolved reference %s", ref)
+	}
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if def.Properties == nil {
+		return c.convert(def)
+	}
+	if _, ok := c.named[name]; ok {
+		return expressions.NewNamedType(name), nil
+	}
+
+	// declare the name first so recursive definitions refer to themselves
+	c.named[name] = nil
+	typ, err := c.object(def)
+	if err != nil {
+		delete(c.named, name)
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	c.named[name] = typ
+	return expressions.NewNamedType(name), nil
+}
+
+// is reports whether the schema declares type name.
+func (s *Schema) is(name string) bool {
+	return contains(s.Type, name)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(properties map[string]*Schema) []string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
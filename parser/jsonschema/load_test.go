@@ -0,0 +1,103 @@
+package jsonschema
+
+import (
+	"guts/parser/expressions"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		schema     string
+		props      []expressions.Prop
+		namedTypes map[string]expressions.ExpressionType
+		message    string
+	}{
+		{
+			name: "draft-07 definitions and keywords",
+			schema: `{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"type": "object",
+				"properties": {
+					"customer": {"$ref": "#/definitions/Customer"},
+					"code": {"$ref": "#/definitions/Code"},
+					"kind": {"oneOf": [{"const": "a"}, {"const": "b"}, {"type": "null"}]},
+					"extra": {},
+					"items": {"type": "array"},
+					"meta": {"type": "object"},
+					"size": {"type": ["integer", "string"]}
+				},
+				"required": ["customer", "code", "extra", "items", "meta", "size"],
+				"definitions": {
+					"Customer": {"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]},
+					"Code": {"type": "string"}
+				}
+			}`,
+			props: []expressions.Prop{
+				{Name: "code", Type: _stringType},
+				{Name: "customer", Type: expressions.NewNamedType("Customer")},
+				{Name: "extra", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown)},
+				{Name: "items", Type: expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown))},
+				{Name: "kind", Type: expressions.NewUnionType([]expressions.ExpressionType{expressions.NewLiteralType("a"), expressions.NewLiteralType("b")}).WithOptional(true)},
+				{Name: "meta", Type: expressions.NewMapType(_stringType, expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown))},
+				{Name: "size", Type: expressions.NewUnionType([]expressions.ExpressionType{_intType, _stringType})},
+			},
+			namedTypes: map[string]expressions.ExpressionType{
+				"Customer": expressions.NewObjectType([]expressions.ObjectField{{Name: "name", Type: _stringType}}),
+			},
+		},
+		{
+			name:   "properties that are not required are optional",
+			schema: `{"properties": {"title": {"type": "string"}, "count": {"type": "integer", "default": 1}}}`,
+			props: []expressions.Prop{
+				{Name: "count", Type: _intType, Default: int64(1), HasDefault: true},
+				{Name: "title", Type: _stringType.WithOptional(true)},
+			},
+		},
+		{
+			name:   "formats and the decimal pattern",
+			schema: `{"properties": {"placed": {"type": "string", "format": "date-time"}, "total": {"type": "string", "pattern": "^-?[0-9]+(\\.[0-9]+)?$"}}, "required": ["placed", "total"]}`,
+			props: []expressions.Prop{
+				{Name: "placed", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDateTime)},
+				{Name: "total", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDecimal)},
+			},
+		},
+		{name: "invalid JSON", schema: `{"type": `, message: "invalid schema"},
+		{name: "root is not an object", schema: `{"type": "array"}`, message: "the root schema must be an object"},
+		{name: "unresolved reference", schema: `{"type": "object", "properties": {"a": {"$ref": "#/$defs/A"}}}`, message: "property a: unresolved reference #/$defs/A"},
+		{name: "unsupported type", schema: `{"type": "object", "properties": {"a": {"type": "null"}}}`, message: "property a: type null is not supported"},
+		{name: "default of another type", schema: `{"type": "object", "properties": {"a": {"type": "integer", "default": "1"}}}`, message: "property a: default is not a int"},
+		{name: "non-empty default", schema: `{"type": "object", "properties": {"a": {"type": "array", "default": [1]}}}`, message: "only empty arrays are supported as defaults"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := Parse([]byte(tt.schema))
+			if tt.message != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if model == nil {
+				return
+			}
+			if assert.Equal(t, len(tt.props), len(model.Props)) {
+				for i, expected := range tt.props {
+					actual := model.Props[i]
+					assert.Equal(t, expected.Name, actual.Name)
+					assert.True(t, expected.Type.Equals(actual.Type), "type mismatch for %s: expected %s, got %s", expected.Name, expected.Type, actual.Type)
+					assert.Equal(t, expected.Default, actual.Default)
+					assert.Equal(t, expected.HasDefault, actual.HasDefault)
+				}
+			}
+			assert.Equal(t, len(tt.namedTypes), len(model.NamedTypes))
+			for name, expected := range tt.namedTypes {
+				assert.True(t, expected.Equals(model.NamedTypes[name]), "type mismatch for %s", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// Package jsonschema converts template models to and from JSON Schema, so
+// services can validate a payload before rendering it and templates can take
+// their props from an existing schema.
+package jsonschema
+
+import (
+	"encoding/json"
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"sort"
+)
+
+// Draft is the JSON Schema dialect of generated schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema that maps to expression types.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Const                *string            `json:"const,omitempty"`
+	Enum                 []*string          `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	PropertyNames        *Schema            `json:"propertyNames,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Default              json.RawMessage    `json:"default,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// Types is the type keyword of a schema, a single type or a list of types.
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*t = Types{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// formats are the string formats of the base types represented as strings.
+var formats = map[expressions.ExpressionBaseType]string{
+	expressions.ExpressionBaseTypeDate:     "date",
+	expressions.ExpressionBaseTypeDateTime: "date-time",
+	expressions.ExpressionBaseTypeDuration: "duration",
+}
+
+// integerKeys is the pattern of the keys of maps with int keys.
+const integerKeys = "^-?[0-9]+$"
+
+// decimalPattern is the pattern of decimals, which have no format in the
+// specification.
+const decimalPattern = "^-?[0-9]+(\\.[0-9]+)?$"
+
+// Generate returns the schema of the model of a document: its props, or its
+// declared types if it has no props block.
+func Generate(document nodes.Document) *Schema {
+	props := document.Props()
+	if !document.Strict() {
+		declared := document.GetDeclaredTypes()
+		names := make([]string, 0, len(declared))
+		for name := range declared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			props = append(props, expressions.Prop{Name: name, Type: declared[name]})
+		}
+	}
+
+	schema := &Schema{
+		Schema:     Draft,
+		Type:       Types{"object"},
+		Properties: map[string]*Schema{},
+	}
+	for _, prop := range props {
+		property := FromType(prop.Type)
+		if prop.HasDefault {
+			property.Default, _ = json.Marshal(prop.Default)
+		}
+		schema.Properties[prop.Name] = property
+		if !prop.HasDefault && !prop.Type.Optional() {
+			schema.Required = append(schema.Required, prop.Name)
+		}
+	}
+
+	named := document.GetNamedTypes()
+	if len(named) > 0 {
+		schema.Defs = map[string]*Schema{}
+		for name, typ := range named {
+			schema.Defs[name] = FromType(typ)
+		}
+	}
+	return schema
+}
+
+// FromType returns the schema of an expression type. Named types refer to
+// the definitions of the root schema.
+func FromType(t expressions.ExpressionType) *Schema {
+	schema := fromType(t)
+	if t.Optional() {
+		return nullable(schema)
+	}
+	return schema
+}
+
+func fromType(t expressions.ExpressionType) *Schema {
+	if literal, ok := t.Literal(); ok {
+		return &Schema{Const: &literal}
+	}
+
+	switch t.BaseType() {
+	case expressions.ExpressionBaseTypeString:
+		return &Schema{Type: Types{"string"}}
+	case expressions.ExpressionBaseTypeInt:
+		return &Schema{Type: Types{"integer"}}
+	case expressions.ExpressionBaseTypeFloat:
+		return &Schema{Type: Types{"number"}}
+	case expressions.ExpressionBaseTypeBool:
+		return &Schema{Type: Types{"boolean"}}
+	case expressions.ExpressionBaseTypeDate, expressions.ExpressionBaseTypeDateTime,
+		expressions.ExpressionBaseTypeDuration:
+		return &Schema{Type: Types{"string"}, Format: formats[t.BaseType()]}
+	case expressions.ExpressionBaseTypeDecimal:
+		return &Schema{Type: Types{"string"}, Pattern: decimalPattern}
+	case expressions.ExpressionBaseTypeArray:
+		return &Schema{Type: Types{"array"}, Items: FromType(t.ValueType())}
+	case expressions.ExpressionBaseTypeMap:
+		schema := &Schema{Type: Types{"object"}, AdditionalProperties: FromType(t.ValueType())}
+		if t.KeyType().BaseType() == expressions.ExpressionBaseTypeInt {
+			schema.PropertyNames = &Schema{Pattern: integerKeys}
+		}
+		return schema
+	case expressions.ExpressionBaseTypeObject:
+		if t.Name() != "" {
+			return &Schema{Ref: "#/$defs/" + t.Name()}
+		}
+		schema := &Schema{Type: Types{"object"}, Properties: map[string]*Schema{}}
+		for _, field := range t.Fields() {
+			schema.Properties[field.Name] = FromType(field.Type)
+			if !field.Type.Optional() {
+				schema.Required = append(schema.Required, field.Name)
+			}
+		}
+		return schema
+	case expressions.ExpressionBaseTypeUnion:
+		var literals []*string
+		var members []*Schema
+		for _, member := range t.Members() {
+			if literal, ok := member.Literal(); ok {
+				literals = append(literals, &literal)
+			}
+			members = append(members, FromType(member))
+		}
+		if len(literals) == len(members) {
+			return &Schema{Enum: literals}
+		}
+		return &Schema{AnyOf: members}
+	}
+	return &Schema{}
+}
+
+// nullable returns a schema that also accepts null.
+func nullable(schema *Schema) *Schema {
+	switch {
+	case len(schema.Type) == 1 && schema.Ref == "":
+		schema.Type = append(schema.Type, "null")
+		return schema
+	case schema.Enum != nil:
+		schema.Enum = append(schema.Enum, nil)
+		return schema
+	case schema.AnyOf != nil:
+		schema.AnyOf = append(schema.AnyOf, &Schema{Type: Types{"null"}})
+		return schema
+	case schema.Ref == "" && schema.Const == nil:
+		// the empty schema accepts null already
+		return schema
+	}
+	return &Schema{AnyOf: []*Schema{schema, {Type: Types{"null"}}}}
+}
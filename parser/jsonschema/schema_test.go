@@ -0,0 +1,147 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_stringType = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)
+	_intType    = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)
+	_floatType  = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeFloat)
+	_boolType   = expressions.NewPrimitiveType(expressions.ExpressionBaseTypeBool)
+)
+
+func TestFromType(t *testing.T) {
+	tests := []struct {
+		name     string
+		typ      expressions.ExpressionType
+		expected string
+	}{
+		{name: "string", typ: _stringType, expected: `{"type":"string"}`},
+		{name: "optional int", typ: _intType.WithOptional(true), expected: `{"type":["integer","null"]}`},
+		{name: "unknown", typ: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeUnknown), expected: `{}`},
+		{name: "array", typ: expressions.NewArrayType(_floatType), expected: `{"type":"array","items":{"type":"number"}}`},
+		{
+			name:     "map with int keys",
+			typ:      expressions.NewMapType(_intType, _boolType),
+			expected: `{"type":"object","additionalProperties":{"type":"boolean"},"propertyNames":{"pattern":"^-?[0-9]+$"}}`,
+		},
+		{
+			name: "object",
+			typ: expressions.NewObjectType([]expressions.ObjectField{
+				{Name: "name", Type: _stringType},
+				{Name: "nickname", Type: _stringType.WithOptional(true)},
+			}),
+			expected: `{"type":"object","properties":{"name":{"type":"string"},"nickname":{"type":["string","null"]}},"required":["name"]}`,
+		},
+		{name: "named type", typ: expressions.NewNamedType("User"), expected: `{"$ref":"#/$defs/User"}`},
+		{
+			name:     "optional named type",
+			typ:      expressions.NewNamedType("User").WithOptional(true),
+			expected: `{"anyOf":[{"$ref":"#/$defs/User"},{"type":"null"}]}`,
+		},
+		{name: "datetime", typ: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDateTime), expected: `{"type":"string","format":"date-time"}`},
+		{name: "optional decimal", typ: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDecimal).WithOptional(true), expected: `{"type":["string","null"],"pattern":"^-?[0-9]+(\\.[0-9]+)?$"}`},
+		{name: "literal", typ: expressions.NewLiteralType("open"), expected: `{"const":"open"}`},
+		{
+			name:     "optional literal union",
+			typ:      expressions.NewUnionType([]expressions.ExpressionType{expressions.NewLiteralType("open"), expressions.NewLiteralType("closed")}).WithOptional(true),
+			expected: `{"enum":["open","closed",null]}`,
+		},
+		{
+			name:     "union",
+			typ:      expressions.NewUnionType([]expressions.ExpressionType{_stringType, _intType}),
+			expected: `{"anyOf":[{"type":"string"},{"type":"integer"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := json.Marshal(FromType(tt.typ))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(actual))
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	lineItem := expressions.NewObjectType([]expressions.ObjectField{
+		{Name: "sku", Type: _stringType},
+		{Name: "qty", Type: _intType},
+		{Name: "price", Type: _floatType},
+		{Name: "parent", Type: expressions.NewNamedType("LineItem").WithOptional(true)},
+		{Name: "options", Type: expressions.NewObjectType([]expressions.ObjectField{
+			{Name: "gift", Type: _boolType},
+			{Name: "message", Type: _stringType.WithOptional(true)},
+		})},
+	})
+	status := expressions.NewUnionType([]expressions.ExpressionType{
+		expressions.NewLiteralType("pending"),
+		expressions.NewLiteralType("shipped"),
+	})
+	props := []expressions.Prop{
+		{Name: "count", Type: _intType, Default: int64(0), HasDefault: true},
+		{Name: "due", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDate), Default: "2024-01-31", HasDefault: true},
+		{Name: "elapsed", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDuration)},
+		{Name: "id", Type: expressions.NewUnionType([]expressions.ExpressionType{_stringType, _intType})},
+		{Name: "lines", Type: expressions.NewArrayType(expressions.NewNamedType("LineItem"))},
+		{Name: "nickname", Type: _stringType.WithOptional(true)},
+		{Name: "notes", Type: expressions.NewMapType(_intType, _stringType.WithOptional(true))},
+		{Name: "placed", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDateTime).WithOptional(true)},
+		{Name: "ratio", Type: _floatType, Default: 0.5, HasDefault: true},
+		{Name: "sizes", Type: expressions.NewArrayType(status.WithOptional(true))},
+		{Name: "status", Type: status, Default: "pending", HasDefault: true},
+		{Name: "tags", Type: expressions.NewArrayType(_stringType), Default: []any{}, HasDefault: true},
+		{Name: "title", Type: _stringType},
+		{Name: "total", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeDecimal)},
+		{Name: "user", Type: expressions.NewNamedType("LineItem").WithOptional(true), Default: nil, HasDefault: true},
+	}
+
+	document := nodes.NewDocument()
+	assert.NoError(t, document.AddNamedType("LineItem", lineItem))
+	assert.NoError(t, document.SetProps(props, nodes.Position{Line: 1, Column: 1}))
+
+	data, err := json.Marshal(Generate(document))
+	assert.NoError(t, err)
+	model, err := Parse(data)
+	assert.NoError(t, err)
+	if model == nil {
+		return
+	}
+
+	if assert.Equal(t, len(props), len(model.Props)) {
+		for i, expected := range props {
+			actual := model.Props[i]
+			assert.Equal(t, expected.Name, actual.Name)
+			assert.True(t, expected.Type.Equals(actual.Type), "type mismatch for %s: expected %s, got %s", expected.Name, expected.Type, actual.Type)
+			assert.Equal(t, expected.HasDefault, actual.HasDefault, expected.Name)
+			assert.Equal(t, expected.Default, actual.Default, expected.Name)
+		}
+	}
+	if assert.Len(t, model.NamedTypes, 1) {
+		assert.True(t, lineItem.Equals(model.NamedTypes["LineItem"]), "got %s", model.NamedTypes["LineItem"])
+	}
+}
+
+func TestGenerate_DeclaredTypes(t *testing.T) {
+	document := nodes.NewDocument()
+	assert.NoError(t, document.AddDeclaredType("title", _stringType, nodes.Position{}))
+	assert.NoError(t, document.AddDeclaredType("count", _intType.WithOptional(true), nodes.Position{}))
+
+	actual, err := json.Marshal(Generate(document))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"count": {"type": ["integer", "null"]},
+			"title": {"type": "string"}
+		},
+		"required": ["title"]
+	}`, string(actual))
+}
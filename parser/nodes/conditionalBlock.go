@@ -45,6 +45,10 @@ func (e *conditionalBlock) Next() ConditionalBlock {
 	return e.next
 }
 
+func (e *conditionalBlock) Append(children ...Node) {
+	e.appendTo(e, children)
+}
+
 func (e *conditionalBlock) OuterHTML() string {
 	var buf bytes.Buffer
 
@@ -10,18 +10,39 @@ import (
 type Document interface {
 	Node
 	GetDeclaredTypes() map[string]expressions.ExpressionType
-	AddDeclaredType(name string, expressionType expressions.ExpressionType) error
+	AddDeclaredType(name string, expressionType expressions.ExpressionType, position Position) error
+	Declarations(name string) []Declaration
+	GetNamedTypes() map[string]expressions.ExpressionType
+	AddNamedType(name string, expressionType expressions.ExpressionType) error
+	Props() []expressions.Prop
+	SetProps(props []expressions.Prop, position Position) error
+	Strict() bool
+	AddWarning(message string)
+	Warnings() []string
+}
+
+// Declaration is a site declaring the type of a field of the model.
+type Declaration struct {
+	Type     expressions.ExpressionType
+	Position Position
 }
 
 type document struct {
 	node
 	declaredTypes map[string]expressions.ExpressionType
+	declarations  map[string][]Declaration
+	namedTypes    map[string]expressions.ExpressionType
+	props         []expressions.Prop
+	strict        bool
+	warnings      []string
 }
 
 func NewDocument() Document {
 	return &document{
 		node:          node{name: "#document"},
 		declaredTypes: make(map[string]expressions.ExpressionType),
+		declarations:  make(map[string][]Declaration),
+		namedTypes:    make(map[string]expressions.ExpressionType),
 	}
 }
 
@@ -29,18 +50,96 @@ func (t *document) GetDeclaredTypes() map[string]expressions.ExpressionType {
 	return t.declaredTypes
 }
 
-func (t *document) AddDeclaredType(name string, expressionType expressions.ExpressionType) error {
+// AddDeclaredType declares the type of a field of the model at position. A
+// field may be declared at several sites, all with the same type. Types
+// added at the zero Position, such as inferred types, have no site.
+func (t *document) AddDeclaredType(name string, expressionType expressions.ExpressionType, position Position) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return fmt.Errorf("empty type name")
 	}
-	if declared, ok := t.declaredTypes[name]; ok && !declared.Equals(expressionType) {
-		return fmt.Errorf("%s is already declared with different type: %s", name, declared.String())
+	site := Declaration{Type: expressionType, Position: position}
+	if declared, ok := t.declaredTypes[name]; ok {
+		if !declared.WithOptional(false).Equals(expressionType.WithOptional(false)) {
+			first := Declaration{Type: declared}
+			if len(t.declarations[name]) > 0 {
+				first = t.declarations[name][0]
+			}
+			return fmt.Errorf("%s is declared as %s at %s and as %s at %s",
+				name, first.Type.String(), first.Position, expressionType.String(), position)
+		}
+		// a field that is optional anywhere is optional everywhere
+		expressionType = expressionType.WithOptional(declared.Optional() || expressionType.Optional())
 	}
 	t.declaredTypes[name] = expressionType
+	if position.IsValid() {
+		t.declarations[name] = append(t.declarations[name], site)
+	}
 	return nil
 }
 
+// Declarations returns the sites declaring the type of name, in the order
+// they were added.
+func (t *document) Declarations(name string) []Declaration {
+	return t.declarations[name]
+}
+
+// GetNamedTypes returns the object types declared with {type Name = {...}}.
+func (t *document) GetNamedTypes() map[string]expressions.ExpressionType {
+	return t.namedTypes
+}
+
+func (t *document) AddNamedType(name string, expressionType expressions.ExpressionType) error {
+	if _, ok := t.namedTypes[name]; ok {
+		return fmt.Errorf("type %s is already declared", name)
+	}
+	if expressionType.BaseType() != expressions.ExpressionBaseTypeObject || expressionType.Name() != "" {
+		return fmt.Errorf("type %s must be an object type", name)
+	}
+	t.namedTypes[name] = expressionType
+	return nil
+}
+
+// Props returns the inputs declared in the template's props block.
+func (t *document) Props() []expressions.Prop {
+	return t.props
+}
+
+// SetProps declares the inputs of the template in a props block at position.
+// A template with a props block is strict: every variable it references must
+// be one of its props.
+func (t *document) SetProps(props []expressions.Prop, position Position) error {
+	if t.strict {
+		return fmt.Errorf("props are already declared")
+	}
+	for _, prop := range props {
+		err := t.AddDeclaredType(prop.Name, prop.Type, position)
+		if err != nil {
+			return err
+		}
+	}
+	t.props = props
+	t.strict = true
+	return nil
+}
+
+// Strict reports whether the template declares its inputs in a props block.
+func (t *document) Strict() bool {
+	return t.strict
+}
+
+func (t *document) AddWarning(message string) {
+	t.warnings = append(t.warnings, message)
+}
+
+func (t *document) Warnings() []string {
+	return t.warnings
+}
+
+func (t *document) Append(children ...Node) {
+	t.appendTo(t, children)
+}
+
 func (t *document) Parent() Node {
 	return nil
 }
@@ -51,6 +150,10 @@ func (t *document) String() string {
 	for name, expressionType := range t.declaredTypes {
 		buf.WriteString(fmt.Sprintf("{\"name\": \"%s\", \"expressionType\": \"%s\"}, ", name, expressionType.String()))
 	}
+	buf.WriteString("], \"namedTypes\": [")
+	for name, expressionType := range t.namedTypes {
+		buf.WriteString(fmt.Sprintf("{\"name\": \"%s\", \"expressionType\": \"%s\"}, ", name, expressionType.String()))
+	}
 	buf.WriteString("], \"children\": [")
 	for _, child := range t.children {
 		buf.WriteString(child.String())
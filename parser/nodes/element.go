@@ -99,6 +99,10 @@ func (t *element) OuterHTML() string {
 	return buf.String()
 }
 
+func (t *element) Append(children ...Node) {
+	t.appendTo(t, children)
+}
+
 func (t *element) Attributes() attributes.Attributes {
 	return t.attributes
 }
@@ -11,6 +11,10 @@ type LoopBlock interface {
 	ValueKey() string
 	ItemsKey() string
 	ExpressionType() expressions.ExpressionType
+	// Key is the path identifying an item across renders, e.g. item.id in
+	// {for i, item in items key item.id}, or empty if the loop is not keyed.
+	Key() string
+	SetKey(key string)
 }
 
 type loopBlock struct {
@@ -19,6 +23,7 @@ type loopBlock struct {
 	valueKey string
 	itemsKey string
 	typ      expressions.ExpressionType
+	key      string
 }
 
 func NewLoopBlock(indexKey, valueKey, itemsKey string, typ expressions.ExpressionType) LoopBlock {
@@ -33,6 +38,10 @@ func NewLoopBlock(indexKey, valueKey, itemsKey string, typ expressions.Expressio
 	}
 }
 
+func (e *loopBlock) Append(children ...Node) {
+	e.appendTo(e, children)
+}
+
 func (e *loopBlock) OuterHTML() string {
 	var buf bytes.Buffer
 	buf.WriteString("{for ")
@@ -45,6 +54,10 @@ func (e *loopBlock) OuterHTML() string {
 		buf.WriteString(":")
 		buf.WriteString(e.typ.String())
 	}
+	if e.key != "" {
+		buf.WriteString(" key ")
+		buf.WriteString(e.key)
+	}
 	buf.WriteString("}")
 
 	for _, child := range e.children {
@@ -71,6 +84,8 @@ func (e *loopBlock) String() string {
 	if e.typ != nil {
 		buf.WriteString(e.typ.String())
 	}
+	buf.WriteString("\", \"key\": \"")
+	buf.WriteString(e.key)
 	buf.WriteString("\", \"children\": [")
 	for _, child := range e.children {
 		buf.WriteString(child.String())
@@ -94,3 +109,11 @@ func (e *loopBlock) ItemsKey() string {
 func (e *loopBlock) ExpressionType() expressions.ExpressionType {
 	return e.typ
 }
+
+func (e *loopBlock) Key() string {
+	return e.key
+}
+
+func (e *loopBlock) SetKey(key string) {
+	e.key = key
+}
@@ -59,9 +59,15 @@ func (t *node) Children() []Node {
 }
 
 func (t *node) Append(children ...Node) {
+	t.appendTo(t, children)
+}
+
+// appendTo appends children, setting their parent to the embedding node
+// rather than the embedded *node.
+func (t *node) appendTo(parent Node, children []Node) {
 	t.children = append(t.children, children...)
 	for _, c := range children {
-		c.setParent(t)
+		c.setParent(parent)
 	}
 }
 
@@ -9,12 +9,19 @@ type OutputBlock interface {
 	Node
 	Key() string
 	ExpressionType() expressions.ExpressionType
+	Expression() expressions.BooleanExpression
+	// Raw reports whether the output is trusted markup, written {raw html},
+	// which generators do not escape.
+	Raw() bool
+	SetRaw(raw bool)
 }
 
 type outputBlock struct {
 	node
-	key string
-	typ expressions.ExpressionType
+	key  string
+	typ  expressions.ExpressionType
+	expr expressions.BooleanExpression
+	raw  bool
 }
 
 func NewOutputExpression(key string, typ expressions.ExpressionType) OutputBlock {
@@ -27,6 +34,18 @@ func NewOutputExpression(key string, typ expressions.ExpressionType) OutputBlock
 	}
 }
 
+// NewComputedOutputExpression creates an output block for an expression that is
+// more than a single key, such as nickname ?? name.
+func NewComputedOutputExpression(expr expressions.BooleanExpression) OutputBlock {
+	return &outputBlock{
+		node: node{
+			name: "#output",
+		},
+		key:  expr.String(),
+		expr: expr,
+	}
+}
+
 func (o *outputBlock) TextContent() string {
 	return ""
 }
@@ -34,6 +53,13 @@ func (o *outputBlock) TextContent() string {
 func (o *outputBlock) OuterHTML() string {
 	var buf bytes.Buffer
 	buf.WriteString("{")
+	if elem, ok := o.Parent().(Element); ok && elem.Name() == "script" {
+		// outputs in scripts are marked, the braces of the code are not
+		buf.WriteString("= ")
+	}
+	if o.raw {
+		buf.WriteString("raw ")
+	}
 	buf.WriteString(o.key)
 	if o.typ != nil {
 		buf.WriteString(":")
@@ -70,3 +96,16 @@ func (o *outputBlock) Key() string {
 func (o *outputBlock) ExpressionType() expressions.ExpressionType {
 	return o.typ
 }
+
+// Expression returns the computed expression, or nil if the block outputs a key.
+func (o *outputBlock) Expression() expressions.BooleanExpression {
+	return o.expr
+}
+
+func (o *outputBlock) Raw() bool {
+	return o.raw
+}
+
+func (o *outputBlock) SetRaw(raw bool) {
+	o.raw = raw
+}
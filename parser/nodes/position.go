@@ -0,0 +1,21 @@
+package nodes
+
+import "fmt"
+
+// Position is a location in a template. Lines and columns start at 1; the
+// zero Position is unknown, e.g. for inferred types.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "unknown position"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"guts/parser/expressions"
+	"guts/parser/gomodel"
+	"guts/parser/jsonschema"
 	"guts/parser/nodes"
 	"guts/parser/nodes/attributes"
 	"io"
+	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -39,6 +43,7 @@ const (
 	RawTextLessThanSign        ParseState = "RawTextLessThanSign"
 	RawTextEndTagOpen          ParseState = "RawTextEndTagOpen"
 	RawTextEndTagName          ParseState = "RawTextEndTagName"
+	RawTextExpression          ParseState = "RawTextExpression"
 	MarkupDeclarationOpen      ParseState = "MarkupDeclarationOpen"
 	Comment                    ParseState = "Comment"
 	ExpressionName             ParseState = "ExpressionName"
@@ -46,11 +51,38 @@ const (
 	IfConditionalExpression    ParseState = "IfConditionalExpression"
 	ElseConditionalExpression  ParseState = "ElseConditionalExpression"
 	ForLoopExpression          ParseState = "ForLoopExpression"
+	TypeDeclarationExpression  ParseState = "TypeDeclarationExpression"
+	PropsExpression            ParseState = "PropsExpression"
 	OutputExpressionKey        ParseState = "OutputExpressionKey"
 	OutputExpressionType       ParseState = "OutputExpressionType"
 )
 
-var _forLoopRegex = regexp.MustCompile(`^\s*(\w+),\s*(\w+)\s+in\s+(\w+)\s*(?:\:\s*([A-Za-z0-9_\]\[-]+))?\s*$`)
+// _declarationStates are the states of expressions and attribute values,
+// which may declare types.
+var _declarationStates = map[ParseState]bool{
+	InElementExpression:        true,
+	SpreadAttribute:            true,
+	BindExpression:             true,
+	AttributeValueDoubleQuoted: true,
+	AttributeValueSingleQuoted: true,
+	AttributeValueExpression:   true,
+	ExpressionName:             true,
+	EndExpression:              true,
+	IfConditionalExpression:    true,
+	ElseConditionalExpression:  true,
+	ForLoopExpression:          true,
+	TypeDeclarationExpression:  true,
+	PropsExpression:            true,
+	OutputExpressionKey:        true,
+	OutputExpressionType:       true,
+	RawTextExpression:          true,
+}
+
+// _forLoopRegex matches the header of a loop. Everything between the colon
+// and the key clause is the type annotation, parsed by ParseExpressionType.
+var _forLoopRegex = regexp.MustCompile(`^\s*(\w+),\s*(\w+)\s+in\s+(\w+)\s*(?:\:\s*(.+?))?(?:\s+key\s+(\w+(?:\.\w+)*))?\s*$`)
+
+var _typeDeclarationRegex = regexp.MustCompile(`^\s*([A-Za-z_]\w*)\s*=\s*([\s\S]+?)\s*$`)
 
 var _rawTextElements = map[string]bool{
 	"script":   true,
@@ -58,6 +90,20 @@ var _rawTextElements = map[string]bool{
 	"textarea": true,
 }
 
+// _blockKeywords start the expressions that are not outputs.
+var _blockKeywords = map[string]bool{
+	"if":    true,
+	"else":  true,
+	"for":   true,
+	"type":  true,
+	"props": true,
+}
+
+// _rawTextOutputRegex matches the outputs recognized in the raw text of style
+// elements: a key or member path, optionally annotated or followed by ?? and a
+// fallback.
+var _rawTextOutputRegex = regexp.MustCompile(`^[A-Za-z_][\w.?]*(\s*:.*|\s*\?\?.*)?$`)
+
 type tag struct {
 	name       strings.Builder
 	attrName   strings.Builder
@@ -76,12 +122,43 @@ func newTag() *tag {
 type parseContext struct {
 	Rune     rune
 	Position int
+	// Line and Column are the location of Rune.
+	Line   int
+	Column int
+	// Start is the location where the current expression or attribute value
+	// began, the site of the types it declares.
+	Start    nodes.Position
 	Buf      bytes.Buffer
 	Temp     bytes.Buffer
 	State    ParseState
 	Parent   nodes.Node
 	Tag      *tag
 	Document nodes.Document
+	Scope    *scope
+	// Annotations are all types declared by the template, checked once the
+	// whole document is parsed because named types may be declared later.
+	Annotations []expressions.ExpressionType
+	Options     Options
+	// Raw is set while parsing {raw ...}, an output that is not escaped.
+	Raw bool
+	// Binds are the sites of the {bind:name} expressions by name. A name is
+	// only bound more than once in branches of a conditional that are never
+	// rendered together.
+	Binds map[string][]bindSite
+}
+
+// bindSite is where a {bind:name} expression is written.
+type bindSite struct {
+	position nodes.Position
+	// parent is the node the bound element is appended to.
+	parent nodes.Node
+}
+
+// Options configure how a template is parsed.
+type Options struct {
+	// Dir is the directory of the template. Go types referenced by
+	// {props from "..."} are loaded from the module containing it.
+	Dir string
 }
 
 var _parseStateHandlers = map[ParseState](func(ctx *parseContext) error){
@@ -106,6 +183,7 @@ var _parseStateHandlers = map[ParseState](func(ctx *parseContext) error){
 	RawTextLessThanSign:        handleRawTextLessThanSign,
 	RawTextEndTagOpen:          handleRawTextEndTagOpen,
 	RawTextEndTagName:          handleRawTextEndTagName,
+	RawTextExpression:          handleRawTextExpression,
 	MarkupDeclarationOpen:      handleMarkupDeclarationOpen,
 	Comment:                    handleComment,
 	ExpressionName:             handleExpressionName,
@@ -113,6 +191,8 @@ var _parseStateHandlers = map[ParseState](func(ctx *parseContext) error){
 	IfConditionalExpression:    handleIfConditionalExpression,
 	ElseConditionalExpression:  handleElseConditionalExpression,
 	ForLoopExpression:          handleForLoopExpression,
+	TypeDeclarationExpression:  handleTypeDeclarationExpression,
+	PropsExpression:            handlePropsExpression,
 	OutputExpressionKey:        handleOutputExpressionKey,
 	OutputExpressionType:       handleOutputExpressionType,
 }
@@ -292,7 +372,10 @@ func handleSpreadAttribute(ctx *parseContext) error {
 		ctx.Tag.attributes.SetSpreadAttribute(spread)
 
 		if spread.ExpressionType() != nil {
-			ctx.Document.AddDeclaredType(spread.Key(), spread.ExpressionType())
+			err := declareType(ctx, spread.Key(), spread.ExpressionType())
+			if err != nil {
+				return parseErr(ctx, err.Error())
+			}
 		}
 
 		ctx.Buf.Reset()
@@ -312,7 +395,14 @@ func handleBindExpression(ctx *parseContext) error {
 		if ctx.Buf.Len() == 0 {
 			return parseErr(ctx, "empty bind expression")
 		}
-		ctx.Tag.bind = ctx.Buf.String()
+		name := ctx.Buf.String()
+		for _, site := range ctx.Binds[name] {
+			if !exclusive(site.parent, ctx.Parent) {
+				return parseErr(ctx, fmt.Sprintf("%s is bound at %s and %s", name, site.position, ctx.Start))
+			}
+		}
+		ctx.Binds[name] = append(ctx.Binds[name], bindSite{position: ctx.Start, parent: ctx.Parent})
+		ctx.Tag.bind = name
 		ctx.Buf.Reset()
 		ctx.State = AfterAttributeValueQuoted
 	default:
@@ -321,6 +411,34 @@ func handleBindExpression(ctx *parseContext) error {
 	return nil
 }
 
+// exclusive reports whether the nodes appended to a and b are never rendered
+// together, as they are in different branches of the same conditional.
+func exclusive(a, b nodes.Node) bool {
+	for x := a; x != nil; x = x.Parent() {
+		branch, ok := x.(nodes.ConditionalBlock)
+		if !ok {
+			continue
+		}
+		for y := b; y != nil; y = y.Parent() {
+			other, ok := y.(nodes.ConditionalBlock)
+			if ok && other != branch && (follows(branch, other) || follows(other, branch)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// follows reports whether b is a later branch of the conditional of a.
+func follows(a, b nodes.ConditionalBlock) bool {
+	for next := a.Next(); next != nil; next = next.Next() {
+		if next == b {
+			return true
+		}
+	}
+	return false
+}
+
 func handleAttributeName(ctx *parseContext) error {
 	r := ctx.Rune
 	switch {
@@ -545,6 +663,14 @@ func handleRawText(ctx *parseContext) error {
 	switch ctx.Rune {
 	case '<':
 		ctx.State = RawTextLessThanSign
+	case '{':
+		// ${ is a substitution in a JavaScript template literal
+		if !hasRawTextOutputs(ctx.Parent) || bytes.HasSuffix(ctx.Buf.Bytes(), []byte("$")) {
+			ctx.Buf.WriteRune(ctx.Rune)
+			break
+		}
+		ctx.Temp.Reset()
+		ctx.State = RawTextExpression
 	default:
 		ctx.Buf.WriteRune(ctx.Rune)
 		ctx.State = RawText
@@ -552,6 +678,87 @@ func handleRawText(ctx *parseContext) error {
 	return nil
 }
 
+// handleRawTextExpression reads an output in a script or style element. The
+// braces of the code itself are kept as text. In a style element an output
+// must start with a key right after the opening brace, e.g. {accent}, while
+// { a } is code. In a script, where {a} is a block, an object literal, a
+// destructuring pattern or an import list, outputs are written {= config}.
+func handleRawTextExpression(ctx *parseContext) error {
+	r := ctx.Rune
+	switch {
+	case r == '}':
+		content := ctx.Temp.String()
+		ctx.Temp.Reset()
+		ctx.State = RawText
+		if isScript(ctx.Parent) {
+			expr, ok := strings.CutPrefix(content, "=")
+			if !ok {
+				// an empty block or object such as {}
+				ctx.Buf.WriteString("{" + content + "}")
+				break
+			}
+			if ctx.Buf.Len() > 0 {
+				ctx.Parent.Append(nodes.NewTextNode(ctx.Buf.String()))
+				ctx.Buf.Reset()
+			}
+			return applyOutput(ctx, strings.TrimSpace(expr))
+		}
+		if !_rawTextOutputRegex.MatchString(content) {
+			ctx.Buf.WriteString("{" + content + "}")
+			break
+		}
+		if _, _, err := expressions.ParseBooleanExpression(content); err != nil {
+			ctx.Buf.WriteString("{" + content + "}")
+			break
+		}
+		if ctx.Buf.Len() > 0 {
+			ctx.Parent.Append(nodes.NewTextNode(ctx.Buf.String()))
+			ctx.Buf.Reset()
+		}
+		return applyOutput(ctx, content)
+	case r == '{', r == '<', r == '\n', ctx.Temp.Len() == 0 && !startsRawTextOutput(ctx.Parent, r):
+		ctx.Buf.WriteString("{")
+		ctx.Buf.Write(ctx.Temp.Bytes())
+		ctx.Temp.Reset()
+		ctx.State = RawText
+		return handleRawText(ctx)
+	default:
+		ctx.Temp.WriteRune(r)
+	}
+	return nil
+}
+
+// hasRawTextOutputs reports whether outputs are parsed in the raw text of n:
+// style elements and scripts, except the TypeScript of the module itself.
+func hasRawTextOutputs(n nodes.Node) bool {
+	elem, ok := n.(nodes.Element)
+	if !ok {
+		return false
+	}
+	switch elem.Name() {
+	case "style":
+		return true
+	case "script":
+		typ := elem.Attributes().GetAttribute("type")
+		return typ == nil || typ.OuterHTML() != `"ts"`
+	}
+	return false
+}
+
+// startsRawTextOutput reports whether r, right after an opening brace in the
+// raw text of n, starts an output.
+func startsRawTextOutput(n nodes.Node, r rune) bool {
+	if isScript(n) {
+		return r == '='
+	}
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isScript(n nodes.Node) bool {
+	elem, ok := n.(nodes.Element)
+	return ok && elem.Name() == "script"
+}
+
 func handleRawTextLessThanSign(ctx *parseContext) error {
 	switch ctx.Rune {
 	case '/':
@@ -706,6 +913,9 @@ func handleExpressionName(ctx *parseContext) error {
 			break
 		}
 		str := ctx.Buf.String()
+		if ctx.Raw && _blockKeywords[str] {
+			return parseErr(ctx, "raw can only be applied to an output: "+str)
+		}
 		if str == "if" {
 			ctx.State = IfConditionalExpression
 			ctx.Buf.Reset()
@@ -722,6 +932,22 @@ func handleExpressionName(ctx *parseContext) error {
 			ctx.Buf.Reset()
 			break
 		}
+		if str == "type" {
+			ctx.State = TypeDeclarationExpression
+			ctx.Buf.Reset()
+			break
+		}
+		if str == "props" {
+			ctx.State = PropsExpression
+			ctx.Buf.Reset()
+			break
+		}
+		if str == "raw" && !ctx.Raw {
+			// {raw html} outputs trusted markup as it is
+			ctx.Raw = true
+			ctx.Buf.Reset()
+			break
+		}
 		// do not reset buf, it contains the variable/output key name
 		ctx.State = OutputExpressionKey
 	case r == ':':
@@ -734,13 +960,24 @@ func handleExpressionName(ctx *parseContext) error {
 		if ctx.Buf.Len() > 0 {
 			return parseErr(ctx, "invalid expression name: "+ctx.Buf.String()+"/")
 		}
+		if ctx.Raw {
+			return parseErr(ctx, "raw can only be applied to an output")
+		}
 		ctx.State = EndExpression
 	case r == '}':
 		str := ctx.Buf.String()
+		if ctx.Raw && _blockKeywords[str] {
+			return parseErr(ctx, "raw can only be applied to an output: "+str)
+		}
 		// if and for expressions must have content
 		if str == "if" || str == "for" {
 			return parseErr(ctx, "invalid empty if/for expression: "+str)
 		}
+		if str == "props" {
+			// a template without inputs
+			ctx.Buf.Reset()
+			return applyProps(ctx, "")
+		}
 		if str == "else" {
 			ifexpr, ok := ctx.Parent.(nodes.ConditionalBlock)
 			if !ok {
@@ -750,8 +987,10 @@ func handleExpressionName(ctx *parseContext) error {
 			ifexpr.SetNext(expr)
 			ctx.Parent = expr
 		} else {
-			expr := nodes.NewOutputExpression(str, nil)
-			ctx.Parent.Append(expr)
+			err := applyOutput(ctx, str)
+			if err != nil {
+				return err
+			}
 		}
 		ctx.Buf.Reset()
 		ctx.State = Data
@@ -784,6 +1023,7 @@ func handleEndExpression(ctx *parseContext) error {
 				return parseErr(ctx, "mismatched end expression: "+str)
 			}
 			ctx.Parent = ctx.Parent.Parent()
+			ctx.Scope = ctx.Scope.parent
 			ctx.State = Data
 			break
 		}
@@ -804,7 +1044,7 @@ func handleIfConditionalExpression(ctx *parseContext) error {
 			return parseErr(ctx, "invalid if conditional expression: "+str)
 		}
 		for key, typ := range types {
-			err := ctx.Document.AddDeclaredType(key, typ)
+			err := declareType(ctx, key, typ)
 			if err != nil {
 				return parseErr(ctx, err.Error())
 			}
@@ -845,7 +1085,7 @@ func handleElseConditionalExpression(ctx *parseContext) error {
 			return parseErr(ctx, "invalid else conditional expression: "+str)
 		}
 		for key, typ := range types {
-			err := ctx.Document.AddDeclaredType(key, typ)
+			err := declareType(ctx, key, typ)
 			if err != nil {
 				return parseErr(ctx, err.Error())
 			}
@@ -866,15 +1106,16 @@ func handleElseConditionalExpression(ctx *parseContext) error {
 
 func handleForLoopExpression(ctx *parseContext) error {
 	r := ctx.Rune
+	content := ctx.Buf.String()
 	switch {
-	case r == '}':
-		content := ctx.Buf.String()
+	// an object type in the annotation contains braces itself
+	case r == '}' && strings.Count(content, "{") <= strings.Count(content, "}"):
 		matches := _forLoopRegex.FindStringSubmatch(content)
-		if len(matches) != 5 {
+		if len(matches) != 6 {
 			return parseErr(ctx, "invalid for loop expression: "+content)
 		}
 
-		// i, item in items
+		// i, item in items key item.id
 		indexKey := matches[1]
 		itemKey := matches[2]
 		collectionKey := matches[3]
@@ -883,14 +1124,16 @@ func handleForLoopExpression(ctx *parseContext) error {
 			return parseErr(ctx, "invalid for loop expression type: "+matches[4])
 		}
 		if ok {
-			err := ctx.Document.AddDeclaredType(collectionKey, typ)
+			err := declareType(ctx, collectionKey, typ)
 			if err != nil {
 				return parseErr(ctx, err.Error())
 			}
 		}
 		expr := nodes.NewLoopBlock(indexKey, itemKey, collectionKey, typ)
+		expr.SetKey(matches[5])
 		ctx.Parent.Append(expr)
 		ctx.Parent = expr
+		ctx.Scope = ctx.Scope.enterLoop(indexKey, itemKey, ctx.Scope.lookupType(collectionKey, ctx.Document))
 		ctx.Buf.Reset()
 		ctx.State = Data
 	default:
@@ -899,21 +1142,158 @@ func handleForLoopExpression(ctx *parseContext) error {
 	return nil
 }
 
+func handleTypeDeclarationExpression(ctx *parseContext) error {
+	r := ctx.Rune
+	content := ctx.Buf.String()
+	// the declared type is an object type and contains braces itself
+	if r != '}' || strings.Count(content, "{") > strings.Count(content, "}") {
+		ctx.Buf.WriteRune(r)
+		return nil
+	}
+
+	// LineItem = {sku: string, qty: int}
+	matches := _typeDeclarationRegex.FindStringSubmatch(content)
+	if len(matches) != 3 {
+		return parseErr(ctx, "invalid type declaration: "+content)
+	}
+	name := matches[1]
+	if !unicode.IsUpper([]rune(name)[0]) {
+		return parseErr(ctx, "type name must start with an upper case letter: "+name)
+	}
+	typ, ok := expressions.ParseExpressionType(matches[2])
+	if !ok {
+		return parseErr(ctx, "invalid type declaration type: "+matches[2])
+	}
+	err := ctx.Document.AddNamedType(name, typ)
+	if err != nil {
+		return parseErr(ctx, err.Error())
+	}
+	ctx.Annotations = append(ctx.Annotations, typ)
+
+	ctx.Buf.Reset()
+	ctx.State = Data
+	return nil
+}
+
+func handlePropsExpression(ctx *parseContext) error {
+	r := ctx.Rune
+	content := ctx.Buf.String()
+	// object types contain braces themselves
+	if r != '}' || strings.Count(content, "{") > strings.Count(content, "}") {
+		ctx.Buf.WriteRune(r)
+		return nil
+	}
+	ctx.Buf.Reset()
+	return applyProps(ctx, content)
+}
+
+// applyProps declares the props of the document. The props block must come
+// before any content.
+func applyProps(ctx *parseContext, content string) error {
+	if ctx.Parent != ctx.Document {
+		return parseErr(ctx, "props must be declared at the top level")
+	}
+	for _, child := range ctx.Document.Children() {
+		if child.Name() == "#comment" {
+			continue
+		}
+		text, ok := child.(nodes.TextNode)
+		if !ok || strings.TrimSpace(text.TextContent()) != "" {
+			return parseErr(ctx, "props must be declared before any content")
+		}
+	}
+
+	var props []expressions.Prop
+	var err error
+	if ref, ok := propsSource(content); ok {
+		props, err = loadProps(ctx, ref)
+		if err != nil {
+			return parseErr(ctx, err.Error())
+		}
+	} else {
+		props, err = expressions.ParseProps(content)
+		if err != nil {
+			return parseErr(ctx, "invalid props: "+err.Error())
+		}
+	}
+	err = ctx.Document.SetProps(props, ctx.Start)
+	if err != nil {
+		return parseErr(ctx, err.Error())
+	}
+	for _, prop := range props {
+		ctx.Annotations = append(ctx.Annotations, prop.Type)
+	}
+	ctx.State = Data
+	return nil
+}
+
+// propsSource returns the model referenced by {props from "..."}.
+func propsSource(content string) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(content), "from")
+	if !ok || rest == "" || !unicode.IsSpace(rune(rest[0])) {
+		return "", false
+	}
+	ref, ok := expressions.StringLiteral(strings.TrimSpace(rest))
+	return ref, ok
+}
+
+// loadProps loads the props of the template from a JSON Schema file or a Go
+// struct type, and declares the object types they reference.
+func loadProps(ctx *parseContext, ref string) ([]expressions.Prop, error) {
+	dir := ctx.Options.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	var props []expressions.Prop
+	var namedTypes map[string]expressions.ExpressionType
+	if strings.HasSuffix(ref, ".json") {
+		file := ref
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		model, err := jsonschema.Load(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid props: %s: %w", ref, err)
+		}
+		props, namedTypes = model.Props, model.NamedTypes
+	} else {
+		model, err := gomodel.Load(dir, ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid props: %w", err)
+		}
+		props, namedTypes = model.Props, model.NamedTypes
+	}
+
+	names := make([]string, 0, len(namedTypes))
+	for name := range namedTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := ctx.Document.AddNamedType(name, namedTypes[name])
+		if err != nil {
+			return nil, err
+		}
+		ctx.Annotations = append(ctx.Annotations, namedTypes[name])
+	}
+	return props, nil
+}
+
 func handleOutputExpressionKey(ctx *parseContext) error {
 	r := ctx.Rune
 	switch {
-	case unicode.IsSpace(r):
-		break
-	case r == ':':
+	case r == ':' && !strings.ContainsFunc(strings.TrimSpace(ctx.Buf.String()), unicode.IsSpace):
 		// put buffer content into temp buffer
 		// temp will contain the variable/output key name
-		ctx.Temp.Write(ctx.Buf.Bytes())
+		ctx.Temp.WriteString(strings.TrimSpace(ctx.Buf.String()))
 		ctx.Buf.Reset()
 		ctx.State = OutputExpressionType
 	case r == '}':
-		key := ctx.Temp.String()
-		expr := nodes.NewOutputExpression(key, nil)
-		ctx.Parent.Append(expr)
+		err := applyOutput(ctx, ctx.Buf.String())
+		if err != nil {
+			return err
+		}
 		ctx.Buf.Reset()
 		ctx.State = Data
 	default:
@@ -925,26 +1305,27 @@ func handleOutputExpressionKey(ctx *parseContext) error {
 func handleOutputExpressionType(ctx *parseContext) error {
 	r := ctx.Rune
 	switch {
-	case unicode.IsSpace(r):
-		break
 	case r == '}':
-		if ctx.Buf.Len() == 0 {
+		typ := strings.TrimSpace(ctx.Buf.String())
+		if typ == "" {
 			return parseErr(ctx, "invalid output expression type: empty")
 		}
 
 		key := ctx.Temp.String()
-		typ, ok := expressions.ParseExpressionType(ctx.Buf.String())
+		_, ok := expressions.ParseExpressionType(typ)
 		if !ok {
-			return parseErr(ctx, "invalid output expression type: "+ctx.Buf.String())
-		}
-		if ok {
-			err := ctx.Document.AddDeclaredType(key, typ)
+			// the type may be followed by the rest of an expression,
+			// e.g. nickname: string? ?? "anonymous"
+			_, _, err := expressions.ParseBooleanExpression(key + ":" + typ)
 			if err != nil {
-				return parseErr(ctx, err.Error())
+				return parseErr(ctx, "invalid output expression type: "+typ)
 			}
 		}
-		expr := nodes.NewOutputExpression(key, typ)
-		ctx.Parent.Append(expr)
+		// the key may itself be an expression, e.g. nickname??name: string
+		err := applyOutput(ctx, key+":"+typ)
+		if err != nil {
+			return err
+		}
 		ctx.Temp.Reset()
 		ctx.Buf.Reset()
 		ctx.State = Data
@@ -954,10 +1335,76 @@ func handleOutputExpressionType(ctx *parseContext) error {
 	return nil
 }
 
+// applyOutput appends an output block for content, which is either a single
+// key or an expression such as nickname ?? name.
+func applyOutput(ctx *parseContext, content string) error {
+	expr, types, err := expressions.ParseBooleanExpression(content)
+	if err != nil {
+		return parseErr(ctx, "invalid output expression: "+content)
+	}
+	for key, typ := range types {
+		err := declareType(ctx, key, typ)
+		if err != nil {
+			return parseErr(ctx, err.Error())
+		}
+	}
+
+	var output nodes.OutputBlock
+	if expr.Literal() != "" {
+		output = nodes.NewOutputExpression(expr.Literal(), expr.ExpressionType())
+	} else {
+		output = nodes.NewComputedOutputExpression(expr)
+	}
+	if ctx.Raw {
+		output.SetRaw(true)
+		ctx.Raw = false
+	}
+	ctx.Parent.Append(output)
+	return nil
+}
+
+// declareType records a type annotation. Annotations of loop variables are
+// checked against the type derived from the loop's collection and never
+// become part of the document's model, neither do annotations of members.
+func declareType(ctx *parseContext, key string, typ expressions.ExpressionType) error {
+	ctx.Annotations = append(ctx.Annotations, typ)
+	name, member := rootIdentifier(key)
+	if member {
+		// checked against the type of the root once the document is parsed
+		return nil
+	}
+	declared, ok := ctx.Scope.lookup(name)
+	if !ok && ctx.Document.Strict() {
+		// the props block is the template's contract
+		prop, declared := ctx.Document.GetDeclaredTypes()[name]
+		if !declared {
+			return fmt.Errorf("%s is not declared in props", name)
+		}
+		if !prop.Equals(typ) {
+			return fmt.Errorf("%s is declared in props as %s at %s and annotated as %s at %s",
+				name, prop.String(), ctx.Document.Declarations(name)[0].Position, typ.String(), ctx.Start)
+		}
+		return nil
+	}
+	if !ok {
+		return ctx.Document.AddDeclaredType(key, typ, ctx.Start)
+	}
+	if declared == nil {
+		ctx.Scope.refine(name, typ)
+		return nil
+	}
+	if !declared.Equals(typ) {
+		return fmt.Errorf("%s is a loop variable of type %s", name, declared.String())
+	}
+	return nil
+}
+
 func debugInfo(ctx *parseContext) string {
 	info := map[string]string{
 		"rune":     string(ctx.Rune),
 		"position": strconv.Itoa(ctx.Position),
+		"line":     strconv.Itoa(ctx.Line),
+		"column":   strconv.Itoa(ctx.Column),
 		"buf":      ctx.Buf.String(),
 		"temp":     ctx.Temp.String(),
 		"state":    string(ctx.State),
@@ -1045,7 +1492,7 @@ func applyAttr(ctx *parseContext, isExpression bool) error {
 		}
 		t.attributes.SetAttribute(name, attr)
 		if attr.ExpressionType() != nil {
-			err := ctx.Document.AddDeclaredType(attr.Key(), attr.ExpressionType())
+			err := declareType(ctx, attr.Key(), attr.ExpressionType())
 			if err != nil {
 				return parseErr(ctx, err.Error())
 			}
@@ -1058,7 +1505,7 @@ func applyAttr(ctx *parseContext, isExpression bool) error {
 		}
 		t.attributes.SetAttribute(name, attr)
 		for key, typ := range attr.DeclaredTypes() {
-			err := ctx.Document.AddDeclaredType(key, typ)
+			err := declareType(ctx, key, typ)
 			if err != nil {
 				return parseErr(ctx, err.Error())
 			}
@@ -1070,6 +1517,11 @@ func applyAttr(ctx *parseContext, isExpression bool) error {
 }
 
 func Parse(reader io.RuneReader) (nodes.Document, error) {
+	return ParseWithOptions(reader, Options{})
+}
+
+// ParseWithOptions parses a template like Parse, configured by options.
+func ParseWithOptions(reader io.RuneReader, options Options) (nodes.Document, error) {
 	document := nodes.NewDocument()
 
 	ctx := &parseContext{
@@ -1077,6 +1529,11 @@ func Parse(reader io.RuneReader) (nodes.Document, error) {
 		Parent:   document,
 		Tag:      nil,
 		Document: document,
+		Scope:    newScope(nil),
+		Options:  options,
+		Line:     1,
+		Column:   1,
+		Binds:    map[string][]bindSite{},
 	}
 
 	err := func() (e error) {
@@ -1099,10 +1556,21 @@ func Parse(reader io.RuneReader) (nodes.Document, error) {
 			ctx.Position = i
 			// fmt.Println(debugInfo(ctx))
 
+			state := ctx.State
 			err = _parseStateHandlers[ctx.State](ctx)
 			if err != nil {
 				return err
 			}
+			if !_declarationStates[state] && _declarationStates[ctx.State] {
+				ctx.Start = nodes.Position{Line: ctx.Line, Column: ctx.Column}
+			}
+
+			if r == '\n' {
+				ctx.Line++
+				ctx.Column = 1
+			} else {
+				ctx.Column++
+			}
 		}
 
 		return nil
@@ -1118,5 +1586,12 @@ func Parse(reader io.RuneReader) (nodes.Document, error) {
 		ctx.Buf.Reset()
 	}
 
+	err = checkTypes(document, ctx.Annotations)
+	if err != nil {
+		return nil, parseErr(ctx, err.Error())
+	}
+
+	inferTypes(document)
+
 	return document, nil
 }
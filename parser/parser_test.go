@@ -3,6 +3,7 @@ package parser
 import (
 	"bytes"
 	"guts/parser/expressions"
+	"guts/parser/nodes"
 	"os"
 	"strings"
 	"testing"
@@ -139,6 +140,22 @@ func TestParseErrors(t *testing.T) {
 			name:    "invalid markup declaration",
 			html:    `<!notadoctype>`,
 			message: "invalid markup declaration",
+		}, {
+			name:    "raw block",
+			html:    `{raw if ready}<p>ready</p>{/if}`,
+			message: "raw can only be applied to an output: if",
+		}, {
+			name:    "duplicate bind",
+			html:    "<button {bind:save}>Save</button>\n<a {bind:save}>Save</a>",
+			message: "save is bound at 1:9 and 2:4",
+		}, {
+			name:    "bind in a branch and after the conditional",
+			html:    "{if a}<button {bind:save}>A</button>{else}<button {bind:save}>B</button>{/if}\n<a {bind:save}>C</a>",
+			message: "save is bound at 1:15 and 2:4",
+		}, {
+			name:    "bind in a nested conditional",
+			html:    "{if a}<button {bind:save}>A</button>{if b}<a {bind:save}>B</a>{/if}{/if}",
+			message: "save is bound at 1:15 and 1:46",
 		},
 	}
 
@@ -166,15 +183,15 @@ func TestParseExpressions(t *testing.T) {
 			html:     `<p>Hello, {name: string}!</p>`,
 			expected: `<p>Hello, {name:string}!</p>`,
 			types: map[string]expressions.ExpressionType{
-				"name": expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
+				"name": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
 			},
 		}, {
 			name:     "emit value again without redeclaration",
 			html:     `<p>The name is {lastName: string}, {firstName: string} {lastName}.</p>`,
 			expected: `<p>The name is {lastName:string}, {firstName:string} {lastName}.</p>`,
 			types: map[string]expressions.ExpressionType{
-				"lastName":  expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
-				"firstName": expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
+				"lastName":  expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+				"firstName": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
 			},
 		}, {
 			name: "full attribute",
@@ -185,8 +202,8 @@ func TestParseExpressions(t *testing.T) {
 				<img src={imgSrc:string} alt={imgAlt:string}>
 			</div>`,
 			types: map[string]expressions.ExpressionType{
-				"imgSrc": expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
-				"imgAlt": expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
+				"imgSrc": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+				"imgAlt": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
 			},
 		}, {
 			name: "partial attribute",
@@ -197,8 +214,8 @@ func TestParseExpressions(t *testing.T) {
 				<img src={imgSrc:string} alt="A photo of {name:string}">
 			</div>`,
 			types: map[string]expressions.ExpressionType{
-				"imgSrc": expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
-				"name":   expressions.NewExpressionType(expressions.ExpressionBaseTypeString, "", expressions.ExpressionBaseTypeString),
+				"imgSrc": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+				"name":   expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
 			},
 		}, {
 			name: "spread attributes",
@@ -209,8 +226,19 @@ func TestParseExpressions(t *testing.T) {
 				<img {...attrs:map[string,string]}>
 			</div>`,
 			types: map[string]expressions.ExpressionType{
-				"attrs": expressions.NewExpressionType(expressions.ExpressionBaseTypeMap, expressions.ExpressionBaseTypeString, expressions.ExpressionBaseTypeString),
+				"attrs": expressions.NewMapType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+			},
+		}, {
+			name:     "emit value with default",
+			html:     `<p>Hello, {nickname??name: string}!</p>`,
+			expected: `<p>Hello, {nickname ?? name:string}!</p>`,
+			types: map[string]expressions.ExpressionType{
+				"nickname": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString).WithOptional(true),
+				"name":     expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
 			},
+		}, {
+			name: "emit optional chain",
+			html: `<p>Ships to {user?.address?.city}</p>`,
 		}, {
 			name: "simple if",
 			html: `<div>
@@ -225,7 +253,7 @@ func TestParseExpressions(t *testing.T) {
 				{if qty:int > 0}You have {qty} item(s).{/if}
 			</div>`,
 			types: map[string]expressions.ExpressionType{
-				"qty": expressions.NewExpressionType(expressions.ExpressionBaseTypeInt, "", expressions.ExpressionBaseTypeInt),
+				"qty": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt),
 			},
 		}, {
 			name: "if...else (1)",
@@ -269,7 +297,22 @@ func TestParseExpressions(t *testing.T) {
 				{/for}
 			</ul>`,
 			types: map[string]expressions.ExpressionType{
-				"items": expressions.NewExpressionType(expressions.ExpressionBaseTypeArray, expressions.ExpressionBaseTypeInt, expressions.ExpressionBaseTypeString),
+				"items": expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+			},
+		}, {
+			name: "keyed for loop",
+			html: `<ul>
+				{for i, item in items: Item[] key item.id}
+					<li>{item.name}</li>
+				{/for}
+			</ul>{type Item = {id: int, name: string}}`,
+			expected: `<ul>
+				{for i, item in items:Item[] key item.id}
+					<li>{item.name}</li>
+				{/for}
+			</ul>`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewNamedType("Item")),
 			},
 		}, {
 			name: "for loop without type declaration",
@@ -300,6 +343,41 @@ func TestParseExpressions(t *testing.T) {
 					}
 				</script>
 			</div>`,
+		}, {
+			name:     "bind in branches of a conditional",
+			html:     `{if a}<button {bind:save}>A</button>{else if b}<a {bind:save}>B</a>{else}<p>{if c}<button {bind:save}>C</button>{/if}</p>{/if}`,
+			expected: `{if a}<button data-bind-id="save">A</button>{else if b}<a data-bind-id="save">B</a>{else}<p>{if c}<button data-bind-id="save">C</button>{/if}</p>{/if}`,
+		}, {
+			name:     "raw output",
+			html:     `<article>{raw body: string}</article><p>{raw: string}</p>`,
+			expected: `<article>{raw body:string}</article><p>{raw:string}</p>`,
+			types: map[string]expressions.ExpressionType{
+				"body": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+				"raw":  expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+			},
+		}, {
+			name: "template literals in script",
+			html: "<script>const s = `${a}, ${b.c}`;</script>",
+		}, {
+			name:     "outputs in script and style",
+			html:     `<script>const config = {=config: string}; if (ready) { start({a, b}); }</script><style>p { color: {accent: string}; }</style><script type="ts">const {a} = b;</script>`,
+			expected: `<script>const config = {= config:string}; if (ready) { start({a, b}); }</script><style>p { color: {accent:string}; }</style><script type="ts">const {a} = b;</script>`,
+			types: map[string]expressions.ExpressionType{
+				"accent": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+				"config": expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString),
+			},
+		}, {
+			name:  "destructuring in script",
+			html:  `<script>const {a} = obj; const [{b}] = list; function f({c, d}) {}</script>`,
+			types: map[string]expressions.ExpressionType{},
+		}, {
+			name:  "imports in script",
+			html:  `<script type="module">import {foo} from "./x"; import def, {bar as baz} from "./y"; export {foo};</script>`,
+			types: map[string]expressions.ExpressionType{},
+		}, {
+			name:  "object literals in script",
+			html:  `<script>start({a}); const o = {b: 1, c}; if (ready) {go()}</script>`,
+			types: map[string]expressions.ExpressionType{},
 		},
 	}
 
@@ -338,3 +416,51 @@ func TestParseExpressions(t *testing.T) {
 		})
 	}
 }
+
+func TestDeclarationSites(t *testing.T) {
+	document, err := Parse(strings.NewReader(`<div title="{qty: int} items">
+	<p>{qty: int}</p>
+	{if qty: int? > 1}many{/if}
+</div>`))
+	assert.NoError(t, err)
+	if document == nil {
+		return
+	}
+	assert.Equal(t, []nodes.Declaration{
+		{Type: _intType, Position: nodes.Position{Line: 1, Column: 12}},
+		{Type: _intType, Position: nodes.Position{Line: 2, Column: 5}},
+		{Type: _intType.WithOptional(true), Position: nodes.Position{Line: 3, Column: 2}},
+	}, document.Declarations("qty"))
+	assert.True(t, _intType.WithOptional(true).Equals(document.GetDeclaredTypes()["qty"]))
+
+	tests := []struct {
+		name    string
+		html    string
+		message string
+	}{
+		{
+			name: "conflicting outputs",
+			html: `<p>{qty: int}</p>
+<p>
+	{qty: string}
+</p>`,
+			message: "qty is declared as int at 1:4 and as string at 3:2",
+		}, {
+			name:    "conflicting attribute and condition",
+			html:    `<a href={url: string}>{if url: bool}link{/if}</a>`,
+			message: "url is declared as string at 1:9 and as bool at 1:23",
+		}, {
+			name:    "conflicting loop",
+			html:    "{items: map[string,int]}\n{for i, item in items: int[]}{item}{/for}",
+			message: "items is declared as map[string,int] at 1:1 and as int[] at 2:1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.html))
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"guts/parser/expressions"
+	"guts/parser/nodes"
+)
+
+// scope is a lexical scope introduced by a loop block. Symbols declared in a
+// scope are visible to the loop's children only and never become part of the
+// document's model. A nil symbol type means the type is not known.
+type scope struct {
+	parent  *scope
+	symbols map[string]expressions.ExpressionType
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		parent:  parent,
+		symbols: make(map[string]expressions.ExpressionType),
+	}
+}
+
+// enterLoop returns the scope for the children of a loop over a collection of
+// the given type. Arrays are indexed by int, maps by their key type.
+func (s *scope) enterLoop(indexKey, valueKey string, collection expressions.ExpressionType) *scope {
+	inner := newScope(s)
+
+	var indexType, valueType expressions.ExpressionType
+	if collection != nil {
+		switch collection.BaseType() {
+		case expressions.ExpressionBaseTypeArray, expressions.ExpressionBaseTypeMap:
+			indexType = collection.KeyType()
+			valueType = collection.ValueType()
+		}
+	}
+
+	inner.symbols[indexKey] = indexType
+	inner.symbols[valueKey] = valueType
+	return inner
+}
+
+// lookup finds the symbol name in this scope or any enclosing scope.
+func (s *scope) lookup(name string) (expressions.ExpressionType, bool) {
+	for current := s; current != nil; current = current.parent {
+		if typ, ok := current.symbols[name]; ok {
+			return typ, true
+		}
+	}
+	return nil, false
+}
+
+// lookupType returns the type of a loop variable of s, or else of a field of
+// the model of document, or nil.
+func (s *scope) lookupType(name string, document nodes.Document) expressions.ExpressionType {
+	if typ, ok := s.lookup(name); ok {
+		return typ
+	}
+	return document.GetDeclaredTypes()[name]
+}
+
+// refine sets the type of a symbol whose type was not known.
+func (s *scope) refine(name string, typ expressions.ExpressionType) {
+	for current := s; current != nil; current = current.parent {
+		if _, ok := current.symbols[name]; ok {
+			current.symbols[name] = typ
+			return
+		}
+	}
+}
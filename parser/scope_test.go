@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"guts/parser/expressions"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoopScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		types    map[string]expressions.ExpressionType
+		warnings []string
+		message  string
+	}{
+		{
+			name: "annotated loop variable does not leak into the model",
+			html: `{for i, item in items: string[]}<li data-index={i: int}>{item: string}</li>{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+			},
+		}, {
+			name: "map loop variables",
+			html: `{for key, count in counts: map[string,int]}{if count > 1}{key: string}{/if}{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"counts": expressions.NewMapType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)),
+			},
+		}, {
+			name: "loop variable of untyped collection",
+			html: `{for i, item in items}{item: string}{/for}{items: string[]}`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+			},
+		}, {
+			name: "loop variable used as a free variable after the loop",
+			html: `{for i, item in items: string[]}{item}{/for}{item: string}`,
+			types: map[string]expressions.ExpressionType{
+				"items": expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+				"item":  _stringType,
+			},
+			warnings: []string{"item: loop variable shadows model field item"},
+		}, {
+			name: "nested loop shadows enclosing loop variable",
+			html: `{for i, row in rows: string[]}{for i, cell in cells: string[]}{cell}{/for}{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"rows":  expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+				"cells": expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString)),
+			},
+			warnings: []string{"i: loop variable shadows loop variable i of an enclosing loop"},
+		}, {
+			name: "loop over a nested collection",
+			html: `{for i, row in rows: int[][]}{for j, cell in row}{if cell: int > 0}{cell}{/if}{/for}{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"rows": expressions.NewArrayType(expressions.NewArrayType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt))),
+			},
+		}, {
+			name: "loop over string literal unions and objects",
+			html: `{for i, status in statuses: ("open" | "closed")[]}{status}{/for}{for i, point in points: {x: int, y: int}[] key point.x}{point.y}{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"statuses": expressions.NewArrayType(expressions.NewUnionType([]expressions.ExpressionType{expressions.NewLiteralType("open"), expressions.NewLiteralType("closed")})),
+				"points": expressions.NewArrayType(expressions.NewObjectType([]expressions.ObjectField{
+					{Name: "x", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)},
+					{Name: "y", Type: expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)},
+				})),
+			},
+		}, {
+			name: "keyed loops",
+			html: `{type Row = {id: string, kind: "a" | "b"}}{for i, row in rows: Row[] key row.id}{row.kind}{/for}{for key, count in counts: map[string,int] key key}{count}{/for}`,
+			types: map[string]expressions.ExpressionType{
+				"rows":   expressions.NewArrayType(expressions.NewNamedType("Row")),
+				"counts": expressions.NewMapType(expressions.NewPrimitiveType(expressions.ExpressionBaseTypeString), expressions.NewPrimitiveType(expressions.ExpressionBaseTypeInt)),
+			},
+		}, {
+			name:    "loop key of another variable",
+			html:    `{for i, item in items: string[] key id}{item}{/for}`,
+			message: "loop key id is not i, item or one of their fields",
+		}, {
+			name:    "loop key of a float",
+			html:    `{type Point = {x: float}}{for i, point in points: Point[] key point.x}{i}{/for}`,
+			message: "loop key point.x of type float is neither a string nor an int",
+		}, {
+			name:    "optional loop key",
+			html:    `{for i, item in items: int?[] key item}{i}{/for}`,
+			message: "loop key item of type int? is neither a string nor an int",
+		}, {
+			name:    "annotation conflicts with nested element type",
+			html:    `{for i, row in rows: map[string,int[]]}{for j, cell in row}{cell: string}{/for}{/for}`,
+			message: "cell is a loop variable of type int",
+		}, {
+			name:    "annotation conflicts with element type",
+			html:    `{for i, item in items: string[]}{item: int}{/for}`,
+			message: "item is a loop variable of type string",
+		}, {
+			name:    "annotation conflicts with index type",
+			html:    `{for key, value in values: map[string,int]}{if key: int > 1}{value}{/if}{/for}`,
+			message: "key is a loop variable of type string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := Parse(strings.NewReader(tt.html))
+			if tt.message != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.message)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			if document == nil {
+				return
+			}
+
+			declaredTypes := document.GetDeclaredTypes()
+			assert.Equal(t, len(tt.types), len(declaredTypes), "number of declared types mismatch")
+			for key, expectedType := range tt.types {
+				actualType, exists := declaredTypes[key]
+				assert.True(t, exists, "type for %s should exist", key)
+				if exists {
+					assert.True(t, expectedType.Equals(actualType),
+						"type mismatch for %s: expected %s, got %s",
+						key, expectedType.String(), actualType.String())
+				}
+			}
+			assert.Equal(t, tt.warnings, document.Warnings())
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"guts/parser/nodes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParents(t *testing.T) {
+	document, err := Parse(strings.NewReader(`<ul>{if items}{for i, item in items}<li>{item}</li>{/for}{/if}</ul>`))
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+
+	ul := document.Children()[0]
+	conditional := ul.Children()[0]
+	loop := conditional.Children()[0]
+	li := loop.Children()[0]
+	assert.Equal(t, document, ul.Parent())
+	assert.Equal(t, ul, conditional.Parent())
+	assert.Equal(t, conditional, loop.Parent())
+	assert.Equal(t, loop, li.Parent())
+	assert.Equal(t, li, li.Children()[0].Parent())
+
+	// parents are the blocks and elements themselves, not their embedded nodes
+	_, ok := li.Parent().(nodes.LoopBlock)
+	assert.True(t, ok, "parent of li is %T", li.Parent())
+	_, ok = loop.Parent().(nodes.ConditionalBlock)
+	assert.True(t, ok, "parent of the loop is %T", loop.Parent())
+}